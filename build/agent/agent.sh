@@ -7,11 +7,46 @@ set -o pipefail
 dir=$(cd $(dirname $0) && pwd)
 cd $dir
 
-sudo systemctl stop agent.service || true
+bin=/usr/local/bin/agent
+unit=/usr/lib/systemd/system/agent.service
+dropin=/etc/systemd/system/agent.service.d/port.conf
+# the port the agent listens on, the default of the agent if it's empty
+port=${1:-}
 
-sudo cp agent /usr/local/bin/agent
-sudo cp ./agent.service /usr/lib/systemd/system/agent.service
+# an old agent without -version would start serving, so it's bounded by timeout
+installed=$(timeout 5 ${bin} -version 2>/dev/null || true)
+bundled=$(./agent -version)
+
+if [ "${installed}" != "${bundled}" ]; then
+  echo "install agent ${bundled}, installed: ${installed:-none}"
+  sudo systemctl stop agent.service || true
+  # replace the binary atomically, a running binary can't be written
+  sudo cp agent ${bin}.new
+  sudo mv -f ${bin}.new ${bin}
+fi
+
+if ! sudo cmp -s ./agent.service ${unit}; then
+  sudo cp ./agent.service ${unit}
+  sudo systemctl daemon-reload
+fi
+
+port_changed=false
+if [ -n "${port}" ]; then
+  want=$(printf '[Service]\nEnvironment=AGENT_PORT=%s' "${port}")
+  if [ "$(sudo cat ${dropin} 2>/dev/null || true)" != "${want}" ]; then
+    sudo mkdir -p $(dirname ${dropin})
+    echo "${want}" | sudo tee ${dropin} >/dev/null
+    port_changed=true
+  fi
+elif sudo test -e ${dropin}; then
+  sudo rm -f ${dropin}
+  port_changed=true
+fi
+if ${port_changed}; then
+  sudo systemctl daemon-reload
+fi
 
-sudo systemctl daemon-reload
 sudo systemctl enable agent.service
-sudo systemctl restart agent.service
+if [ "${installed}" != "${bundled}" ] || ${port_changed} || ! sudo systemctl is-active --quiet agent.service; then
+  sudo systemctl restart agent.service
+fi
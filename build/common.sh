@@ -8,8 +8,12 @@ function kube::build::build_image() {
   OUTPUT_DIR=_output
   ROOT_PATH=$(dirname "${BASH_SOURCE[0]}")/..
 
-  cd ${ROOT_PATH}/cmd/agent/ && go build . && cd -
-  cd ${ROOT_PATH}/cmd/apiserver/ && go build . && cd -
+  # the apiserver knows the version of the agent it bootstraps by the same ldflags
+  VERSION=${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo v0.1.0)}
+  LDFLAGS="-X github.com/farmer-hutao/paas-operator/pkg/agent.Version=${VERSION}"
+
+  cd ${ROOT_PATH}/cmd/agent/ && go build -ldflags "${LDFLAGS}" . && cd -
+  cd ${ROOT_PATH}/cmd/apiserver/ && go build -ldflags "${LDFLAGS}" . && cd -
 
   cp ${ROOT_PATH}/cmd/agent/agent ${ROOT_PATH}/build/agent/
   cp ${ROOT_PATH}/cmd/apiserver/apiserver ${ROOT_PATH}/build/apiserver/docker/
@@ -1,11 +1,46 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"log"
+
 	"github.com/farmer-hutao/paas-operator/pkg/agent"
 )
 
 func main() {
+	version := flag.Bool("version", false, "print the version and exit")
+	relay := flag.Bool("relay", false, "run as the relay of a network zone instead of an agent, see AGENT_RELAY_*")
+	upgradeWatch := flag.String("upgrade-watch", "", "watch the upgrade to the version, only run by the agent itself")
+	flag.Parse()
+	if *version {
+		fmt.Println(agent.Version)
+		return
+	}
+	if *upgradeWatch != "" {
+		if err := agent.WatchUpgrade(*upgradeWatch); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *relay {
+		log.Fatal(agent.RunRelay())
+	}
+
 	app := agent.NewGinEngine()
+	// the grpc api of protocol v1 is served next to the json api during the transition
+	go func() {
+		log.Printf("Error: grpc api stopped: %s", agent.ServeGRPC())
+	}()
 	go agent.TryCheck()
-	app.Run(":3335")
+	go agent.Heartbeat()
+	switch agent.Mode {
+	case agent.ModePull:
+		// actions are polled from the apiserver, the api is kept for local use
+		go agent.PollOperations()
+	case agent.ModeTunnel:
+		// actions come through the websocket opened by the agent
+		go agent.Tunnel(app)
+	}
+	app.Run(":" + agent.Port)
 }
@@ -3,11 +3,9 @@ package agent
 import (
 	"bufio"
 	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -18,11 +16,10 @@ import (
 	"sync"
 	"time"
 
-	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils"
 	"github.com/gin-gonic/gin"
-)
 
-var once = &sync.Once{}
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+)
 
 func NewGinEngine() *gin.Engine {
 	r := gin.New()
@@ -46,19 +43,31 @@ func NewGinEngine() *gin.Engine {
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "pong",
+			"version": Version,
+			"mode":    Mode,
 		})
 	})
 
 	// action can only be [ install, start, stop, restart, uninstall ]
 	r.POST("/:action", DoAction)
+
+	// the apiserver tells the agent how to reach it when onboarding the host
+	r.PUT("/node", SetNode)
+
+	// the apiserver learns the version and capabilities of the agent
+	r.GET("/discovery", Discover)
+
+	// the apiserver upgrades the agent to another version
+	r.PUT("/upgrade", Upgrade)
 	return r
 }
 
 // DoAction knows how to judge the script to be executed
 // according to the Action
 
-// if ok, return 200 and {"msg":"ok"}
-// if some error occer, return not200 and {"error":"detail info"}
+// if ok, return 200 and {"msg":"ok","result":"ok","exit_code":0}
+// if some error occer, return not200 and {"error":"detail info","result":"failed","exit_code":1}
+// result is derived from the exit code of the script, see ResultOfExitCode
 func DoAction(c *gin.Context) {
 	// validate action
 	action := c.Param("action")
@@ -81,208 +90,214 @@ func DoAction(c *gin.Context) {
 	log.Println("Action: " + action)
 	log.Println("AppInfo: " + appInfo.Print())
 
-	// doAction get the Action & AppInfo, then exec a corresponding script.
-	doAction := func(action Action, appInfo *AppInfo) (*bytes.Buffer, error) {
-		// eg. [ install.sh, start.sh, ... ]
-		var scriptName string
-		var repoUrl = appInfo.RepoURL
-
-		switch action {
-		case Install:
-			scriptName = appInfo.Install
-		case Start:
-			scriptName = appInfo.Start
-		case Stop:
-			scriptName = appInfo.Stop
-		case Restart:
-			scriptName = appInfo.Restart
-		case Uninstall:
-			scriptName = appInfo.Uninstall
-		case Check:
-			scriptName = appInfo.Check
-		}
-
-		//validate scriptName
-		if len(scriptName) < 1 {
-			return nil, errors.New("script name illegal: " + scriptName)
-		}
-
-		// prepare the script
-		scriptPath, err := getScriptIfNotExist(scriptName, repoUrl)
-		if err != nil {
-			log.Println("Get script failed: " + err.Error())
-			return nil, err
-		}
-
-		// prepare args for script
-		var args string
-		for k, v := range appInfo.Metadata {
-			args += k + "=" + v + " "
-		}
-
-		if action == Check {
-			check(appInfo.Name, appInfo.Type, appInfo.OperatorIp, appInfo.OperatorPort, WorkDir, scriptPath, args)
-			return nil, nil
-		}
-
-		// exec the script
-		err = execInSystem(WorkDir, []string{scriptPath, args}, nil, true)
-		if err != nil {
-			return nil, err
-		}
-		return nil, nil
-	}
+	rememberNodeOf(&appInfo)
 
-	_, err := doAction(Action(action), &appInfo)
+	result, err := doAction(Action(action), &appInfo, nil)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
+			"error":     err.Error(),
+			"result":    result,
+			"exit_code": exitCodeOf(err),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"msg": "ok",
+		"msg":       "ok",
+		"result":    result,
+		"exit_code": ExitOK,
 	})
 }
 
-type CheckArg struct {
-	Name         string `json:"name"`
-	AppType      string `json:"apptype"`
-	OperatorIp   string `json:"operatorip"`
-	OperatorPort string `json:"operatorport"`
-	WorkDir      string `json:"workdir"`
-	ScriptPath   string `json:"scriptpath"`
-	Args         string `json:"args"`
+// rememberNodeOf remembers the apiserver which sent the action, heartbeats go to it
+func rememberNodeOf(appInfo *AppInfo) {
+	if appInfo.OperatorIp == "" || appInfo.OperatorPort == "" {
+		return
+	}
+	info := &NodeInfo{
+		OperatorIp:       appInfo.OperatorIp,
+		OperatorPort:     appInfo.OperatorPort,
+		HostIP:           appInfo.HostIP,
+		RelayToken:       appInfo.RelayToken,
+		OperatorGRPCPort: appInfo.OperatorGRPCPort,
+	}
+	if err := rememberNode(info); err != nil {
+		log.Printf("Save node info failed: %s", err)
+	}
+}
+
+// actionEvents is told the steps of an action and the lines printed by its script,
+// the grpc api streams them to the apiserver. A nil one drops them.
+type actionEvents struct {
+	progress func(step agentpb.Progress_Step, msg string)
+	output   func(stream agentpb.Output_Stream, line string)
+}
+
+func (e *actionEvents) step(step agentpb.Progress_Step, msg string) {
+	if e != nil && e.progress != nil {
+		e.progress(step, msg)
+	}
+}
+
+func (e *actionEvents) outputFunc() func(stream agentpb.Output_Stream, line string) {
+	if e == nil {
+		return nil
+	}
+	return e.output
+}
+
+// codedError tells why an action failed before its script ran, see errorCodeOf
+type codedError struct {
+	code agentpb.Error_Code
+	error
+}
+
+func invalidArgument(err error) error {
+	return &codedError{code: agentpb.Error_INVALID_ARGUMENT, error: err}
+}
+
+func downloadFailed(err error) error {
+	return &codedError{code: agentpb.Error_DOWNLOAD_FAILED, error: err}
 }
 
-func check(name, appType, operatorIp, operatorPort, workdir, scriptPath, args string) {
-	// save all func args to checkInfo.json
-	var ca = CheckArg{
-		Name:         name,
-		AppType:      appType,
-		OperatorIp:   operatorIp,
-		OperatorPort: operatorPort,
-		WorkDir:      workdir,
-		ScriptPath:   scriptPath,
-		Args:         args,
+// errorCodeOf return the code of an error returned by doAction
+func errorCodeOf(err error) agentpb.Error_Code {
+	if codedErr, ok := err.(*codedError); ok {
+		return codedErr.code
+	}
+	if exitCodeOf(err) > 0 {
+		return agentpb.Error_SCRIPT_FAILED
 	}
-	caBytes, err := json.Marshal(ca)
+	return agentpb.Error_SCRIPT_ABORTED
+}
+
+// doAction get the Action & AppInfo, then exec a corresponding script.
+// It is used by DoAction in push mode, by PollOperations in pull mode and by the grpc api,
+// which passes events to stream the progress.
+func doAction(action Action, appInfo *AppInfo, events *actionEvents) (ScriptResult, error) {
+	// eg. [ install.sh, start.sh, ... ]
+	scriptName := appInfo.ScriptName(action)
+
+	// every app version has its own dir, so scripts with the same name never mix
+	appDir, err := appInfo.AppDir()
 	if err != nil {
-		log.Printf("marshal checkInfo.json failed: %s", err)
-	} else {
-		err = ioutil.WriteFile(filepath.Join(WorkDir, "checkInfo.json"), caBytes, 0666)
-		if err != nil {
-			log.Printf("write checkInfo.json failed: %s", err)
-		}
+		return ResultFailed, invalidArgument(err)
 	}
 
-	// prepare to check
-	period := 5 * time.Second
-	var c = &http.Client{}
-
-	report := func(msg string) {
-		// trim "xxx{xxx}xxx" to "{xxx}"
-		trimMsg := func(msg string) string {
-			start := strings.Index(msg, "{")
-			end := strings.LastIndex(msg, "}")
-			if start < 0 || end < 0 {
-				return msg
+	// probes are run by the agent itself, no check script is needed
+	if action == Check && len(appInfo.Probes) > 0 {
+		for i := range appInfo.Probes {
+			if err := appInfo.Probes[i].Validate(); err != nil {
+				return ResultFailed, invalidArgument(err)
 			}
-			return msg[start : end+1]
-		}
-		msg = trimMsg(msg)
-
-		if !utils.ValidateAppHealthyJson(msg) {
-			log.Printf("Error: Json illeagel:<%s>", msg)
-			return
 		}
+		startCheck(CheckArg{
+			Name:         appInfo.Name,
+			AppType:      appInfo.Type,
+			OperatorIp:   appInfo.OperatorIp,
+			OperatorPort: appInfo.OperatorPort,
+			WorkDir:      appDir,
+			Env:          scriptEnv(action, appInfo, appDir),
+			Probes:       appInfo.Probes,
+		})
+		return ResultOK, nil
+	}
 
-		url := fmt.Sprintf("http://%s:%s/apis/v1alpha1/%s/%s/check", operatorIp, operatorPort, appType, name)
-		payload := bytes.NewBufferString(msg)
-		req, err := http.NewRequest("PUT", url, payload)
+	// prepare the script, from the bundle if the app has one
+	var scriptPath string
+	if appInfo.Bundle != nil {
+		events.step(agentpb.Progress_PREPARE_BUNDLE, appInfo.Bundle.Name)
+		manifest, err := prepareBundle(appDir, appInfo)
 		if err != nil {
-			log.Printf("Error: NewRequest failed: %s", err)
-			return
+			log.Println("Prepare bundle failed: " + err.Error())
+			return ResultFailed, downloadFailed(err)
 		}
-		req.Header.Add("Content-Type", "application/json;charset=utf-8")
-		resp, err := c.Do(req)
+		scriptPath, err = manifest.ScriptPath(appDir, action)
 		if err != nil {
-			log.Printf("Error: Do Request failed: %s", err)
-			return
+			return ResultFailed, invalidArgument(err)
 		}
-		if resp.StatusCode != http.StatusAccepted {
-			log.Printf("Error: %s", resp.Status)
-			if period < 1*time.Minute {
-				period += 5 * time.Second
-			}
-			return
+	} else {
+		//validate scriptName
+		if len(scriptName) < 1 {
+			return ResultFailed, invalidArgument(errors.New("script name illegal: " + scriptName))
+		}
+		events.step(agentpb.Progress_DOWNLOAD_SCRIPT, scriptName)
+		scriptPath, err = getScript(appDir, scriptName, appInfo)
+		if err != nil {
+			log.Println("Get script failed: " + err.Error())
+			return ResultFailed, err
 		}
-		period = 5 * time.Second
 	}
 
-	var buf bytes.Buffer
-	once.Do(func() {
-		go func() {
-			for {
-				err := execInSystem(workdir, []string{scriptPath, args}, &buf, false)
-				if err != nil {
-					if period < 1*time.Hour {
-						period *= 2
-					}
-					log.Printf("Exec check cmd failed: %s, wait %ds", err, period/time.Second)
-				} else {
-					report(buf.String())
-				}
-				buf.Reset()
-				time.Sleep(period)
-			}
-		}()
-	})
-}
+	// the package is needed only by install, other scripts find it in the app dir
+	// a bundle may carry the package itself
+	if action == Install && appInfo.Package != "" && !bundleHasPackage(appDir, appInfo) {
+		events.step(agentpb.Progress_DOWNLOAD_PACKAGE, appInfo.Package)
+		if _, err := getPackage(appDir, appInfo); err != nil {
+			log.Println("Get package failed: " + err.Error())
+			return ResultFailed, err
+		}
+	}
 
-func TryCheck() {
-	infoBytes, err := ioutil.ReadFile(filepath.Join(WorkDir, "checkInfo.json"))
-	if err != nil {
-		log.Printf("read checkInfo.json failed: %s; if it's the first time start agent, it's ok.", err)
-		return
+	// metadata and standard variables are passed to the script by environment, never by args
+	env := scriptEnv(action, appInfo, appDir)
+
+	if action == Check {
+		startCheck(CheckArg{
+			Name:         appInfo.Name,
+			AppType:      appInfo.Type,
+			OperatorIp:   appInfo.OperatorIp,
+			OperatorPort: appInfo.OperatorPort,
+			WorkDir:      appDir,
+			ScriptPath:   scriptPath,
+			Env:          env,
+		})
+		return ResultOK, nil
 	}
-	var ca CheckArg
-	err = json.Unmarshal(infoBytes, &ca)
-	if err != nil {
-		log.Printf("Unmarshal checkInfo.json failed: %s", err)
-		return
+
+	// exec the script
+	events.step(agentpb.Progress_RUN_SCRIPT, filepath.Base(scriptPath))
+	err = execInSystem(appDir, []string{scriptPath}, env, nil, true, events.outputFunc())
+	result := ResultOfExitCode(exitCodeOf(err))
+
+	// an uninstalled app needn't be checked any more, and leaves nothing in WorkDir
+	if action == Uninstall && (result == ResultOK || result == ResultNotInstalled) {
+		events.step(agentpb.Progress_CLEAN, appDir)
+		stopCheck(appInfo.Type, appInfo.Name)
+		if err := cleanAppDir(appInfo.Type, appInfo.Name); err != nil {
+			log.Printf("clean dir of <%s> failed: %s", appInfo.Name, err)
+		}
 	}
-	check(ca.Name, ca.AppType, ca.OperatorIp, ca.OperatorPort, ca.WorkDir, ca.ScriptPath, ca.Args)
+	return result, err
 }
 
-// local dir: /opt/app/; local script: /opt/app/xxx.sh
-// origin script: http://xxx:nn/xxx/xxx.sh
-// return /opt/app/xxx.sh, error
-func getScriptIfNotExist(scriptName, repoUrl string) (string, error) {
-	scriptPath := filepath.Join(WorkDir, scriptName)
-	exist, err := pathExists(scriptPath)
-	if err != nil {
-		return "", err
+// getScript makes sure the latest script is in the app dir.
+// local dir: /opt/app/apps/database/mysql/5.7/; local script: /opt/app/apps/database/mysql/5.7/xxx.sh
+// origin script: http://xxx:nn/xxx/xxx.sh, or the same path on any mirror
+// return /opt/app/apps/database/mysql/5.7/xxx.sh, error
+func getScript(appDir, scriptName string, appInfo *AppInfo) (string, error) {
+	if err := validatePathElem(scriptName); err != nil {
+		return "", invalidArgument(err)
 	}
-
-	if exist {
-		return scriptPath, nil
+	scriptPath := filepath.Join(appDir, scriptName)
+	d := NewDownloader(append([]string{appInfo.RepoURL}, appInfo.Mirrors...)...)
+	if _, err := d.Fetch(scriptName, appInfo.Checksums[scriptName], scriptPath); err != nil {
+		return "", downloadFailed(err)
 	}
+	return scriptPath, nil
+}
 
-	// file not exist, do wget
-
-	// ensure the workdir is exist
-	err = os.MkdirAll(WorkDir, os.ModePerm)
-	if err != nil {
-		return "", err
+// getPackage makes sure the package is in the app dir, and return its path.
+func getPackage(appDir string, appInfo *AppInfo) (string, error) {
+	if err := validatePathElem(appInfo.Package); err != nil {
+		return "", invalidArgument(err)
 	}
-	err = execInSystem(WorkDir, []string{"wget", repoUrl + scriptName}, nil, true)
-	if err != nil {
-		log.Println("Wget Failed!!!")
-		return "", err
+	packagePath := filepath.Join(appDir, appInfo.Package)
+	d := NewDownloader(append([]string{appInfo.RepoURL}, appInfo.Mirrors...)...)
+	if _, err := d.Fetch(appInfo.Package, appInfo.Checksums[appInfo.Package], packagePath); err != nil {
+		return "", downloadFailed(err)
 	}
-	return scriptPath, nil
+	return packagePath, nil
 }
 
 // exist -> true; else -> false; if some error occur, return the err
@@ -298,25 +313,25 @@ func pathExists(path string) (bool, error) {
 }
 
 // execInSystem can exec a command with some params in linux/windowns system
-// all log producted by script would be print to stdout and return at logsBuffer if logsBuffer is not nil
-func execInSystem(execPath string, params []string, logsBuffer *bytes.Buffer, print bool) error {
+// all log producted by script would be print to stdout and return at logsBuffer if logsBuffer is not nil,
+// and passed to output line by line if output is not nil
+func execInSystem(execPath string, params []string, env []string, logsBuffer *bytes.Buffer, print bool,
+	output func(stream agentpb.Output_Stream, line string)) error {
 	var lock sync.Mutex
-	var c string
-	var cmdName string
+	var cmd *exec.Cmd
 
 	switch runtime.GOOS {
 	case "linux":
-		c = "-c"
-		cmdName = "sh"
+		cmd = exec.Command("sh", params...)
 	case "windows":
-		c = "/c"
-		cmdName = "cmd"
+		cmd = exec.Command("cmd", append([]string{"/c"}, params...)...)
 	default:
 		log.Panicf("System type error, got <%s>, but expect linux/windowns!", runtime.GOOS)
 	}
 
-	cmd := exec.Command(cmdName, append(params, c)...)
 	cmd.Dir = execPath
+	// env is appended to the agent's environment, a later value overrides an earlier one
+	cmd.Env = append(os.Environ(), env...)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -331,7 +346,7 @@ func execInSystem(execPath string, params []string, logsBuffer *bytes.Buffer, pr
 	// print log
 	outReader := bufio.NewReader(stdout)
 	errReader := bufio.NewReader(stderr)
-	printLog := func(reader *bufio.Reader, typex string) {
+	printLog := func(reader *bufio.Reader, typex string, stream agentpb.Output_Stream) {
 		for {
 			line, err := reader.ReadString('\n')
 			if print {
@@ -342,6 +357,9 @@ func execInSystem(execPath string, params []string, logsBuffer *bytes.Buffer, pr
 				logsBuffer.WriteString(line)
 				lock.Unlock()
 			}
+			if output != nil && line != "" {
+				output(stream, strings.TrimRight(line, "\r\n"))
+			}
 			if err != nil || err == io.EOF {
 				break
 			}
@@ -352,13 +370,13 @@ func execInSystem(execPath string, params []string, logsBuffer *bytes.Buffer, pr
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		printLog(outReader, "Stdout")
+		printLog(outReader, "Stdout", agentpb.Output_STDOUT)
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		printLog(errReader, "Stderr")
+		printLog(errReader, "Stderr", agentpb.Output_STDERR)
 	}()
 
 	err = cmd.Start()
@@ -0,0 +1,1672 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: proto/agent/v1/agent.proto
+
+package agentpb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type Action int32
+
+const (
+	Action_ACTION_UNSPECIFIED Action = 0
+	Action_INSTALL            Action = 1
+	Action_START              Action = 2
+	Action_STOP               Action = 3
+	Action_RESTART            Action = 4
+	Action_UNINSTALL          Action = 5
+	Action_CHECK              Action = 6
+)
+
+var Action_name = map[int32]string{
+	0: "ACTION_UNSPECIFIED",
+	1: "INSTALL",
+	2: "START",
+	3: "STOP",
+	4: "RESTART",
+	5: "UNINSTALL",
+	6: "CHECK",
+}
+
+var Action_value = map[string]int32{
+	"ACTION_UNSPECIFIED": 0,
+	"INSTALL":            1,
+	"START":              2,
+	"STOP":               3,
+	"RESTART":            4,
+	"UNINSTALL":          5,
+	"CHECK":              6,
+}
+
+func (x Action) String() string {
+	return proto.EnumName(Action_name, int32(x))
+}
+
+func (Action) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{0}
+}
+
+type Progress_Step int32
+
+const (
+	Progress_STEP_UNSPECIFIED Progress_Step = 0
+	Progress_DOWNLOAD_SCRIPT  Progress_Step = 1
+	Progress_DOWNLOAD_PACKAGE Progress_Step = 2
+	Progress_PREPARE_BUNDLE   Progress_Step = 3
+	Progress_RUN_SCRIPT       Progress_Step = 4
+	Progress_CLEAN            Progress_Step = 5
+)
+
+var Progress_Step_name = map[int32]string{
+	0: "STEP_UNSPECIFIED",
+	1: "DOWNLOAD_SCRIPT",
+	2: "DOWNLOAD_PACKAGE",
+	3: "PREPARE_BUNDLE",
+	4: "RUN_SCRIPT",
+	5: "CLEAN",
+}
+
+var Progress_Step_value = map[string]int32{
+	"STEP_UNSPECIFIED": 0,
+	"DOWNLOAD_SCRIPT":  1,
+	"DOWNLOAD_PACKAGE": 2,
+	"PREPARE_BUNDLE":   3,
+	"RUN_SCRIPT":       4,
+	"CLEAN":            5,
+}
+
+func (x Progress_Step) String() string {
+	return proto.EnumName(Progress_Step_name, int32(x))
+}
+
+func (Progress_Step) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{9, 0}
+}
+
+type Output_Stream int32
+
+const (
+	Output_STDOUT Output_Stream = 0
+	Output_STDERR Output_Stream = 1
+)
+
+var Output_Stream_name = map[int32]string{
+	0: "STDOUT",
+	1: "STDERR",
+}
+
+var Output_Stream_value = map[string]int32{
+	"STDOUT": 0,
+	"STDERR": 1,
+}
+
+func (x Output_Stream) String() string {
+	return proto.EnumName(Output_Stream_name, int32(x))
+}
+
+func (Output_Stream) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{10, 0}
+}
+
+type Error_Code int32
+
+const (
+	Error_CODE_UNSPECIFIED Error_Code = 0
+	// the request is illegal, eg. an unknown action or an illegal name
+	Error_INVALID_ARGUMENT Error_Code = 1
+	// a script, the package or the bundle can't be downloaded, or its checksum is wrong
+	Error_DOWNLOAD_FAILED Error_Code = 2
+	// the script exits with non-zero, see exit_code
+	Error_SCRIPT_FAILED Error_Code = 3
+	// the script was killed or can't be started
+	Error_SCRIPT_ABORTED Error_Code = 4
+	// the action isn't supported by the agent, eg. probes on an older agent
+	Error_UNSUPPORTED Error_Code = 5
+)
+
+var Error_Code_name = map[int32]string{
+	0: "CODE_UNSPECIFIED",
+	1: "INVALID_ARGUMENT",
+	2: "DOWNLOAD_FAILED",
+	3: "SCRIPT_FAILED",
+	4: "SCRIPT_ABORTED",
+	5: "UNSUPPORTED",
+}
+
+var Error_Code_value = map[string]int32{
+	"CODE_UNSPECIFIED": 0,
+	"INVALID_ARGUMENT": 1,
+	"DOWNLOAD_FAILED":  2,
+	"SCRIPT_FAILED":    3,
+	"SCRIPT_ABORTED":   4,
+	"UNSUPPORTED":      5,
+}
+
+func (x Error_Code) String() string {
+	return proto.EnumName(Error_Code_name, int32(x))
+}
+
+func (Error_Code) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{12, 0}
+}
+
+type ReportCommand_Command int32
+
+const (
+	ReportCommand_COMMAND_UNSPECIFIED ReportCommand_Command = 0
+	// the app is deleted or uninstalled, stop its check loop
+	ReportCommand_STOP_CHECK ReportCommand_Command = 1
+	// the report is received, it's dropped from the queue of the agent
+	ReportCommand_ACK ReportCommand_Command = 2
+)
+
+var ReportCommand_Command_name = map[int32]string{
+	0: "COMMAND_UNSPECIFIED",
+	1: "STOP_CHECK",
+	2: "ACK",
+}
+
+var ReportCommand_Command_value = map[string]int32{
+	"COMMAND_UNSPECIFIED": 0,
+	"STOP_CHECK":          1,
+	"ACK":                 2,
+}
+
+func (x ReportCommand_Command) String() string {
+	return proto.EnumName(ReportCommand_Command_name, int32(x))
+}
+
+func (ReportCommand_Command) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{14, 0}
+}
+
+type PingRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PingRequest) Reset()         { *m = PingRequest{} }
+func (m *PingRequest) String() string { return proto.CompactTextString(m) }
+func (*PingRequest) ProtoMessage()    {}
+func (*PingRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{0}
+}
+
+func (m *PingRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingRequest.Unmarshal(m, b)
+}
+func (m *PingRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingRequest.Marshal(b, m, deterministic)
+}
+func (m *PingRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingRequest.Merge(m, src)
+}
+func (m *PingRequest) XXX_Size() int {
+	return xxx_messageInfo_PingRequest.Size(m)
+}
+func (m *PingRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PingRequest proto.InternalMessageInfo
+
+type PingResponse struct {
+	Version string `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	// push, pull or tunnel, see agent.Mode
+	Mode string `protobuf:"bytes,2,opt,name=mode,proto3" json:"mode,omitempty"`
+	// see agent.Capabilities
+	Capabilities         []string `protobuf:"bytes,3,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PingResponse) Reset()         { *m = PingResponse{} }
+func (m *PingResponse) String() string { return proto.CompactTextString(m) }
+func (*PingResponse) ProtoMessage()    {}
+func (*PingResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{1}
+}
+
+func (m *PingResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingResponse.Unmarshal(m, b)
+}
+func (m *PingResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingResponse.Marshal(b, m, deterministic)
+}
+func (m *PingResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingResponse.Merge(m, src)
+}
+func (m *PingResponse) XXX_Size() int {
+	return xxx_messageInfo_PingResponse.Size(m)
+}
+func (m *PingResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PingResponse proto.InternalMessageInfo
+
+func (m *PingResponse) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *PingResponse) GetMode() string {
+	if m != nil {
+		return m.Mode
+	}
+	return ""
+}
+
+func (m *PingResponse) GetCapabilities() []string {
+	if m != nil {
+		return m.Capabilities
+	}
+	return nil
+}
+
+type NodeInfo struct {
+	OperatorIp   string `protobuf:"bytes,1,opt,name=operator_ip,json=operatorIp,proto3" json:"operator_ip,omitempty"`
+	OperatorPort string `protobuf:"bytes,2,opt,name=operator_port,json=operatorPort,proto3" json:"operator_port,omitempty"`
+	HostIp       string `protobuf:"bytes,3,opt,name=host_ip,json=hostIp,proto3" json:"host_ip,omitempty"`
+	RelayToken   string `protobuf:"bytes,4,opt,name=relay_token,json=relayToken,proto3" json:"relay_token,omitempty"`
+	// the port of the Operator service, empty if the agent reports by the json api
+	OperatorGrpcPort     string   `protobuf:"bytes,5,opt,name=operator_grpc_port,json=operatorGrpcPort,proto3" json:"operator_grpc_port,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NodeInfo) Reset()         { *m = NodeInfo{} }
+func (m *NodeInfo) String() string { return proto.CompactTextString(m) }
+func (*NodeInfo) ProtoMessage()    {}
+func (*NodeInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{2}
+}
+
+func (m *NodeInfo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeInfo.Unmarshal(m, b)
+}
+func (m *NodeInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeInfo.Marshal(b, m, deterministic)
+}
+func (m *NodeInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeInfo.Merge(m, src)
+}
+func (m *NodeInfo) XXX_Size() int {
+	return xxx_messageInfo_NodeInfo.Size(m)
+}
+func (m *NodeInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeInfo proto.InternalMessageInfo
+
+func (m *NodeInfo) GetOperatorIp() string {
+	if m != nil {
+		return m.OperatorIp
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetOperatorPort() string {
+	if m != nil {
+		return m.OperatorPort
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetHostIp() string {
+	if m != nil {
+		return m.HostIp
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetRelayToken() string {
+	if m != nil {
+		return m.RelayToken
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetOperatorGrpcPort() string {
+	if m != nil {
+		return m.OperatorGrpcPort
+	}
+	return ""
+}
+
+type SetNodeResponse struct {
+	Version              string   `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetNodeResponse) Reset()         { *m = SetNodeResponse{} }
+func (m *SetNodeResponse) String() string { return proto.CompactTextString(m) }
+func (*SetNodeResponse) ProtoMessage()    {}
+func (*SetNodeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{3}
+}
+
+func (m *SetNodeResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetNodeResponse.Unmarshal(m, b)
+}
+func (m *SetNodeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetNodeResponse.Marshal(b, m, deterministic)
+}
+func (m *SetNodeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetNodeResponse.Merge(m, src)
+}
+func (m *SetNodeResponse) XXX_Size() int {
+	return xxx_messageInfo_SetNodeResponse.Size(m)
+}
+func (m *SetNodeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetNodeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetNodeResponse proto.InternalMessageInfo
+
+func (m *SetNodeResponse) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+type Bundle struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version              string   `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	Sha256               string   `protobuf:"bytes,3,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Bundle) Reset()         { *m = Bundle{} }
+func (m *Bundle) String() string { return proto.CompactTextString(m) }
+func (*Bundle) ProtoMessage()    {}
+func (*Bundle) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{4}
+}
+
+func (m *Bundle) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Bundle.Unmarshal(m, b)
+}
+func (m *Bundle) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Bundle.Marshal(b, m, deterministic)
+}
+func (m *Bundle) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Bundle.Merge(m, src)
+}
+func (m *Bundle) XXX_Size() int {
+	return xxx_messageInfo_Bundle.Size(m)
+}
+func (m *Bundle) XXX_DiscardUnknown() {
+	xxx_messageInfo_Bundle.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Bundle proto.InternalMessageInfo
+
+func (m *Bundle) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Bundle) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *Bundle) GetSha256() string {
+	if m != nil {
+		return m.Sha256
+	}
+	return ""
+}
+
+type Probe struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// tcp, http, process or exec
+	Type                 string   `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Address              string   `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	Url                  string   `protobuf:"bytes,4,opt,name=url,proto3" json:"url,omitempty"`
+	ExpectStatus         int32    `protobuf:"varint,5,opt,name=expect_status,json=expectStatus,proto3" json:"expect_status,omitempty"`
+	ExpectBody           string   `protobuf:"bytes,6,opt,name=expect_body,json=expectBody,proto3" json:"expect_body,omitempty"`
+	InsecureSkipVerify   bool     `protobuf:"varint,7,opt,name=insecure_skip_verify,json=insecureSkipVerify,proto3" json:"insecure_skip_verify,omitempty"`
+	Process              string   `protobuf:"bytes,8,opt,name=process,proto3" json:"process,omitempty"`
+	PidFile              string   `protobuf:"bytes,9,opt,name=pid_file,json=pidFile,proto3" json:"pid_file,omitempty"`
+	Command              []string `protobuf:"bytes,10,rep,name=command,proto3" json:"command,omitempty"`
+	IntervalSeconds      int32    `protobuf:"varint,11,opt,name=interval_seconds,json=intervalSeconds,proto3" json:"interval_seconds,omitempty"`
+	TimeoutSeconds       int32    `protobuf:"varint,12,opt,name=timeout_seconds,json=timeoutSeconds,proto3" json:"timeout_seconds,omitempty"`
+	SuccessThreshold     int32    `protobuf:"varint,13,opt,name=success_threshold,json=successThreshold,proto3" json:"success_threshold,omitempty"`
+	FailureThreshold     int32    `protobuf:"varint,14,opt,name=failure_threshold,json=failureThreshold,proto3" json:"failure_threshold,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Probe) Reset()         { *m = Probe{} }
+func (m *Probe) String() string { return proto.CompactTextString(m) }
+func (*Probe) ProtoMessage()    {}
+func (*Probe) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{5}
+}
+
+func (m *Probe) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Probe.Unmarshal(m, b)
+}
+func (m *Probe) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Probe.Marshal(b, m, deterministic)
+}
+func (m *Probe) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Probe.Merge(m, src)
+}
+func (m *Probe) XXX_Size() int {
+	return xxx_messageInfo_Probe.Size(m)
+}
+func (m *Probe) XXX_DiscardUnknown() {
+	xxx_messageInfo_Probe.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Probe proto.InternalMessageInfo
+
+func (m *Probe) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Probe) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Probe) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+func (m *Probe) GetUrl() string {
+	if m != nil {
+		return m.Url
+	}
+	return ""
+}
+
+func (m *Probe) GetExpectStatus() int32 {
+	if m != nil {
+		return m.ExpectStatus
+	}
+	return 0
+}
+
+func (m *Probe) GetExpectBody() string {
+	if m != nil {
+		return m.ExpectBody
+	}
+	return ""
+}
+
+func (m *Probe) GetInsecureSkipVerify() bool {
+	if m != nil {
+		return m.InsecureSkipVerify
+	}
+	return false
+}
+
+func (m *Probe) GetProcess() string {
+	if m != nil {
+		return m.Process
+	}
+	return ""
+}
+
+func (m *Probe) GetPidFile() string {
+	if m != nil {
+		return m.PidFile
+	}
+	return ""
+}
+
+func (m *Probe) GetCommand() []string {
+	if m != nil {
+		return m.Command
+	}
+	return nil
+}
+
+func (m *Probe) GetIntervalSeconds() int32 {
+	if m != nil {
+		return m.IntervalSeconds
+	}
+	return 0
+}
+
+func (m *Probe) GetTimeoutSeconds() int32 {
+	if m != nil {
+		return m.TimeoutSeconds
+	}
+	return 0
+}
+
+func (m *Probe) GetSuccessThreshold() int32 {
+	if m != nil {
+		return m.SuccessThreshold
+	}
+	return 0
+}
+
+func (m *Probe) GetFailureThreshold() int32 {
+	if m != nil {
+		return m.FailureThreshold
+	}
+	return 0
+}
+
+// AppInfo is agent.AppInfo, see the wiki for every field.
+type AppInfo struct {
+	Name                 string            `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type                 string            `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	OperatorIp           string            `protobuf:"bytes,3,opt,name=operator_ip,json=operatorIp,proto3" json:"operator_ip,omitempty"`
+	OperatorPort         string            `protobuf:"bytes,4,opt,name=operator_port,json=operatorPort,proto3" json:"operator_port,omitempty"`
+	RepoUrl              string            `protobuf:"bytes,5,opt,name=repo_url,json=repoUrl,proto3" json:"repo_url,omitempty"`
+	Install              string            `protobuf:"bytes,6,opt,name=install,proto3" json:"install,omitempty"`
+	Start                string            `protobuf:"bytes,7,opt,name=start,proto3" json:"start,omitempty"`
+	Stop                 string            `protobuf:"bytes,8,opt,name=stop,proto3" json:"stop,omitempty"`
+	Restart              string            `protobuf:"bytes,9,opt,name=restart,proto3" json:"restart,omitempty"`
+	Uninstall            string            `protobuf:"bytes,10,opt,name=uninstall,proto3" json:"uninstall,omitempty"`
+	Check                string            `protobuf:"bytes,11,opt,name=check,proto3" json:"check,omitempty"`
+	Package              string            `protobuf:"bytes,12,opt,name=package,proto3" json:"package,omitempty"`
+	Version              string            `protobuf:"bytes,13,opt,name=version,proto3" json:"version,omitempty"`
+	Mirrors              []string          `protobuf:"bytes,14,rep,name=mirrors,proto3" json:"mirrors,omitempty"`
+	Checksums            map[string]string `protobuf:"bytes,15,rep,name=checksums,proto3" json:"checksums,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Bundle               *Bundle           `protobuf:"bytes,16,opt,name=bundle,proto3" json:"bundle,omitempty"`
+	Probes               []*Probe          `protobuf:"bytes,17,rep,name=probes,proto3" json:"probes,omitempty"`
+	HostIp               string            `protobuf:"bytes,18,opt,name=host_ip,json=hostIp,proto3" json:"host_ip,omitempty"`
+	Metadata             map[string]string `protobuf:"bytes,19,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	RelayToken           string            `protobuf:"bytes,20,opt,name=relay_token,json=relayToken,proto3" json:"relay_token,omitempty"`
+	OperatorGrpcPort     string            `protobuf:"bytes,21,opt,name=operator_grpc_port,json=operatorGrpcPort,proto3" json:"operator_grpc_port,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *AppInfo) Reset()         { *m = AppInfo{} }
+func (m *AppInfo) String() string { return proto.CompactTextString(m) }
+func (*AppInfo) ProtoMessage()    {}
+func (*AppInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{6}
+}
+
+func (m *AppInfo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AppInfo.Unmarshal(m, b)
+}
+func (m *AppInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AppInfo.Marshal(b, m, deterministic)
+}
+func (m *AppInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AppInfo.Merge(m, src)
+}
+func (m *AppInfo) XXX_Size() int {
+	return xxx_messageInfo_AppInfo.Size(m)
+}
+func (m *AppInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_AppInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AppInfo proto.InternalMessageInfo
+
+func (m *AppInfo) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *AppInfo) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *AppInfo) GetOperatorIp() string {
+	if m != nil {
+		return m.OperatorIp
+	}
+	return ""
+}
+
+func (m *AppInfo) GetOperatorPort() string {
+	if m != nil {
+		return m.OperatorPort
+	}
+	return ""
+}
+
+func (m *AppInfo) GetRepoUrl() string {
+	if m != nil {
+		return m.RepoUrl
+	}
+	return ""
+}
+
+func (m *AppInfo) GetInstall() string {
+	if m != nil {
+		return m.Install
+	}
+	return ""
+}
+
+func (m *AppInfo) GetStart() string {
+	if m != nil {
+		return m.Start
+	}
+	return ""
+}
+
+func (m *AppInfo) GetStop() string {
+	if m != nil {
+		return m.Stop
+	}
+	return ""
+}
+
+func (m *AppInfo) GetRestart() string {
+	if m != nil {
+		return m.Restart
+	}
+	return ""
+}
+
+func (m *AppInfo) GetUninstall() string {
+	if m != nil {
+		return m.Uninstall
+	}
+	return ""
+}
+
+func (m *AppInfo) GetCheck() string {
+	if m != nil {
+		return m.Check
+	}
+	return ""
+}
+
+func (m *AppInfo) GetPackage() string {
+	if m != nil {
+		return m.Package
+	}
+	return ""
+}
+
+func (m *AppInfo) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *AppInfo) GetMirrors() []string {
+	if m != nil {
+		return m.Mirrors
+	}
+	return nil
+}
+
+func (m *AppInfo) GetChecksums() map[string]string {
+	if m != nil {
+		return m.Checksums
+	}
+	return nil
+}
+
+func (m *AppInfo) GetBundle() *Bundle {
+	if m != nil {
+		return m.Bundle
+	}
+	return nil
+}
+
+func (m *AppInfo) GetProbes() []*Probe {
+	if m != nil {
+		return m.Probes
+	}
+	return nil
+}
+
+func (m *AppInfo) GetHostIp() string {
+	if m != nil {
+		return m.HostIp
+	}
+	return ""
+}
+
+func (m *AppInfo) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *AppInfo) GetRelayToken() string {
+	if m != nil {
+		return m.RelayToken
+	}
+	return ""
+}
+
+func (m *AppInfo) GetOperatorGrpcPort() string {
+	if m != nil {
+		return m.OperatorGrpcPort
+	}
+	return ""
+}
+
+type ActionRequest struct {
+	// operation_id is the same for retries of one action, it's passed to scripts as OPERATION_ID
+	OperationId          string   `protobuf:"bytes,1,opt,name=operation_id,json=operationId,proto3" json:"operation_id,omitempty"`
+	Action               Action   `protobuf:"varint,2,opt,name=action,proto3,enum=paasoperator.agent.v1.Action" json:"action,omitempty"`
+	App                  *AppInfo `protobuf:"bytes,3,opt,name=app,proto3" json:"app,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ActionRequest) Reset()         { *m = ActionRequest{} }
+func (m *ActionRequest) String() string { return proto.CompactTextString(m) }
+func (*ActionRequest) ProtoMessage()    {}
+func (*ActionRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{7}
+}
+
+func (m *ActionRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ActionRequest.Unmarshal(m, b)
+}
+func (m *ActionRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ActionRequest.Marshal(b, m, deterministic)
+}
+func (m *ActionRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ActionRequest.Merge(m, src)
+}
+func (m *ActionRequest) XXX_Size() int {
+	return xxx_messageInfo_ActionRequest.Size(m)
+}
+func (m *ActionRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ActionRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ActionRequest proto.InternalMessageInfo
+
+func (m *ActionRequest) GetOperationId() string {
+	if m != nil {
+		return m.OperationId
+	}
+	return ""
+}
+
+func (m *ActionRequest) GetAction() Action {
+	if m != nil {
+		return m.Action
+	}
+	return Action_ACTION_UNSPECIFIED
+}
+
+func (m *ActionRequest) GetApp() *AppInfo {
+	if m != nil {
+		return m.App
+	}
+	return nil
+}
+
+type ActionEvent struct {
+	// Types that are valid to be assigned to Event:
+	//	*ActionEvent_Progress
+	//	*ActionEvent_Output
+	//	*ActionEvent_Result
+	Event                isActionEvent_Event `protobuf_oneof:"event"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *ActionEvent) Reset()         { *m = ActionEvent{} }
+func (m *ActionEvent) String() string { return proto.CompactTextString(m) }
+func (*ActionEvent) ProtoMessage()    {}
+func (*ActionEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{8}
+}
+
+func (m *ActionEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ActionEvent.Unmarshal(m, b)
+}
+func (m *ActionEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ActionEvent.Marshal(b, m, deterministic)
+}
+func (m *ActionEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ActionEvent.Merge(m, src)
+}
+func (m *ActionEvent) XXX_Size() int {
+	return xxx_messageInfo_ActionEvent.Size(m)
+}
+func (m *ActionEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_ActionEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ActionEvent proto.InternalMessageInfo
+
+type isActionEvent_Event interface {
+	isActionEvent_Event()
+}
+
+type ActionEvent_Progress struct {
+	Progress *Progress `protobuf:"bytes,1,opt,name=progress,proto3,oneof"`
+}
+
+type ActionEvent_Output struct {
+	Output *Output `protobuf:"bytes,2,opt,name=output,proto3,oneof"`
+}
+
+type ActionEvent_Result struct {
+	Result *Result `protobuf:"bytes,3,opt,name=result,proto3,oneof"`
+}
+
+func (*ActionEvent_Progress) isActionEvent_Event() {}
+
+func (*ActionEvent_Output) isActionEvent_Event() {}
+
+func (*ActionEvent_Result) isActionEvent_Event() {}
+
+func (m *ActionEvent) GetEvent() isActionEvent_Event {
+	if m != nil {
+		return m.Event
+	}
+	return nil
+}
+
+func (m *ActionEvent) GetProgress() *Progress {
+	if x, ok := m.GetEvent().(*ActionEvent_Progress); ok {
+		return x.Progress
+	}
+	return nil
+}
+
+func (m *ActionEvent) GetOutput() *Output {
+	if x, ok := m.GetEvent().(*ActionEvent_Output); ok {
+		return x.Output
+	}
+	return nil
+}
+
+func (m *ActionEvent) GetResult() *Result {
+	if x, ok := m.GetEvent().(*ActionEvent_Result); ok {
+		return x.Result
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*ActionEvent) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*ActionEvent_Progress)(nil),
+		(*ActionEvent_Output)(nil),
+		(*ActionEvent_Result)(nil),
+	}
+}
+
+// Progress tells which step of an action is running.
+type Progress struct {
+	Step                 Progress_Step `protobuf:"varint,1,opt,name=step,proto3,enum=paasoperator.agent.v1.Progress_Step" json:"step,omitempty"`
+	Message              string        `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *Progress) Reset()         { *m = Progress{} }
+func (m *Progress) String() string { return proto.CompactTextString(m) }
+func (*Progress) ProtoMessage()    {}
+func (*Progress) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{9}
+}
+
+func (m *Progress) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Progress.Unmarshal(m, b)
+}
+func (m *Progress) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Progress.Marshal(b, m, deterministic)
+}
+func (m *Progress) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Progress.Merge(m, src)
+}
+func (m *Progress) XXX_Size() int {
+	return xxx_messageInfo_Progress.Size(m)
+}
+func (m *Progress) XXX_DiscardUnknown() {
+	xxx_messageInfo_Progress.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Progress proto.InternalMessageInfo
+
+func (m *Progress) GetStep() Progress_Step {
+	if m != nil {
+		return m.Step
+	}
+	return Progress_STEP_UNSPECIFIED
+}
+
+func (m *Progress) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+// Output is a line printed by the script.
+type Output struct {
+	Stream               Output_Stream `protobuf:"varint,1,opt,name=stream,proto3,enum=paasoperator.agent.v1.Output_Stream" json:"stream,omitempty"`
+	Line                 string        `protobuf:"bytes,2,opt,name=line,proto3" json:"line,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *Output) Reset()         { *m = Output{} }
+func (m *Output) String() string { return proto.CompactTextString(m) }
+func (*Output) ProtoMessage()    {}
+func (*Output) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{10}
+}
+
+func (m *Output) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Output.Unmarshal(m, b)
+}
+func (m *Output) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Output.Marshal(b, m, deterministic)
+}
+func (m *Output) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Output.Merge(m, src)
+}
+func (m *Output) XXX_Size() int {
+	return xxx_messageInfo_Output.Size(m)
+}
+func (m *Output) XXX_DiscardUnknown() {
+	xxx_messageInfo_Output.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Output proto.InternalMessageInfo
+
+func (m *Output) GetStream() Output_Stream {
+	if m != nil {
+		return m.Stream
+	}
+	return Output_STDOUT
+}
+
+func (m *Output) GetLine() string {
+	if m != nil {
+		return m.Line
+	}
+	return ""
+}
+
+// Result is the end of an action, it replaces {"msg":"ok"} and {"error":...} of the json api.
+type Result struct {
+	// ok, failed, not-installed, already-running, already-stopped or retryable-failure, see agent.ScriptResult
+	Result string `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	// exit code of the script, -1 if it wasn't run
+	ExitCode             int32    `protobuf:"varint,2,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`
+	Error                *Error   `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Result) Reset()         { *m = Result{} }
+func (m *Result) String() string { return proto.CompactTextString(m) }
+func (*Result) ProtoMessage()    {}
+func (*Result) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{11}
+}
+
+func (m *Result) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Result.Unmarshal(m, b)
+}
+func (m *Result) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Result.Marshal(b, m, deterministic)
+}
+func (m *Result) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Result.Merge(m, src)
+}
+func (m *Result) XXX_Size() int {
+	return xxx_messageInfo_Result.Size(m)
+}
+func (m *Result) XXX_DiscardUnknown() {
+	xxx_messageInfo_Result.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Result proto.InternalMessageInfo
+
+func (m *Result) GetResult() string {
+	if m != nil {
+		return m.Result
+	}
+	return ""
+}
+
+func (m *Result) GetExitCode() int32 {
+	if m != nil {
+		return m.ExitCode
+	}
+	return 0
+}
+
+func (m *Result) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+// Error tells why an action failed without parsing messages.
+type Error struct {
+	Code                 Error_Code `protobuf:"varint,1,opt,name=code,proto3,enum=paasoperator.agent.v1.Error_Code" json:"code,omitempty"`
+	Message              string     `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *Error) Reset()         { *m = Error{} }
+func (m *Error) String() string { return proto.CompactTextString(m) }
+func (*Error) ProtoMessage()    {}
+func (*Error) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{12}
+}
+
+func (m *Error) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Error.Unmarshal(m, b)
+}
+func (m *Error) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Error.Marshal(b, m, deterministic)
+}
+func (m *Error) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Error.Merge(m, src)
+}
+func (m *Error) XXX_Size() int {
+	return xxx_messageInfo_Error.Size(m)
+}
+func (m *Error) XXX_DiscardUnknown() {
+	xxx_messageInfo_Error.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Error proto.InternalMessageInfo
+
+func (m *Error) GetCode() Error_Code {
+	if m != nil {
+		return m.Code
+	}
+	return Error_CODE_UNSPECIFIED
+}
+
+func (m *Error) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+type CheckReport struct {
+	AppType string `protobuf:"bytes,1,opt,name=app_type,json=appType,proto3" json:"app_type,omitempty"`
+	AppName string `protobuf:"bytes,2,opt,name=app_name,json=appName,proto3" json:"app_name,omitempty"`
+	// the app healthy json printed by the check script or built from probes
+	Health string `protobuf:"bytes,3,opt,name=health,proto3" json:"health,omitempty"`
+	// when the check ran in RFC 3339, a report queued while the apiserver was unreachable comes late
+	Time                 string   `protobuf:"bytes,4,opt,name=time,proto3" json:"time,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CheckReport) Reset()         { *m = CheckReport{} }
+func (m *CheckReport) String() string { return proto.CompactTextString(m) }
+func (*CheckReport) ProtoMessage()    {}
+func (*CheckReport) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{13}
+}
+
+func (m *CheckReport) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CheckReport.Unmarshal(m, b)
+}
+func (m *CheckReport) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CheckReport.Marshal(b, m, deterministic)
+}
+func (m *CheckReport) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CheckReport.Merge(m, src)
+}
+func (m *CheckReport) XXX_Size() int {
+	return xxx_messageInfo_CheckReport.Size(m)
+}
+func (m *CheckReport) XXX_DiscardUnknown() {
+	xxx_messageInfo_CheckReport.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CheckReport proto.InternalMessageInfo
+
+func (m *CheckReport) GetAppType() string {
+	if m != nil {
+		return m.AppType
+	}
+	return ""
+}
+
+func (m *CheckReport) GetAppName() string {
+	if m != nil {
+		return m.AppName
+	}
+	return ""
+}
+
+func (m *CheckReport) GetHealth() string {
+	if m != nil {
+		return m.Health
+	}
+	return ""
+}
+
+func (m *CheckReport) GetTime() string {
+	if m != nil {
+		return m.Time
+	}
+	return ""
+}
+
+type ReportCommand struct {
+	AppType              string                `protobuf:"bytes,1,opt,name=app_type,json=appType,proto3" json:"app_type,omitempty"`
+	AppName              string                `protobuf:"bytes,2,opt,name=app_name,json=appName,proto3" json:"app_name,omitempty"`
+	Command              ReportCommand_Command `protobuf:"varint,3,opt,name=command,proto3,enum=paasoperator.agent.v1.ReportCommand_Command" json:"command,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}              `json:"-"`
+	XXX_unrecognized     []byte                `json:"-"`
+	XXX_sizecache        int32                 `json:"-"`
+}
+
+func (m *ReportCommand) Reset()         { *m = ReportCommand{} }
+func (m *ReportCommand) String() string { return proto.CompactTextString(m) }
+func (*ReportCommand) ProtoMessage()    {}
+func (*ReportCommand) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6d5894091b396a9, []int{14}
+}
+
+func (m *ReportCommand) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReportCommand.Unmarshal(m, b)
+}
+func (m *ReportCommand) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReportCommand.Marshal(b, m, deterministic)
+}
+func (m *ReportCommand) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReportCommand.Merge(m, src)
+}
+func (m *ReportCommand) XXX_Size() int {
+	return xxx_messageInfo_ReportCommand.Size(m)
+}
+func (m *ReportCommand) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReportCommand.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReportCommand proto.InternalMessageInfo
+
+func (m *ReportCommand) GetAppType() string {
+	if m != nil {
+		return m.AppType
+	}
+	return ""
+}
+
+func (m *ReportCommand) GetAppName() string {
+	if m != nil {
+		return m.AppName
+	}
+	return ""
+}
+
+func (m *ReportCommand) GetCommand() ReportCommand_Command {
+	if m != nil {
+		return m.Command
+	}
+	return ReportCommand_COMMAND_UNSPECIFIED
+}
+
+func init() {
+	proto.RegisterEnum("paasoperator.agent.v1.Action", Action_name, Action_value)
+	proto.RegisterEnum("paasoperator.agent.v1.Progress_Step", Progress_Step_name, Progress_Step_value)
+	proto.RegisterEnum("paasoperator.agent.v1.Output_Stream", Output_Stream_name, Output_Stream_value)
+	proto.RegisterEnum("paasoperator.agent.v1.Error_Code", Error_Code_name, Error_Code_value)
+	proto.RegisterEnum("paasoperator.agent.v1.ReportCommand_Command", ReportCommand_Command_name, ReportCommand_Command_value)
+	proto.RegisterType((*PingRequest)(nil), "paasoperator.agent.v1.PingRequest")
+	proto.RegisterType((*PingResponse)(nil), "paasoperator.agent.v1.PingResponse")
+	proto.RegisterType((*NodeInfo)(nil), "paasoperator.agent.v1.NodeInfo")
+	proto.RegisterType((*SetNodeResponse)(nil), "paasoperator.agent.v1.SetNodeResponse")
+	proto.RegisterType((*Bundle)(nil), "paasoperator.agent.v1.Bundle")
+	proto.RegisterType((*Probe)(nil), "paasoperator.agent.v1.Probe")
+	proto.RegisterType((*AppInfo)(nil), "paasoperator.agent.v1.AppInfo")
+	proto.RegisterMapType((map[string]string)(nil), "paasoperator.agent.v1.AppInfo.ChecksumsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "paasoperator.agent.v1.AppInfo.MetadataEntry")
+	proto.RegisterType((*ActionRequest)(nil), "paasoperator.agent.v1.ActionRequest")
+	proto.RegisterType((*ActionEvent)(nil), "paasoperator.agent.v1.ActionEvent")
+	proto.RegisterType((*Progress)(nil), "paasoperator.agent.v1.Progress")
+	proto.RegisterType((*Output)(nil), "paasoperator.agent.v1.Output")
+	proto.RegisterType((*Result)(nil), "paasoperator.agent.v1.Result")
+	proto.RegisterType((*Error)(nil), "paasoperator.agent.v1.Error")
+	proto.RegisterType((*CheckReport)(nil), "paasoperator.agent.v1.CheckReport")
+	proto.RegisterType((*ReportCommand)(nil), "paasoperator.agent.v1.ReportCommand")
+}
+
+func init() { proto.RegisterFile("proto/agent/v1/agent.proto", fileDescriptor_d6d5894091b396a9) }
+
+var fileDescriptor_d6d5894091b396a9 = []byte{
+	// 1571 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x57, 0xdd, 0x6e, 0xdb, 0xc8,
+	0x15, 0x36, 0xf5, 0x43, 0x49, 0x47, 0x96, 0xcc, 0x4c, 0xbc, 0x59, 0xae, 0xbb, 0xed, 0x7a, 0xb9,
+	0x41, 0xeb, 0x76, 0x13, 0xd9, 0x51, 0x9b, 0x36, 0x68, 0xd2, 0x0b, 0x5a, 0xa2, 0x63, 0xc1, 0xb6,
+	0x24, 0x50, 0x52, 0x0a, 0xf4, 0x86, 0xa5, 0xa4, 0xb1, 0x45, 0x98, 0xe2, 0x4c, 0x86, 0x23, 0x21,
+	0x06, 0x0a, 0xf4, 0x41, 0xfa, 0x22, 0x7d, 0x83, 0x02, 0xbd, 0xea, 0x55, 0x81, 0xbc, 0x4c, 0x51,
+	0xcc, 0x0f, 0xe5, 0x1f, 0x58, 0x76, 0xda, 0x1b, 0x69, 0xce, 0x99, 0xef, 0x9c, 0x39, 0x73, 0x7e,
+	0x87, 0xb0, 0x43, 0x19, 0xe1, 0x64, 0x3f, 0xbc, 0xc0, 0x09, 0xdf, 0x5f, 0xbe, 0x52, 0x8b, 0x86,
+	0x64, 0xa2, 0xaf, 0x68, 0x18, 0xa6, 0x84, 0x62, 0x16, 0x72, 0xc2, 0x1a, 0x6a, 0x67, 0xf9, 0xca,
+	0xa9, 0x41, 0xb5, 0x1f, 0x25, 0x17, 0x3e, 0xfe, 0xb8, 0xc0, 0x29, 0x77, 0xfe, 0x0c, 0x9b, 0x8a,
+	0x4c, 0x29, 0x49, 0x52, 0x8c, 0x6c, 0x28, 0x2d, 0x31, 0x4b, 0x23, 0x92, 0xd8, 0xc6, 0xae, 0xb1,
+	0x57, 0xf1, 0x33, 0x12, 0x21, 0x28, 0xcc, 0xc9, 0x14, 0xdb, 0x39, 0xc9, 0x96, 0x6b, 0xe4, 0xc0,
+	0xe6, 0x24, 0xa4, 0xe1, 0x38, 0x8a, 0x23, 0x1e, 0xe1, 0xd4, 0xce, 0xef, 0xe6, 0xf7, 0x2a, 0xfe,
+	0x2d, 0x9e, 0xf3, 0x77, 0x03, 0xca, 0x5d, 0x32, 0xc5, 0x9d, 0xe4, 0x9c, 0xa0, 0xef, 0xa0, 0x9a,
+	0x99, 0x14, 0x44, 0x54, 0x1f, 0x01, 0x19, 0xab, 0x43, 0xd1, 0x0f, 0x50, 0x5b, 0x01, 0x28, 0x61,
+	0x5c, 0x1f, 0xb7, 0x99, 0x31, 0xfb, 0x84, 0x71, 0xf4, 0x35, 0x94, 0x66, 0x24, 0xe5, 0x42, 0x43,
+	0x5e, 0x6e, 0x9b, 0x82, 0xec, 0x50, 0xa1, 0x9e, 0xe1, 0x38, 0xbc, 0x0a, 0x38, 0xb9, 0xc4, 0x89,
+	0x5d, 0x50, 0xea, 0x25, 0x6b, 0x28, 0x38, 0xe8, 0x05, 0xa0, 0x95, 0xfa, 0x0b, 0x46, 0x27, 0xea,
+	0x8c, 0xa2, 0xc4, 0x59, 0xd9, 0xce, 0x7b, 0x46, 0x27, 0xe2, 0x1c, 0xe7, 0x47, 0xd8, 0x1a, 0x60,
+	0x2e, 0x8c, 0x7f, 0xdc, 0x3f, 0x4e, 0x17, 0xcc, 0xc3, 0x45, 0x32, 0x8d, 0xb1, 0xf0, 0x54, 0x12,
+	0xce, 0xb1, 0x06, 0xc8, 0xf5, 0x4d, 0xb9, 0xdc, 0x6d, 0xbf, 0x3e, 0x03, 0x33, 0x9d, 0x85, 0xcd,
+	0xd7, 0xbf, 0xcd, 0xee, 0xa2, 0x28, 0xe7, 0x5f, 0x79, 0x28, 0xf6, 0x19, 0x19, 0xdf, 0xaf, 0x0f,
+	0x41, 0x81, 0x5f, 0xd1, 0x55, 0x34, 0xc4, 0x5a, 0x9c, 0x11, 0x4e, 0xa7, 0x0c, 0xa7, 0xa9, 0x56,
+	0x95, 0x91, 0xc8, 0x82, 0xfc, 0x82, 0xc5, 0xda, 0x1f, 0x62, 0x29, 0xfc, 0x8c, 0x3f, 0x51, 0x3c,
+	0xe1, 0x41, 0xca, 0x43, 0xbe, 0x48, 0xa5, 0x0f, 0x8a, 0xfe, 0xa6, 0x62, 0x0e, 0x24, 0x4f, 0xb8,
+	0x53, 0x83, 0xc6, 0x64, 0x7a, 0x65, 0x9b, 0xca, 0x9d, 0x8a, 0x75, 0x48, 0xa6, 0x57, 0xe8, 0x00,
+	0xb6, 0xa3, 0x24, 0xc5, 0x93, 0x05, 0xc3, 0x41, 0x7a, 0x19, 0xd1, 0x60, 0x89, 0x59, 0x74, 0x7e,
+	0x65, 0x97, 0x76, 0x8d, 0xbd, 0xb2, 0x8f, 0xb2, 0xbd, 0xc1, 0x65, 0x44, 0x3f, 0xc8, 0x1d, 0x61,
+	0x23, 0x65, 0x64, 0x22, 0x6c, 0x2c, 0x2b, 0x1b, 0x35, 0x89, 0xbe, 0x81, 0x32, 0x8d, 0xa6, 0xc1,
+	0x79, 0x14, 0x63, 0xbb, 0xa2, 0xb7, 0xa2, 0xe9, 0x51, 0x14, 0xcb, 0x8b, 0x4d, 0xc8, 0x7c, 0x1e,
+	0x26, 0x53, 0x1b, 0x64, 0x86, 0x65, 0x24, 0xfa, 0x25, 0x58, 0x51, 0xc2, 0x31, 0x5b, 0x86, 0x71,
+	0x90, 0xe2, 0x09, 0x49, 0xa6, 0xa9, 0x5d, 0x95, 0x37, 0xd9, 0xca, 0xf8, 0x03, 0xc5, 0x46, 0xbf,
+	0x80, 0x2d, 0x1e, 0xcd, 0x31, 0x59, 0xf0, 0x15, 0x72, 0x53, 0x22, 0xeb, 0x9a, 0x9d, 0x01, 0x7f,
+	0x84, 0x27, 0xe9, 0x62, 0x22, 0x6c, 0x0a, 0xf8, 0x8c, 0xe1, 0x74, 0x46, 0xe2, 0xa9, 0x5d, 0x93,
+	0x50, 0x4b, 0x6f, 0x0c, 0x33, 0xbe, 0x00, 0x9f, 0x87, 0x51, 0x2c, 0x1c, 0x70, 0x0d, 0xae, 0x2b,
+	0xb0, 0xde, 0x58, 0x81, 0x9d, 0xcf, 0x26, 0x94, 0x5c, 0x4a, 0x65, 0x25, 0x7c, 0x69, 0x50, 0xef,
+	0x54, 0x4c, 0xfe, 0xf1, 0x8a, 0x29, 0xdc, 0x53, 0x31, 0xdf, 0x40, 0x99, 0x61, 0x4a, 0x02, 0x91,
+	0x05, 0x2a, 0xdb, 0x4b, 0x82, 0x1e, 0xb1, 0x58, 0x38, 0x37, 0x4a, 0x52, 0x1e, 0xc6, 0xb1, 0x0e,
+	0x70, 0x46, 0xa2, 0x6d, 0x28, 0xa6, 0x3c, 0x64, 0x5c, 0x86, 0xb3, 0xe2, 0x2b, 0x42, 0x18, 0x99,
+	0x72, 0x42, 0x75, 0xf8, 0xe4, 0x5a, 0xe8, 0x60, 0x58, 0x61, 0x2b, 0x99, 0x76, 0x85, 0xfe, 0x16,
+	0x2a, 0x8b, 0x24, 0xd3, 0x0f, 0x72, 0xef, 0x9a, 0x21, 0x4e, 0x98, 0xcc, 0xf0, 0xe4, 0x52, 0xc6,
+	0xac, 0xe2, 0x2b, 0x42, 0xe6, 0x48, 0x38, 0xb9, 0x0c, 0x2f, 0xb0, 0xbd, 0xa9, 0x13, 0x41, 0x91,
+	0x37, 0xab, 0xa8, 0x76, 0xbb, 0x8a, 0x6c, 0x28, 0xcd, 0x23, 0xc6, 0x08, 0x4b, 0xed, 0xba, 0x4a,
+	0x11, 0x4d, 0xa2, 0x13, 0xa8, 0x48, 0xb5, 0xe9, 0x62, 0x9e, 0xda, 0x5b, 0xbb, 0xf9, 0xbd, 0x6a,
+	0xf3, 0x65, 0xe3, 0xde, 0xde, 0xd8, 0xd0, 0xb1, 0x69, 0xb4, 0x32, 0xbc, 0x97, 0x70, 0x76, 0xe5,
+	0x5f, 0xcb, 0xa3, 0xd7, 0x60, 0x8e, 0x65, 0x91, 0xdb, 0xd6, 0xae, 0xb1, 0x57, 0x6d, 0xfe, 0x74,
+	0x8d, 0x26, 0xd5, 0x09, 0x7c, 0x0d, 0x46, 0xbf, 0x01, 0x93, 0x8a, 0x52, 0x4e, 0xed, 0x27, 0xd2,
+	0x80, 0x6f, 0xd7, 0x88, 0xc9, 0x7a, 0xf7, 0x35, 0xf6, 0x66, 0x9b, 0x43, 0xb7, 0xda, 0xdc, 0x31,
+	0x94, 0xe7, 0x98, 0x87, 0xd3, 0x90, 0x87, 0xf6, 0x53, 0xa9, 0xf0, 0xc5, 0x23, 0x37, 0x3a, 0xd3,
+	0x70, 0x75, 0xa1, 0x95, 0xf4, 0xdd, 0x86, 0xb9, 0xfd, 0x85, 0x0d, 0xf3, 0xab, 0xfb, 0x1b, 0xe6,
+	0xce, 0x3b, 0xa8, 0xdf, 0xf6, 0x9d, 0xe8, 0x3c, 0x97, 0xf8, 0x4a, 0x67, 0xb9, 0x58, 0x8a, 0x98,
+	0x2f, 0xc3, 0x78, 0x91, 0x65, 0xb9, 0x22, 0x7e, 0x9f, 0x7b, 0x63, 0xec, 0xbc, 0x85, 0xda, 0x2d,
+	0x3b, 0xff, 0x17, 0x61, 0xe7, 0x6f, 0x06, 0xd4, 0xdc, 0x09, 0x8f, 0x48, 0xa2, 0x47, 0x1b, 0xfa,
+	0x1e, 0x74, 0x0d, 0x44, 0x24, 0x09, 0xa2, 0xa9, 0x56, 0x53, 0x5d, 0xf1, 0x3a, 0x53, 0x11, 0xce,
+	0x50, 0xca, 0x48, 0x7d, 0xf5, 0xb5, 0xe1, 0xd4, 0x8a, 0x35, 0x18, 0x1d, 0x40, 0x3e, 0xa4, 0xaa,
+	0x16, 0xab, 0xcd, 0x9f, 0x3d, 0xec, 0x7a, 0x5f, 0x40, 0x9d, 0x7f, 0x18, 0x50, 0x55, 0x4a, 0xbc,
+	0x25, 0x4e, 0x38, 0xfa, 0x03, 0x94, 0x29, 0x23, 0x17, 0xb2, 0x57, 0x1b, 0x52, 0xcd, 0x77, 0xeb,
+	0x53, 0x42, 0xc2, 0x8e, 0x37, 0xfc, 0x95, 0x08, 0xfa, 0x1d, 0x98, 0x64, 0xc1, 0xe9, 0x42, 0x8d,
+	0xc7, 0xf5, 0x69, 0xd8, 0x93, 0xa0, 0xe3, 0x0d, 0x5f, 0xc3, 0x85, 0x20, 0xc3, 0xe9, 0x22, 0xe6,
+	0x76, 0xfe, 0x41, 0x41, 0x5f, 0x82, 0x84, 0xa0, 0x82, 0x1f, 0x96, 0xa0, 0x88, 0x85, 0xe5, 0xce,
+	0xbf, 0x0d, 0x28, 0x67, 0x36, 0xa1, 0x37, 0xa2, 0x17, 0x60, 0x35, 0xc7, 0xeb, 0xcd, 0xe7, 0x8f,
+	0x5c, 0xa1, 0x31, 0xe0, 0x98, 0xfa, 0x52, 0x42, 0xd6, 0x2b, 0x4e, 0x53, 0x51, 0xe3, 0x7a, 0x1e,
+	0x6a, 0xd2, 0x59, 0x42, 0x41, 0xe0, 0xd0, 0x36, 0x58, 0x83, 0xa1, 0xd7, 0x0f, 0x46, 0xdd, 0x41,
+	0xdf, 0x6b, 0x75, 0x8e, 0x3a, 0x5e, 0xdb, 0xda, 0x40, 0x4f, 0x61, 0xab, 0xdd, 0xfb, 0x63, 0xf7,
+	0xb4, 0xe7, 0xb6, 0x83, 0x41, 0xcb, 0xef, 0xf4, 0x87, 0x96, 0x21, 0xa0, 0x2b, 0x66, 0xdf, 0x6d,
+	0x9d, 0xb8, 0xef, 0x3d, 0x2b, 0x87, 0x10, 0xd4, 0xfb, 0xbe, 0xd7, 0x77, 0x7d, 0x2f, 0x38, 0x1c,
+	0x75, 0xdb, 0xa7, 0x9e, 0x95, 0x47, 0x75, 0x00, 0x7f, 0xd4, 0xcd, 0x24, 0x0b, 0xa8, 0x02, 0xc5,
+	0xd6, 0xa9, 0xe7, 0x76, 0xad, 0xa2, 0xf3, 0x17, 0x30, 0x95, 0xbb, 0xd0, 0x3b, 0x30, 0x53, 0xce,
+	0x70, 0x38, 0x7f, 0xe4, 0x5e, 0x0a, 0xde, 0x18, 0x48, 0xac, 0xaf, 0x65, 0x44, 0x7f, 0x8c, 0xa3,
+	0x64, 0xd5, 0xc4, 0xc5, 0xda, 0xd9, 0x05, 0x53, 0xa1, 0x10, 0x80, 0x39, 0x18, 0xb6, 0x7b, 0xa3,
+	0xa1, 0xb5, 0xa1, 0xd7, 0x9e, 0xef, 0x5b, 0x86, 0xf3, 0x11, 0x4c, 0xe5, 0x73, 0xf1, 0x1e, 0xd0,
+	0x21, 0x52, 0x09, 0xab, 0x29, 0xf4, 0x13, 0xa8, 0xe0, 0x4f, 0x11, 0x0f, 0x26, 0xd9, 0x23, 0xac,
+	0xe8, 0x97, 0x05, 0xa3, 0x25, 0x1e, 0x62, 0x4d, 0x28, 0x62, 0xc6, 0x08, 0xd3, 0x61, 0x5d, 0xd7,
+	0x5f, 0x3c, 0x81, 0xf1, 0x15, 0xd4, 0xf9, 0x6c, 0x40, 0x51, 0x32, 0xd0, 0x6b, 0x28, 0x48, 0xad,
+	0xea, 0xba, 0xdf, 0x3f, 0x24, 0xdc, 0x10, 0xc7, 0xf9, 0x12, 0xfe, 0x40, 0x0c, 0xff, 0x0a, 0x05,
+	0x69, 0xd6, 0x36, 0x58, 0xad, 0x5e, 0xdb, 0xbb, 0x13, 0xc3, 0x6d, 0xb0, 0x3a, 0xdd, 0x0f, 0xee,
+	0x69, 0xa7, 0x1d, 0xb8, 0xfe, 0xfb, 0xd1, 0x99, 0xd7, 0x15, 0x41, 0xbc, 0x19, 0xd9, 0x23, 0xb7,
+	0x73, 0xea, 0xb5, 0xad, 0x1c, 0x7a, 0x02, 0x35, 0x15, 0xab, 0x8c, 0x95, 0x17, 0x61, 0xd5, 0x2c,
+	0xf7, 0xb0, 0xe7, 0x0f, 0xbd, 0xb6, 0x55, 0x40, 0x5b, 0x50, 0x1d, 0x75, 0x07, 0xa3, 0x7e, 0x5f,
+	0x31, 0x8a, 0x0e, 0x81, 0xaa, 0x6c, 0x44, 0x3e, 0xa6, 0x7a, 0xfc, 0x85, 0x94, 0x06, 0x72, 0xb8,
+	0xea, 0x67, 0x5b, 0x48, 0xe9, 0x50, 0xcc, 0x57, 0xbd, 0x25, 0x67, 0x71, 0x6e, 0xb5, 0xd5, 0x15,
+	0xe3, 0xf8, 0x19, 0x98, 0x33, 0x1c, 0xc6, 0x7c, 0xb6, 0x7a, 0x65, 0x4a, 0x4a, 0x8e, 0xe9, 0x68,
+	0x8e, 0xf5, 0xa0, 0x95, 0x6b, 0xe7, 0x9f, 0x06, 0xd4, 0xd4, 0x61, 0x2d, 0xfd, 0x34, 0xf9, 0xff,
+	0xce, 0x3c, 0xba, 0x7e, 0xea, 0xe4, 0x65, 0x34, 0x5e, 0xac, 0xad, 0xd0, 0x1b, 0x87, 0x35, 0xf4,
+	0xff, 0xea, 0x61, 0xe4, 0xbc, 0x85, 0x52, 0x66, 0xc8, 0xd7, 0xf0, 0xb4, 0xd5, 0x3b, 0x3b, 0x73,
+	0xbb, 0xed, 0x3b, 0x71, 0xa8, 0x03, 0x0c, 0x86, 0xbd, 0x7e, 0xd0, 0x3a, 0xf6, 0x5a, 0x27, 0x96,
+	0x81, 0x4a, 0x90, 0x77, 0x5b, 0x27, 0x56, 0xee, 0x57, 0x11, 0x98, 0xaa, 0x59, 0xa1, 0x67, 0x80,
+	0xdc, 0xd6, 0xb0, 0xd3, 0xeb, 0xde, 0x11, 0xad, 0x42, 0xa9, 0xd3, 0x1d, 0x0c, 0xdd, 0xd3, 0x53,
+	0xcb, 0x10, 0x45, 0x34, 0x18, 0xba, 0xfe, 0xd0, 0xca, 0xa1, 0x32, 0x14, 0x84, 0x4a, 0x2b, 0x2f,
+	0x10, 0xbe, 0xa7, 0xd8, 0x05, 0x54, 0x83, 0xca, 0xa8, 0x9b, 0x09, 0x14, 0x65, 0xd5, 0xc9, 0x33,
+	0xcd, 0xe6, 0x7f, 0x0c, 0x28, 0xba, 0xe2, 0x4e, 0xa8, 0x07, 0x05, 0xf1, 0x25, 0x82, 0x9c, 0x75,
+	0x5d, 0xe4, 0xfa, 0xab, 0x65, 0xe7, 0x87, 0x07, 0x31, 0xfa, 0xa9, 0xee, 0x43, 0x49, 0xbf, 0xde,
+	0xd1, 0xba, 0xe6, 0x9a, 0x7d, 0x97, 0xec, 0xfc, 0x7c, 0x0d, 0xe0, 0xee, 0xf3, 0xbf, 0x0f, 0xb9,
+	0x36, 0x41, 0xcf, 0x1f, 0x1e, 0x13, 0xda, 0x48, 0xe7, 0x41, 0x94, 0x9c, 0x03, 0x07, 0x46, 0x73,
+	0x0c, 0xe5, 0x9e, 0x86, 0xa0, 0x0f, 0x60, 0xaa, 0xb0, 0xae, 0x75, 0xc2, 0x8d, 0xa4, 0xde, 0x79,
+	0xfe, 0x25, 0x99, 0xb1, 0x67, 0x1c, 0x18, 0x87, 0xed, 0x3f, 0x1d, 0x5e, 0x44, 0x7c, 0xb6, 0x18,
+	0x37, 0x26, 0x64, 0xbe, 0x7f, 0x1e, 0xb2, 0x39, 0x66, 0x2f, 0x67, 0x0b, 0x1e, 0x92, 0x7d, 0xa1,
+	0xe2, 0x65, 0xa6, 0x63, 0x9f, 0x5e, 0x5e, 0xe8, 0x8f, 0x49, 0xf9, 0x4b, 0xc7, 0xfb, 0xcb, 0x57,
+	0x6f, 0xf5, 0x72, 0x6c, 0xca, 0xef, 0xca, 0x5f, 0xff, 0x77, 0x00, 0xf0, 0x50, 0x83, 0xb3, 0x75,
+	0x0e, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// AgentClient is the client API for Agent service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type AgentClient interface {
+	// Ping return the version and mode of the agent, it's used to tell the agent is ready.
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
+	// SetNode tells the agent how to reach the apiserver, see agent.NodeInfo.
+	SetNode(ctx context.Context, in *NodeInfo, opts ...grpc.CallOption) (*SetNodeResponse, error)
+	// Do runs an action of an app. Progress and output of the script are streamed while it
+	// runs, the last message of the stream is always a Result.
+	Do(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (Agent_DoClient, error)
+}
+
+type agentClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAgentClient(cc *grpc.ClientConn) AgentClient {
+	return &agentClient{cc}
+}
+
+func (c *agentClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error) {
+	out := new(PingResponse)
+	err := c.cc.Invoke(ctx, "/paasoperator.agent.v1.Agent/Ping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) SetNode(ctx context.Context, in *NodeInfo, opts ...grpc.CallOption) (*SetNodeResponse, error) {
+	out := new(SetNodeResponse)
+	err := c.cc.Invoke(ctx, "/paasoperator.agent.v1.Agent/SetNode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Do(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (Agent_DoClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Agent_serviceDesc.Streams[0], "/paasoperator.agent.v1.Agent/Do", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentDoClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Agent_DoClient interface {
+	Recv() (*ActionEvent, error)
+	grpc.ClientStream
+}
+
+type agentDoClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentDoClient) Recv() (*ActionEvent, error) {
+	m := new(ActionEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AgentServer is the server API for Agent service.
+type AgentServer interface {
+	// Ping return the version and mode of the agent, it's used to tell the agent is ready.
+	Ping(context.Context, *PingRequest) (*PingResponse, error)
+	// SetNode tells the agent how to reach the apiserver, see agent.NodeInfo.
+	SetNode(context.Context, *NodeInfo) (*SetNodeResponse, error)
+	// Do runs an action of an app. Progress and output of the script are streamed while it
+	// runs, the last message of the stream is always a Result.
+	Do(*ActionRequest, Agent_DoServer) error
+}
+
+func RegisterAgentServer(s *grpc.Server, srv AgentServer) {
+	s.RegisterService(&_Agent_serviceDesc, srv)
+}
+
+func _Agent_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/paasoperator.agent.v1.Agent/Ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Ping(ctx, req.(*PingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_SetNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).SetNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/paasoperator.agent.v1.Agent/SetNode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).SetNode(ctx, req.(*NodeInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Do_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ActionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).Do(m, &agentDoServer{stream})
+}
+
+type Agent_DoServer interface {
+	Send(*ActionEvent) error
+	grpc.ServerStream
+}
+
+type agentDoServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentDoServer) Send(m *ActionEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Agent_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "paasoperator.agent.v1.Agent",
+	HandlerType: (*AgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Ping",
+			Handler:    _Agent_Ping_Handler,
+		},
+		{
+			MethodName: "SetNode",
+			Handler:    _Agent_SetNode_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Do",
+			Handler:       _Agent_Do_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/agent/v1/agent.proto",
+}
+
+// OperatorClient is the client API for Operator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type OperatorClient interface {
+	// Report streams check results of all apps on the node. The apiserver sends a
+	// ReportCommand back for every report, in the same order.
+	Report(ctx context.Context, opts ...grpc.CallOption) (Operator_ReportClient, error)
+}
+
+type operatorClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewOperatorClient(cc *grpc.ClientConn) OperatorClient {
+	return &operatorClient{cc}
+}
+
+func (c *operatorClient) Report(ctx context.Context, opts ...grpc.CallOption) (Operator_ReportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Operator_serviceDesc.Streams[0], "/paasoperator.agent.v1.Operator/Report", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &operatorReportClient{stream}
+	return x, nil
+}
+
+type Operator_ReportClient interface {
+	Send(*CheckReport) error
+	Recv() (*ReportCommand, error)
+	grpc.ClientStream
+}
+
+type operatorReportClient struct {
+	grpc.ClientStream
+}
+
+func (x *operatorReportClient) Send(m *CheckReport) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *operatorReportClient) Recv() (*ReportCommand, error) {
+	m := new(ReportCommand)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// OperatorServer is the server API for Operator service.
+type OperatorServer interface {
+	// Report streams check results of all apps on the node. The apiserver sends a
+	// ReportCommand back for every report, in the same order.
+	Report(Operator_ReportServer) error
+}
+
+func RegisterOperatorServer(s *grpc.Server, srv OperatorServer) {
+	s.RegisterService(&_Operator_serviceDesc, srv)
+}
+
+func _Operator_Report_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(OperatorServer).Report(&operatorReportServer{stream})
+}
+
+type Operator_ReportServer interface {
+	Send(*ReportCommand) error
+	Recv() (*CheckReport, error)
+	grpc.ServerStream
+}
+
+type operatorReportServer struct {
+	grpc.ServerStream
+}
+
+func (x *operatorReportServer) Send(m *ReportCommand) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *operatorReportServer) Recv() (*CheckReport, error) {
+	m := new(CheckReport)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _Operator_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "paasoperator.agent.v1.Operator",
+	HandlerType: (*OperatorServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Report",
+			Handler:       _Operator_Report_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/agent/v1/agent.proto",
+}
@@ -2,8 +2,12 @@ package agent
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 type Action string
@@ -28,11 +32,40 @@ var ActionMap = map[Action]struct{}{
 
 var WorkDir = "/opt/app/"
 
+// appsDir is the dir under WorkDir holding the dirs of all apps, like:
+// /opt/app/apps/<type>/<name>/<version>/
+const appsDir = "apps"
+
+// defaultVersion is used when the app doesn't declare a version
+const defaultVersion = "default"
+
+// DownloadRetries is how many times the agent retries all mirrors of a download
+var DownloadRetries = 3
+
+// DownloadRateLimit is the max bytes per second of a download, 0 means no limit
+var DownloadRateLimit int64
+
 func init() {
 	// TODO This environment is in the vm
 	if os.Getenv("AGENT_WORK_DIR") != "" {
 		WorkDir = os.Getenv("AGENT_WORK_DIR")
 	}
+	if v := os.Getenv("AGENT_DOWNLOAD_RETRIES"); v != "" {
+		retries, err := strconv.Atoi(v)
+		if err != nil {
+			log.Printf("Warning: AGENT_DOWNLOAD_RETRIES <%s> is illegal, use default value: %d", v, DownloadRetries)
+		} else {
+			DownloadRetries = retries
+		}
+	}
+	if v := os.Getenv("AGENT_DOWNLOAD_RATE_LIMIT"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Printf("Warning: AGENT_DOWNLOAD_RATE_LIMIT <%s> is illegal, download without limit", v)
+		} else {
+			DownloadRateLimit = limit
+		}
+	}
 }
 
 // AppInfo include all info the agent will use to control a app.
@@ -49,20 +82,103 @@ type AppInfo struct {
 	Uninstall    string `json:"uninstall"`
 	Check        string `json:"check"`
 	Package      string `json:"package"`
-	// all metadata will inject to script as a param, like:
-	// for k, v := range appInfo.Metadata {
-	//	  args += k + "=" + v + " "
-	// }
-	// sh xxx.sh args
+	Version      string `json:"version"`
+	// Mirrors are tried in order after RepoURL when downloading scripts and the package
+	Mirrors []string `json:"mirrors"`
+	// Checksums are the sha256 digests of scripts and the package, eg. {"install.sh":"9f86d0..."}
+	Checksums map[string]string `json:"checksums"`
+	// Bundle holds all scripts of the app version, scripts above are ignored if it's set
+	Bundle *Bundle `json:"bundle,omitempty"`
+	// Probes are run by the agent to check the app, the check script is ignored if they are set
+	Probes []Probe `json:"probes,omitempty"`
+	// OperationID identifies one call from the operator, it's passed to scripts as OPERATION_ID
+	OperationID string `json:"operation_id"`
+	// HostIP is the ip of the host the app is on, it's passed to scripts as HOST_IP
+	HostIP string `json:"host_ip"`
+	// RelayToken is the token of the relay at OperatorIp if the host is in a zone, see Relay
+	RelayToken string `json:"relay_token,omitempty"`
+	// OperatorGRPCPort is the port of the grpc api of the apiserver, see NodeInfo
+	OperatorGRPCPort string `json:"operator_grpc_port,omitempty"`
+	// all metadata will inject to script as environment variables, see scriptEnv:
+	// APP_USER=mysql APP_PASSWD=xxx sh xxx.sh
 	Metadata map[string]string `json:"metadata"`
 }
 
-// Print return a string desc with AppInfo; If some error occur, return err.Error()
+// redacted replaces the values which may be secrets in logs
+const redacted = "******"
+
+// Print return a string desc with AppInfo, values of metadata and the relay token are redacted;
+// If some error occur, return err.Error()
 func (ai *AppInfo) Print() string {
-	bytes, err := json.MarshalIndent(ai, "", " ")
+	printed := *ai
+	if printed.RelayToken != "" {
+		printed.RelayToken = redacted
+	}
+	if len(ai.Metadata) > 0 {
+		printed.Metadata = make(map[string]string, len(ai.Metadata))
+		for k := range ai.Metadata {
+			printed.Metadata[k] = redacted
+		}
+	}
+	bytes, err := json.MarshalIndent(&printed, "", " ")
 	if err != nil {
 		log.Println(err.Error())
 		return err.Error()
 	}
 	return string(bytes)
 }
+
+// ScriptName return the name of the script for the action, eg. install.sh
+func (ai *AppInfo) ScriptName(action Action) string {
+	switch action {
+	case Install:
+		return ai.Install
+	case Start:
+		return ai.Start
+	case Stop:
+		return ai.Stop
+	case Restart:
+		return ai.Restart
+	case Uninstall:
+		return ai.Uninstall
+	case Check:
+		return ai.Check
+	}
+	return ""
+}
+
+// AppDir return the dir where all scripts of the app version are kept and executed.
+// eg. /opt/app/apps/database/mysql-5.7-xxx/5.7.26/
+func (ai *AppInfo) AppDir() (string, error) {
+	version := ai.Version
+	if version == "" && ai.Bundle != nil {
+		version = ai.Bundle.Version
+	}
+	if version == "" {
+		version = defaultVersion
+	}
+	for _, elem := range []string{ai.Type, ai.Name, version} {
+		if err := validatePathElem(elem); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(WorkDir, appsDir, ai.Type, ai.Name, version), nil
+}
+
+// cleanAppDir remove the dirs of all versions of the app.
+func cleanAppDir(appType, name string) error {
+	for _, elem := range []string{appType, name} {
+		if err := validatePathElem(elem); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join(WorkDir, appsDir, appType, name))
+}
+
+// validatePathElem make sure elem can't escape from the dir it joined to.
+func validatePathElem(elem string) error {
+	if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, `/\`) {
+		return errors.New("illegal path element: <" + elem + ">")
+	}
+	return nil
+}
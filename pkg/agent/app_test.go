@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppDir(t *testing.T) {
+	cases := []struct {
+		info   AppInfo
+		expect string
+		ok     bool
+	}{
+		{AppInfo{Name: "mysql", Type: "database", Version: "5.7"}, filepath.Join(WorkDir, appsDir, "database", "mysql", "5.7"), true},
+		{AppInfo{Name: "mysql", Type: "database"}, filepath.Join(WorkDir, appsDir, "database", "mysql", defaultVersion), true},
+		{AppInfo{Name: "../mysql", Type: "database"}, "", false},
+		{AppInfo{Name: "mysql", Type: "database", Version: ".."}, "", false},
+		{AppInfo{Name: "mysql"}, "", false},
+	}
+
+	for _, c := range cases {
+		dir, err := c.info.AppDir()
+		if (err == nil) != c.ok {
+			t.Errorf("AppDir of %+v: expect ok=%v, got err %v", c.info, c.ok, err)
+			continue
+		}
+		if dir != c.expect {
+			t.Errorf("AppDir of %+v: expect <%s>, got <%s>", c.info, c.expect, dir)
+		}
+	}
+}
+
+func TestScriptAndPackageStayInAppDir(t *testing.T) {
+	appDir := filepath.Join(WorkDir, appsDir, "database", "mysql", "5.7")
+	for _, name := range []string{"../install.sh", "../../../etc/cron.d/x", ".."} {
+		if _, err := getScript(appDir, name, &AppInfo{}); err == nil {
+			t.Errorf("script <%s> escapes the app dir, expect an error", name)
+		}
+		if _, err := getPackage(appDir, &AppInfo{Package: name}); err == nil {
+			t.Errorf("package <%s> escapes the app dir, expect an error", name)
+		}
+	}
+}
+
+func TestPrintRedactsMetadata(t *testing.T) {
+	ai := &AppInfo{Name: "mysql", Metadata: map[string]string{"PASSWORD": "s3cret"}}
+	if printed := ai.Print(); strings.Contains(printed, "s3cret") || !strings.Contains(printed, "PASSWORD") {
+		t.Errorf("expect values of metadata redacted, got %s", printed)
+	}
+	if ai.Metadata["PASSWORD"] != "s3cret" {
+		t.Errorf("Print changed the metadata of the app")
+	}
+}
@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestName is the manifest at the root of every bundle
+const manifestName = "manifest.json"
+
+// bundleMark is written into the app dir after a bundle is unpacked, it holds the bundle's digest
+const bundleMark = ".bundle"
+
+// Bundle is a single archive (tar.gz) holding all scripts of an app version and a manifest.
+type Bundle struct {
+	// Name of the archive on repo_url or mirrors, eg. mysql-5.7.26.tar.gz
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	SHA256  string `json:"sha256"`
+}
+
+// BundleManifest describes the content of a bundle, eg.
+//
+//	{
+//	  "name": "mysql",
+//	  "version": "5.7.26",
+//	  "scripts": {"install": "scripts/install.sh", "start": "scripts/start.sh", ...}
+//	}
+type BundleManifest struct {
+	Name    string            `json:"name"`
+	Version string            `json:"version"`
+	Scripts map[Action]string `json:"scripts"`
+}
+
+// ScriptPath return the path of the script for the action in appDir
+func (m *BundleManifest) ScriptPath(appDir string, action Action) (string, error) {
+	script, ok := m.Scripts[action]
+	if !ok || script == "" {
+		return "", fmt.Errorf("bundle <%s:%s> has no script for action <%s>", m.Name, m.Version, action)
+	}
+	return safeJoin(appDir, script)
+}
+
+// prepareBundle makes sure the bundle is unpacked into appDir and return its manifest.
+// The bundle is downloaded and unpacked again only if its digest changed.
+func prepareBundle(appDir string, appInfo *AppInfo) (*BundleManifest, error) {
+	b := appInfo.Bundle
+	if b.Name == "" || b.Version == "" || b.SHA256 == "" {
+		return nil, errors.New("bundle needs name, version and sha256")
+	}
+
+	digest, _ := ioutil.ReadFile(filepath.Join(appDir, bundleMark))
+	if strings.TrimSpace(string(digest)) != normalizeDigest(b.SHA256) {
+		d := NewDownloader(append([]string{appInfo.RepoURL}, appInfo.Mirrors...)...)
+		archive := filepath.Join(d.CacheDir, "bundles", b.Name)
+		got, err := d.Fetch(b.Name, b.SHA256, archive)
+		if err != nil {
+			return nil, err
+		}
+		if err := unpackBundle(archive, appDir, got); err != nil {
+			return nil, err
+		}
+		log.Printf("bundle <%s> unpacked into <%s>", b.Name, appDir)
+	}
+
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(appDir, manifestName))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest of bundle <%s> failed: %s", b.Name, err)
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest of bundle <%s> failed: %s", b.Name, err)
+	}
+	if manifest.Version != b.Version {
+		return nil, fmt.Errorf("bundle <%s> has version <%s>, but expect <%s>", b.Name, manifest.Version, b.Version)
+	}
+	return &manifest, nil
+}
+
+// unpackBundle replaces appDir with the content of archive, and marks it with digest.
+func unpackBundle(archive, appDir, digest string) error {
+	tmpDir := appDir + ".unpacking"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := untarGz(archive, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, bundleMark), []byte(digest), 0644); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+
+	// files of the old bundle (and the package in it) are dropped together
+	if err := os.RemoveAll(appDir); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, appDir)
+}
+
+func untarGz(archive, dst string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := safeJoin(dst, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)|0600)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		default:
+			// links and devices may point out of the app dir
+			log.Printf("skip <%s> in bundle, type %c isn't supported", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// bundleHasPackage return true if the package of the app is unpacked from its bundle
+func bundleHasPackage(appDir string, appInfo *AppInfo) bool {
+	if appInfo.Bundle == nil {
+		return false
+	}
+	path, err := safeJoin(appDir, appInfo.Package)
+	if err != nil {
+		return false
+	}
+	exist, _ := pathExists(path)
+	return exist
+}
+
+// safeJoin joins name to dir, name must stay in dir
+func safeJoin(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if path != filepath.Clean(dir) && !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", errors.New("illegal path in bundle: <" + name + ">")
+	}
+	return path, nil
+}
+
+func normalizeDigest(digest string) string {
+	return strings.ToLower(strings.TrimPrefix(digest, "sha256:"))
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeBundle(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPrepareBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+
+	bundle := makeBundle(t, map[string]string{
+		"manifest.json":      `{"name":"mysql","version":"5.7.26","scripts":{"install":"scripts/install.sh"}}`,
+		"scripts/install.sh": "exit 0\n",
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bundle)
+	}))
+	defer server.Close()
+
+	appInfo := &AppInfo{
+		Name:    "mysql",
+		Type:    "database",
+		RepoURL: server.URL,
+		Bundle:  &Bundle{Name: "mysql-5.7.26.tar.gz", Version: "5.7.26", SHA256: hashString(string(bundle))},
+	}
+	appDir, err := appInfo.AppDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(appDir) != "5.7.26" {
+		t.Errorf("app dir should use the bundle version, got <%s>", appDir)
+	}
+
+	manifest, err := prepareBundle(appDir, appInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := manifest.ScriptPath(appDir, Install)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exist, _ := pathExists(script); !exist {
+		t.Errorf("script <%s> should be unpacked", script)
+	}
+	if _, err := manifest.ScriptPath(appDir, Start); err == nil {
+		t.Errorf("expect error for action without script")
+	}
+
+	appInfo.Bundle.Version = "5.7.27"
+	if _, err := prepareBundle(appDir, appInfo); err == nil {
+		t.Errorf("expect error for version mismatch")
+	}
+}
+
+func TestUntarGzRejectsTraversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "evil.tar.gz")
+	if err := ioutil.WriteFile(archive, makeBundle(t, map[string]string{"../evil.sh": "rm -rf /"}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := untarGz(archive, filepath.Join(dir, "app")); err == nil {
+		t.Errorf("expect error for path out of the app dir")
+	}
+}
@@ -0,0 +1,243 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils"
+)
+
+// checkInfoDir is where the agent saves one CheckArg per app, so that all
+// check loops can be restored when the agent restarts.
+const checkInfoDir = "checks"
+
+// legacyCheckInfo is the single check info file used by older agents.
+const legacyCheckInfo = "checkInfo.json"
+
+type CheckArg struct {
+	Name         string   `json:"name"`
+	AppType      string   `json:"apptype"`
+	OperatorIp   string   `json:"operatorip"`
+	OperatorPort string   `json:"operatorport"`
+	WorkDir      string   `json:"workdir"`
+	ScriptPath   string   `json:"scriptpath"`
+	Env          []string `json:"env"`
+	// Probes are run instead of the check script if they are set
+	Probes []Probe `json:"probes,omitempty"`
+	// Args is the "k=v k=v " args saved by older agents, it's converted to Env when restored
+	Args string `json:"args,omitempty"`
+}
+
+// Key identifies the app checked by this CheckArg, eg. database_mysql-5.7-xxx
+func (ca *CheckArg) Key() string {
+	return fmt.Sprintf("%s_%s", ca.AppType, ca.Name)
+}
+
+// checkLoop runs the check script of one app periodically until stop is closed.
+type checkLoop struct {
+	arg  CheckArg
+	stop chan struct{}
+}
+
+// checkers holds all running check loops, one per app.
+type checkers struct {
+	lock  sync.Mutex
+	loops map[string]*checkLoop
+}
+
+var globalCheckers = &checkers{loops: map[string]*checkLoop{}}
+
+// startCheck saves the CheckArg and (re)starts the check loop of the app.
+func startCheck(ca CheckArg) {
+	if err := saveCheckArg(&ca); err != nil {
+		log.Printf("save check info of <%s> failed: %s", ca.Key(), err)
+	}
+	globalCheckers.start(ca)
+}
+
+// stopCheck stops the check loop of the app and forgets its CheckArg.
+func stopCheck(appType, name string) {
+	ca := CheckArg{Name: name, AppType: appType}
+	globalCheckers.stop(ca.Key())
+	if err := os.Remove(checkInfoPath(ca.Key())); err != nil && !os.IsNotExist(err) {
+		log.Printf("remove check info of <%s> failed: %s", ca.Key(), err)
+	}
+}
+
+func (cs *checkers) start(ca CheckArg) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	// the app may be reinstalled with other args, so restart its loop
+	if old, ok := cs.loops[ca.Key()]; ok {
+		close(old.stop)
+	}
+	loop := &checkLoop{arg: ca, stop: make(chan struct{})}
+	cs.loops[ca.Key()] = loop
+	go loop.run()
+	log.Printf("check loop of <%s> started", ca.Key())
+}
+
+func (cs *checkers) stop(key string) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if loop, ok := cs.loops[key]; ok {
+		close(loop.stop)
+		delete(cs.loops, key)
+		log.Printf("check loop of <%s> stopped", key)
+	}
+}
+
+func (l *checkLoop) run() {
+	ca := l.arg
+	if len(ca.Env) == 0 && ca.Args != "" {
+		ca.Env = legacyArgsToEnv(ca.Args)
+	}
+
+	// the period is fixed, reports are sent by the report queue without slowing checks down
+	period := 5 * time.Second
+
+	report := func(msg string) {
+		// trim "xxx{xxx}xxx" to "{xxx}"
+		trimMsg := func(msg string) string {
+			start := strings.Index(msg, "{")
+			end := strings.LastIndex(msg, "}")
+			if start < 0 || end < 0 {
+				return msg
+			}
+			return msg[start : end+1]
+		}
+		msg = trimMsg(msg)
+
+		if !utils.ValidateAppHealthyJson(msg) {
+			log.Printf("Error: Json illeagel:<%s>", msg)
+			return
+		}
+		// kept on disk until the apiserver takes it, through the tunnel if the agent is in tunnel mode
+		enqueueReport(&ca, msg)
+	}
+
+	// probes run on their own intervals, the loop only reports their aggregated state
+	var probes *probeSet
+	if len(ca.Probes) > 0 {
+		probes = newProbeSet(ca.Probes)
+		probes.run(ca.WorkDir, ca.Env, l.stop)
+	}
+
+	var buf bytes.Buffer
+	for {
+		if probes != nil {
+			if msg := probes.healthMsg(); msg != "" {
+				report(msg)
+			}
+		} else if err := execInSystem(ca.WorkDir, []string{ca.ScriptPath}, ca.Env, &buf, false, nil); err != nil {
+			log.Printf("Exec check cmd of <%s> failed: %s", ca.Key(), err)
+		} else {
+			report(buf.String())
+		}
+		buf.Reset()
+
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(period):
+		}
+	}
+}
+
+// TryCheck restores the check loops of all apps saved by a previous agent.
+func TryCheck() {
+	migrateLegacyCheckInfo()
+	// reports queued before the restart are sent even if no app is checked any more
+	globalReports.startSender()
+
+	args, err := loadCheckArgs()
+	if err != nil {
+		log.Printf("load check info failed: %s", err)
+		return
+	}
+	if len(args) == 0 {
+		log.Printf("no check info found; if it's the first time start agent, it's ok.")
+		return
+	}
+	for _, ca := range args {
+		globalCheckers.start(ca)
+	}
+}
+
+func checkInfoPath(key string) string {
+	return filepath.Join(WorkDir, checkInfoDir, key+".json")
+}
+
+func saveCheckArg(ca *CheckArg) error {
+	caBytes, err := json.Marshal(ca)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(WorkDir, checkInfoDir), os.ModePerm); err != nil {
+		return err
+	}
+	// env may contain secrets of the app
+	return ioutil.WriteFile(checkInfoPath(ca.Key()), caBytes, 0600)
+}
+
+// loadCheckArgs reads all CheckArg saved in checkInfoDir, broken files are skipped.
+func loadCheckArgs() ([]CheckArg, error) {
+	files, err := ioutil.ReadDir(filepath.Join(WorkDir, checkInfoDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var args = make([]CheckArg, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		infoBytes, err := ioutil.ReadFile(filepath.Join(WorkDir, checkInfoDir, f.Name()))
+		if err != nil {
+			log.Printf("read check info <%s> failed: %s", f.Name(), err)
+			continue
+		}
+		var ca CheckArg
+		if err := json.Unmarshal(infoBytes, &ca); err != nil {
+			log.Printf("Unmarshal check info <%s> failed: %s", f.Name(), err)
+			continue
+		}
+		args = append(args, ca)
+	}
+	return args, nil
+}
+
+// migrateLegacyCheckInfo moves the checkInfo.json written by older agents
+// into checkInfoDir, so the app it describes keeps being checked.
+func migrateLegacyCheckInfo() {
+	legacyPath := filepath.Join(WorkDir, legacyCheckInfo)
+	infoBytes, err := ioutil.ReadFile(legacyPath)
+	if err != nil {
+		return
+	}
+	var ca CheckArg
+	if err := json.Unmarshal(infoBytes, &ca); err != nil {
+		log.Printf("Unmarshal %s failed: %s", legacyCheckInfo, err)
+		return
+	}
+	if err := saveCheckArg(&ca); err != nil {
+		log.Printf("migrate %s failed: %s", legacyCheckInfo, err)
+		return
+	}
+	if err := os.Remove(legacyPath); err != nil {
+		log.Printf("remove %s failed: %s", legacyCheckInfo, err)
+	}
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckArgsPerApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+
+	mysql := CheckArg{Name: "mysql", AppType: "database", ScriptPath: "check.sh"}
+	redis := CheckArg{Name: "redis", AppType: "database", ScriptPath: "check.sh"}
+	for _, ca := range []CheckArg{mysql, redis} {
+		if err := saveCheckArg(&ca); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args, err := loadCheckArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 {
+		t.Fatalf("expect 2 check args, got %d", len(args))
+	}
+
+	stopCheck(mysql.AppType, mysql.Name)
+	args, err = loadCheckArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0].Name != redis.Name {
+		t.Fatalf("expect only <%s> left, got %v", redis.Name, args)
+	}
+}
+
+func TestMigrateLegacyCheckInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+
+	legacy := `{"name":"mysql","apptype":"database","scriptpath":"/opt/app/check.sh"}`
+	if err := ioutil.WriteFile(filepath.Join(dir, legacyCheckInfo), []byte(legacy), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateLegacyCheckInfo()
+
+	if exist, _ := pathExists(filepath.Join(dir, legacyCheckInfo)); exist {
+		t.Fatalf("%s should be removed after migrating", legacyCheckInfo)
+	}
+	args, err := loadCheckArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0].Key() != "database_mysql" {
+		t.Fatalf("expect database_mysql migrated, got %v", args)
+	}
+}
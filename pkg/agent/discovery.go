@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Capabilities an agent may have, the apiserver refuses to send an action needing one the agent doesn't report.
+// A capability is added when the agent learns a new field or api, and never removed.
+const (
+	// CapProbes runs the probes of an app instead of its check script
+	CapProbes = "probes"
+	// CapBundles prepares the scripts of an app from a bundle
+	CapBundles = "bundles"
+	// CapMirrors downloads scripts and packages from mirrors, verified by checksums
+	CapMirrors = "mirrors"
+	// CapResults returns the result and exit code of a script, and keeps the operation id of retries
+	CapResults = "results"
+	// CapUpgrade upgrades the agent by PUT /upgrade
+	CapUpgrade = "upgrade"
+	// CapGRPC serves the grpc api of protocol v1 on the grpc port of the discovery
+	CapGRPC = "grpc"
+)
+
+// Capabilities are what this agent can do
+var Capabilities = []string{CapProbes, CapBundles, CapMirrors, CapResults, CapUpgrade, CapGRPC}
+
+// Discovery tells the apiserver which protocol features the agent understands
+type Discovery struct {
+	Version      string   `json:"version"`
+	Mode         string   `json:"mode"`
+	Capabilities []string `json:"capabilities"`
+	Actions      []Action `json:"actions"`
+	// GRPCPort is the port of the grpc api, see CapGRPC
+	GRPCPort string `json:"grpc_port,omitempty"`
+}
+
+// HasCapability return true if the capability is in the list
+func HasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Discover return the version, mode, capabilities and actions of the agent
+func Discover(c *gin.Context) {
+	d := Discovery{Version: Version, Mode: Mode, Capabilities: Capabilities, GRPCPort: GRPCPort}
+	for action := range ActionMap {
+		d.Actions = append(d.Actions, action)
+	}
+	sort.Slice(d.Actions, func(i, j int) bool { return d.Actions[i] < d.Actions[j] })
+	c.JSON(http.StatusOK, d)
+}
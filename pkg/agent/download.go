@@ -0,0 +1,380 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheDir is the dir under WorkDir holding all downloaded files, like:
+// /opt/app/cache/sha256/<digest>   the content of a file
+// /opt/app/cache/urls/<hash>.json  what we know about an url, see urlRecord
+// /opt/app/cache/tmp/<hash>.part   a partial download, resumed by next try
+// /opt/app/cache/tmp/<hash>.json   the validators of the partial download, see urlRecord
+const cacheDir = "cache"
+
+// downloading holds the lock of every url downloaded, so concurrent fetches of an url
+// don't write the same partial file, key: hash of the url
+var downloading sync.Map
+
+// Downloader fetches files from mirrors into a content-addressed cache.
+type Downloader struct {
+	// Mirrors are base urls tried in order, eg. http://192.168.19.200:123/ftp/software/mysql/5.7/
+	Mirrors []string
+	// Retries is how many times all mirrors are tried
+	Retries int
+	// RateLimit is the max bytes per second of a download, 0 means no limit
+	RateLimit int64
+	// CacheDir is the root of the content-addressed cache
+	CacheDir string
+	// Header is added to every request, eg. to download from the apiserver through a relay
+	Header http.Header
+
+	client *http.Client
+}
+
+// urlRecord remembers the validators of an url, so an unchanged file isn't downloaded again.
+type urlRecord struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+	Digest       string `json:"digest"`
+}
+
+// NewDownloader return a Downloader for the mirrors with the agent's download settings
+func NewDownloader(mirrors ...string) *Downloader {
+	var ms []string
+	for _, m := range mirrors {
+		if m == "" {
+			continue
+		}
+		if !strings.HasSuffix(m, "/") {
+			m += "/"
+		}
+		ms = append(ms, m)
+	}
+	return &Downloader{
+		Mirrors:   ms,
+		Retries:   DownloadRetries,
+		RateLimit: DownloadRateLimit,
+		CacheDir:  filepath.Join(WorkDir, cacheDir),
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+				ResponseHeaderTimeout: 30 * time.Second,
+			},
+		},
+	}
+}
+
+// Fetch makes dst hold the file named name, and return its sha256 digest.
+// If digest is declared, a cached file with the digest is used without any download,
+// and a downloaded file must match it. Otherwise the file is revalidated with the mirrors
+// every time, so an updated file is always detected.
+func (d *Downloader) Fetch(name, digest, dst string) (string, error) {
+	if err := validatePathElem(name); err != nil {
+		return "", err
+	}
+	digest = normalizeDigest(digest)
+
+	if digest != "" {
+		if exist, _ := pathExists(d.blobPath(digest)); exist {
+			return digest, d.place(digest, dst)
+		}
+	}
+
+	if len(d.Mirrors) == 0 {
+		return "", errors.New("no mirror to download " + name)
+	}
+
+	var lastErr error
+	for i := 0; i <= d.Retries; i++ {
+		if i > 0 {
+			wait := time.Duration(i) * 2 * time.Second
+			log.Printf("download <%s> failed: %s; retry %d/%d after %s", name, lastErr, i, d.Retries, wait)
+			time.Sleep(wait)
+		}
+		for _, mirror := range d.Mirrors {
+			got, err := d.download(mirror+name, digest)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			return got, d.place(got, dst)
+		}
+	}
+	return "", fmt.Errorf("download <%s> failed: %s", name, lastErr)
+}
+
+// download fetches url into the cache and return the digest of its content.
+func (d *Downloader) download(url, digest string) (string, error) {
+	key := hashString(url)
+	lock, _ := downloading.LoadOrStore(key, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	record := d.loadRecord(d.recordPath(key))
+
+	partPath, partRecordPath := d.partPath(key), d.partRecordPath(key)
+	if err := os.MkdirAll(filepath.Dir(partPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+	// a part is resumed only if the file is the same one, or it would be part old and part new
+	var ifRange string
+	if offset > 0 {
+		ifRange = resumeValidator(d.loadRecord(partRecordPath))
+		if ifRange == "" {
+			log.Printf("drop the partial download of <%s>, the file has no validator", url)
+			os.Remove(partPath)
+			offset = 0
+		}
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", ifRange)
+	} else if record != nil && (digest == "" || digest == record.Digest) {
+		if exist, _ := pathExists(d.blobPath(record.Digest)); exist {
+			if record.ETag != "" {
+				req.Header.Set("If-None-Match", record.ETag)
+			}
+			if record.LastModified != "" {
+				req.Header.Set("If-Modified-Since", record.LastModified)
+			}
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	flag := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		log.Printf("<%s> not modified, use cache %s", url, record.Digest)
+		return record.Digest, nil
+	case http.StatusPartialContent:
+		log.Printf("resume <%s> from %d bytes", url, offset)
+		flag |= os.O_APPEND
+	case http.StatusOK:
+		// a changed file is sent whole for If-Range, its validators are kept for resuming
+		flag |= os.O_TRUNC
+		d.saveRecord(partRecordPath, &urlRecord{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")})
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the part may be complete or the file may be changed, start over next time
+		os.Remove(partPath)
+		os.Remove(partRecordPath)
+		return "", fmt.Errorf("get <%s> failed: %s", url, resp.Status)
+	default:
+		return "", fmt.Errorf("get <%s> failed: %s", url, resp.Status)
+	}
+
+	part, err := os.OpenFile(partPath, flag, 0644)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(part, newRateLimitReader(resp.Body, d.RateLimit))
+	if closeErr := part.Close(); err == nil {
+		err = closeErr
+	}
+	// keep the part for resuming
+	if err != nil {
+		return "", err
+	}
+
+	got, err := hashFile(partPath)
+	if err != nil {
+		return "", err
+	}
+	if digest != "" && got != digest {
+		os.Remove(partPath)
+		os.Remove(partRecordPath)
+		return "", fmt.Errorf("sha256 of <%s> mismatch, expect %s but got %s", url, digest, got)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.blobPath(got)), os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.Rename(partPath, d.blobPath(got)); err != nil {
+		return "", err
+	}
+	os.Remove(partRecordPath)
+
+	d.saveRecord(d.recordPath(key), &urlRecord{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Digest:       got,
+	})
+	log.Printf("downloaded <%s>, sha256: %s", url, got)
+	return got, nil
+}
+
+// place makes dst a copy of the cached file with digest, dst is replaced atomically.
+func (d *Downloader) place(digest, dst string) error {
+	if current, err := hashFile(dst); err == nil && current == digest {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := dst + ".tmp"
+	os.Remove(tmp)
+	// a hard link saves the space of big packages, copy if the cache is on another device
+	if err := os.Link(d.blobPath(digest), tmp); err != nil {
+		if err := copyFile(d.blobPath(digest), tmp); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (d *Downloader) blobPath(digest string) string {
+	return filepath.Join(d.CacheDir, "sha256", digest)
+}
+
+func (d *Downloader) recordPath(key string) string {
+	return filepath.Join(d.CacheDir, "urls", key+".json")
+}
+
+func (d *Downloader) partPath(key string) string {
+	return filepath.Join(d.CacheDir, "tmp", key+".part")
+}
+
+func (d *Downloader) partRecordPath(key string) string {
+	return filepath.Join(d.CacheDir, "tmp", key+".json")
+}
+
+// resumeValidator return the If-Range of a partial download, "" if it can't be resumed safely.
+// A weak etag can't be used by If-Range.
+func resumeValidator(record *urlRecord) string {
+	if record == nil {
+		return ""
+	}
+	if record.ETag != "" && !strings.HasPrefix(record.ETag, "W/") {
+		return record.ETag
+	}
+	return record.LastModified
+}
+
+func (d *Downloader) loadRecord(path string) *urlRecord {
+	recordBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var record urlRecord
+	if err := json.Unmarshal(recordBytes, &record); err != nil {
+		return nil
+	}
+	return &record
+}
+
+func (d *Downloader) saveRecord(path string, record *urlRecord) {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		log.Printf("save record of <%s> failed: %s", record.URL, err)
+		return
+	}
+	if err := ioutil.WriteFile(path, recordBytes, 0644); err != nil {
+		log.Printf("save record of <%s> failed: %s", record.URL, err)
+	}
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// rateLimitReader sleeps between reads to keep the average rate under limit bytes per second.
+type rateLimitReader struct {
+	r     io.Reader
+	limit int64
+	start time.Time
+	read  int64
+}
+
+func newRateLimitReader(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &rateLimitReader{r: r, limit: limit, start: time.Now()}
+}
+
+func (l *rateLimitReader) Read(p []byte) (int, error) {
+	// never read more than 1/10 second of data at once, so the rate stays smooth
+	if max := l.limit/10 + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+
+	expect := time.Duration(float64(l.read) / float64(l.limit) * float64(time.Second))
+	if elapsed := time.Since(l.start); expect > elapsed {
+		time.Sleep(expect - elapsed)
+	}
+	return n, err
+}
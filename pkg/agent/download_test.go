@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDownloader(t *testing.T, mirrors ...string) (*Downloader, string) {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDownloader(mirrors...)
+	d.Retries = 0
+	d.CacheDir = filepath.Join(dir, cacheDir)
+	return d, dir
+}
+
+func TestFetchWithMirrorAndChecksum(t *testing.T) {
+	content := "echo install\n"
+	digest := hashString(content)
+
+	var hits int
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		http.ServeContent(w, r, "install.sh", time.Now(), strings.NewReader(content))
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.NotFoundHandler())
+	defer bad.Close()
+
+	d, dir := newTestDownloader(t, bad.URL, good.URL)
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "app", "install.sh")
+
+	got, err := d.Fetch("install.sh", "sha256:"+digest, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != digest {
+		t.Errorf("expect digest %s, got %s", digest, got)
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != content {
+		t.Errorf("expect content <%s>, got <%s>", content, string(b))
+	}
+
+	// a declared digest in cache needs no download
+	os.Remove(dst)
+	if _, err := d.Fetch("install.sh", digest, dst); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 1 {
+		t.Errorf("expect 1 download, got %d", hits)
+	}
+
+	// a wrong digest is never accepted
+	if _, err := d.Fetch("install.sh", hashString("other"), dst); err == nil {
+		t.Errorf("expect checksum mismatch")
+	}
+}
+
+func TestFetchDetectsUpdate(t *testing.T) {
+	content := "v1"
+	modified := time.Now().Add(-time.Hour)
+	var downloads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == "" {
+			downloads++
+		}
+		http.ServeContent(w, r, "check.sh", modified, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	d, dir := newTestDownloader(t, server.URL)
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "app", "check.sh")
+
+	first, err := d.Fetch("check.sh", "", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unchanged file is revalidated only
+	if second, err := d.Fetch("check.sh", "", dst); err != nil || second != first {
+		t.Fatalf("expect cached %s, got %s, %v", first, second, err)
+	}
+
+	content = "v2"
+	modified = time.Now()
+	third, err := d.Fetch("check.sh", "", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first {
+		t.Errorf("updated file should be downloaded again")
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "v2" {
+		t.Errorf("expect updated content, got <%s>", string(b))
+	}
+	if downloads != 1 {
+		t.Errorf("expect 1 unconditional download, got %d", downloads)
+	}
+}
+
+func TestFetchResume(t *testing.T) {
+	content := "0123456789"
+	etag := `"v1"`
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "pkg.tar.gz", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	d, dir := newTestDownloader(t, server.URL)
+	defer os.RemoveAll(dir)
+	key := hashString(server.URL + "/pkg.tar.gz")
+	writePart := func(part string, record *urlRecord) {
+		if err := os.MkdirAll(filepath.Dir(d.partPath(key)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(d.partPath(key), []byte(part), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(d.partRecordPath(key))
+		if record != nil {
+			d.saveRecord(d.partRecordPath(key), record)
+		}
+	}
+
+	cases := []struct {
+		desc   string
+		part   string
+		record *urlRecord
+		ranges []string
+	}{
+		{"resumed", content[:4], &urlRecord{ETag: etag}, []string{"bytes=4-"}},
+		// the file changed on the mirror, it's sent whole by If-Range
+		{"changed", "abcd", &urlRecord{ETag: `"v0"`}, []string{"bytes=4-"}},
+		// without a validator a change can't be detected, the part is dropped
+		{"no validator", "abcd", nil, []string{""}},
+		{"weak etag", "abcd", &urlRecord{ETag: `W/"v1"`}, []string{""}},
+	}
+	for _, c := range cases {
+		ranges = nil
+		os.RemoveAll(filepath.Join(d.CacheDir, "sha256"))
+		writePart(c.part, c.record)
+		dst := filepath.Join(dir, "app", "pkg.tar.gz")
+		os.Remove(dst)
+		if _, err := d.Fetch("pkg.tar.gz", "", dst); err != nil {
+			t.Fatalf("%s: %s", c.desc, err)
+		}
+		if strings.Join(ranges, ",") != strings.Join(c.ranges, ",") {
+			t.Errorf("%s: expect ranges %v, got %v", c.desc, c.ranges, ranges)
+		}
+		if b, _ := ioutil.ReadFile(dst); string(b) != content {
+			t.Errorf("%s: expect content <%s>, got <%s>", c.desc, content, string(b))
+		}
+	}
+}
+
+func TestFetchConcurrently(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// written slowly, so downloads overlap
+		for i := 0; i < len(content); i += 1000 {
+			w.Write([]byte(content[i : i+1000]))
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	d, dir := newTestDownloader(t, server.URL)
+	defer os.RemoveAll(dir)
+
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		go func(i int) {
+			dst := filepath.Join(dir, fmt.Sprintf("app%d", i), "pkg.tar.gz")
+			if _, err := d.Fetch("pkg.tar.gz", "", dst); err != nil {
+				errs <- err
+				return
+			}
+			if b, _ := ioutil.ReadFile(dst); string(b) != content {
+				errs <- fmt.Errorf("expect the whole content in %s, got %d bytes", dst, len(b))
+				return
+			}
+			errs <- nil
+		}(i)
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
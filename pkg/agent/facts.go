@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FactsInterval is how often the agent gathers facts and reports them
+var FactsInterval = 10 * time.Minute
+
+// factTools are the tools scripts often depend on
+var factTools = []string{"expect", "wget", "curl", "tar", "unzip", "sudo", "systemctl", "rpm", "dpkg", "python"}
+
+// Facts describes the host the agent runs on, facts which can't be gathered are left empty.
+type Facts struct {
+	Hostname      string          `json:"hostname"`
+	OS            OSFact          `json:"os"`
+	Kernel        string          `json:"kernel"`
+	Arch          string          `json:"arch"`
+	CPUCores      int             `json:"cpu_cores"`
+	MemoryTotal   uint64          `json:"memory_total"`     // bytes
+	MemoryAvail   uint64          `json:"memory_available"` // bytes
+	Mounts        []MountFact     `json:"mounts"`
+	IPs           []string        `json:"ips"`
+	ListenPorts   []int           `json:"listen_ports"` // tcp
+	Tools         map[string]bool `json:"tools"`        // {"expect":true,"wget":false}
+	CollectedTime string          `json:"collected_time"`
+}
+
+// OSFact is read from /etc/os-release
+type OSFact struct {
+	ID         string `json:"id"`         // centos
+	VersionID  string `json:"version_id"` // 7
+	PrettyName string `json:"pretty_name"`
+}
+
+type MountFact struct {
+	Path   string `json:"path"`
+	Device string `json:"device"`
+	FSType string `json:"fs_type"`
+	Total  uint64 `json:"total"` // bytes
+	Free   uint64 `json:"free"`  // bytes, available to unprivileged users
+}
+
+// GatherFacts return the facts of this host
+func GatherFacts() *Facts {
+	f := &Facts{
+		Arch:          runtime.GOARCH,
+		CPUCores:      runtime.NumCPU(),
+		Tools:         map[string]bool{},
+		CollectedTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	f.Hostname, _ = os.Hostname()
+	f.OS = readOSRelease("/etc/os-release")
+	if b, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		f.Kernel = strings.TrimSpace(string(b))
+	}
+	f.MemoryTotal, f.MemoryAvail = readMemInfo("/proc/meminfo")
+	f.Mounts = readMounts("/proc/mounts")
+	f.IPs = localIPs()
+	f.ListenPorts = listenPorts("/proc/net/tcp", "/proc/net/tcp6")
+	for _, tool := range factTools {
+		_, err := exec.LookPath(tool)
+		f.Tools[tool] = err == nil
+	}
+	return f
+}
+
+func readOSRelease(path string) OSFact {
+	var o OSFact
+	file, err := os.Open(path)
+	if err != nil {
+		return o
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.Trim(kv[1], `"'`)
+		switch kv[0] {
+		case "ID":
+			o.ID = value
+		case "VERSION_ID":
+			o.VersionID = value
+		case "PRETTY_NAME":
+			o.PrettyName = value
+		}
+	}
+	return o
+}
+
+// readMemInfo return the total and available memory in bytes
+func readMemInfo(path string) (uint64, uint64) {
+	var total, avail uint64
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// MemTotal:        8008820 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb * 1024
+		case "MemAvailable:":
+			avail = kb * 1024
+		}
+	}
+	return total, avail
+}
+
+// readMounts return mounts backed by block devices, pseudo file systems are skipped
+func readMounts(path string) []MountFact {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var mounts []MountFact
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// /dev/sda1 / xfs rw,relatime 0 0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "/dev/") || seen[fields[1]] {
+			continue
+		}
+		seen[fields[1]] = true
+		m := MountFact{Device: fields[0], Path: fields[1], FSType: fields[2]}
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(m.Path, &st); err == nil {
+			m.Total = st.Blocks * uint64(st.Bsize)
+			m.Free = st.Bavail * uint64(st.Bsize)
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts
+}
+
+// localIPs return the addresses of all up interfaces except loopback
+func localIPs() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP.String())
+	}
+	return ips
+}
+
+// listenPorts return the sorted tcp ports in LISTEN state found in the /proc/net/tcp* files
+func listenPorts(paths ...string) []int {
+	found := map[int]bool{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			//   sl  local_address rem_address   st ...
+			//    0: 00000000:0CEF 00000000:0000 0A ...
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 || fields[3] != "0A" {
+				continue
+			}
+			i := strings.LastIndex(fields[1], ":")
+			if i < 0 {
+				continue
+			}
+			port, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+			if err != nil {
+				continue
+			}
+			found[int(port)] = true
+		}
+		file.Close()
+	}
+
+	var ports []int
+	for port := range found {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
+}
@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTemp(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFactParsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "facts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	osRelease := writeTemp(t, dir, "os-release", "NAME=\"CentOS Linux\"\nID=\"centos\"\nVERSION_ID=\"7\"\nPRETTY_NAME=\"CentOS Linux 7 (Core)\"\n")
+	if o := readOSRelease(osRelease); o != (OSFact{ID: "centos", VersionID: "7", PrettyName: "CentOS Linux 7 (Core)"}) {
+		t.Errorf("unexpected os release %+v", o)
+	}
+
+	meminfo := writeTemp(t, dir, "meminfo", "MemTotal:        8008820 kB\nMemFree:          171440 kB\nMemAvailable:    4302256 kB\n")
+	if total, avail := readMemInfo(meminfo); total != 8008820*1024 || avail != 4302256*1024 {
+		t.Errorf("unexpected memory %d %d", total, avail)
+	}
+
+	tcp := writeTemp(t, dir, "tcp", "  sl  local_address rem_address   st tx_queue rx_queue\n"+
+		"   0: 00000000:0CEF 00000000:0000 0A 00000000:00000000\n"+
+		"   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000\n"+
+		"   2: 6513A8C0:0016 6413A8C0:D2F0 01 00000000:00000000\n")
+	tcp6 := writeTemp(t, dir, "tcp6", "  sl  local_address rem_address   st\n"+
+		"   0: 00000000000000000000000000000000:0CEF 00000000000000000000000000000000:0000 0A\n")
+	if ports := listenPorts(tcp, tcp6, filepath.Join(dir, "missing")); !reflect.DeepEqual(ports, []int{3306, 3311}) {
+		t.Errorf("unexpected listen ports %v", ports)
+	}
+}
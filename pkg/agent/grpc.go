@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+)
+
+// actionOfPB maps the actions of protocol v1 to the ones of the json api
+var actionOfPB = map[agentpb.Action]Action{
+	agentpb.Action_INSTALL:   Install,
+	agentpb.Action_START:     Start,
+	agentpb.Action_STOP:      Stop,
+	agentpb.Action_RESTART:   Restart,
+	agentpb.Action_UNINSTALL: Uninstall,
+	agentpb.Action_CHECK:     Check,
+}
+
+// ActionToPB return the action of protocol v1, ACTION_UNSPECIFIED if it's unknown
+func ActionToPB(action Action) agentpb.Action {
+	for pb, a := range actionOfPB {
+		if a == action {
+			return pb
+		}
+	}
+	return agentpb.Action_ACTION_UNSPECIFIED
+}
+
+// AppInfoToPB converts the app info of the json api to the one of protocol v1
+func AppInfoToPB(ai *AppInfo) *agentpb.AppInfo {
+	pb := &agentpb.AppInfo{
+		Name:             ai.Name,
+		Type:             ai.Type,
+		OperatorIp:       ai.OperatorIp,
+		OperatorPort:     ai.OperatorPort,
+		RepoUrl:          ai.RepoURL,
+		Install:          ai.Install,
+		Start:            ai.Start,
+		Stop:             ai.Stop,
+		Restart:          ai.Restart,
+		Uninstall:        ai.Uninstall,
+		Check:            ai.Check,
+		Package:          ai.Package,
+		Version:          ai.Version,
+		Mirrors:          ai.Mirrors,
+		Checksums:        ai.Checksums,
+		HostIp:           ai.HostIP,
+		Metadata:         ai.Metadata,
+		RelayToken:       ai.RelayToken,
+		OperatorGrpcPort: ai.OperatorGRPCPort,
+	}
+	if ai.Bundle != nil {
+		pb.Bundle = &agentpb.Bundle{Name: ai.Bundle.Name, Version: ai.Bundle.Version, Sha256: ai.Bundle.SHA256}
+	}
+	for _, p := range ai.Probes {
+		pb.Probes = append(pb.Probes, &agentpb.Probe{
+			Name:               p.Name,
+			Type:               string(p.Type),
+			Address:            p.Address,
+			Url:                p.URL,
+			ExpectStatus:       int32(p.ExpectStatus),
+			ExpectBody:         p.ExpectBody,
+			InsecureSkipVerify: p.InsecureSkipVerify,
+			Process:            p.Process,
+			PidFile:            p.PidFile,
+			Command:            p.Command,
+			IntervalSeconds:    int32(p.IntervalSeconds),
+			TimeoutSeconds:     int32(p.TimeoutSeconds),
+			SuccessThreshold:   int32(p.SuccessThreshold),
+			FailureThreshold:   int32(p.FailureThreshold),
+		})
+	}
+	return pb
+}
+
+// appInfoFromPB converts the app info of protocol v1 to the one of the json api
+func appInfoFromPB(pb *agentpb.AppInfo) *AppInfo {
+	ai := &AppInfo{
+		Name:             pb.Name,
+		Type:             pb.Type,
+		OperatorIp:       pb.OperatorIp,
+		OperatorPort:     pb.OperatorPort,
+		RepoURL:          pb.RepoUrl,
+		Install:          pb.Install,
+		Start:            pb.Start,
+		Stop:             pb.Stop,
+		Restart:          pb.Restart,
+		Uninstall:        pb.Uninstall,
+		Check:            pb.Check,
+		Package:          pb.Package,
+		Version:          pb.Version,
+		Mirrors:          pb.Mirrors,
+		Checksums:        pb.Checksums,
+		HostIP:           pb.HostIp,
+		Metadata:         pb.Metadata,
+		RelayToken:       pb.RelayToken,
+		OperatorGRPCPort: pb.OperatorGrpcPort,
+	}
+	if pb.Bundle != nil {
+		ai.Bundle = &Bundle{Name: pb.Bundle.Name, Version: pb.Bundle.Version, SHA256: pb.Bundle.Sha256}
+	}
+	for _, p := range pb.Probes {
+		ai.Probes = append(ai.Probes, Probe{
+			Name:               p.Name,
+			Type:               ProbeType(p.Type),
+			Address:            p.Address,
+			URL:                p.Url,
+			ExpectStatus:       int(p.ExpectStatus),
+			ExpectBody:         p.ExpectBody,
+			InsecureSkipVerify: p.InsecureSkipVerify,
+			Process:            p.Process,
+			PidFile:            p.PidFile,
+			Command:            p.Command,
+			IntervalSeconds:    int(p.IntervalSeconds),
+			TimeoutSeconds:     int(p.TimeoutSeconds),
+			SuccessThreshold:   int(p.SuccessThreshold),
+			FailureThreshold:   int(p.FailureThreshold),
+		})
+	}
+	return ai
+}
+
+// NodeInfoToPB converts the node info of the json api to the one of protocol v1
+func NodeInfoToPB(info *NodeInfo) *agentpb.NodeInfo {
+	return &agentpb.NodeInfo{
+		OperatorIp:       info.OperatorIp,
+		OperatorPort:     info.OperatorPort,
+		HostIp:           info.HostIP,
+		RelayToken:       info.RelayToken,
+		OperatorGrpcPort: info.OperatorGRPCPort,
+	}
+}
+
+// agentServer serves the Agent service of protocol v1, next to the json api
+type agentServer struct{}
+
+// NewGRPCServer return the grpc server of the agent
+func NewGRPCServer() *grpc.Server {
+	s := grpc.NewServer()
+	agentpb.RegisterAgentServer(s, &agentServer{})
+	return s
+}
+
+// ServeGRPC serves the grpc api on GRPCPort, it returns only on errors
+func ServeGRPC() error {
+	l, err := net.Listen("tcp", ":"+GRPCPort)
+	if err != nil {
+		return err
+	}
+	log.Printf("grpc api on :%s", GRPCPort)
+	return NewGRPCServer().Serve(l)
+}
+
+func (s *agentServer) Ping(ctx context.Context, req *agentpb.PingRequest) (*agentpb.PingResponse, error) {
+	return &agentpb.PingResponse{Version: Version, Mode: Mode, Capabilities: Capabilities}, nil
+}
+
+func (s *agentServer) SetNode(ctx context.Context, pb *agentpb.NodeInfo) (*agentpb.SetNodeResponse, error) {
+	info := &NodeInfo{
+		OperatorIp:       pb.OperatorIp,
+		OperatorPort:     pb.OperatorPort,
+		HostIP:           pb.HostIp,
+		RelayToken:       pb.RelayToken,
+		OperatorGRPCPort: pb.OperatorGrpcPort,
+	}
+	if err := rememberNode(info); err != nil {
+		return nil, err
+	}
+	return &agentpb.SetNodeResponse{Version: Version}, nil
+}
+
+// Do runs the action like DoAction, the steps and the output of the script are streamed before the result.
+// An action refused by the agent still ends with a result, the stream fails only if it's broken.
+func (s *agentServer) Do(req *agentpb.ActionRequest, stream agentpb.Agent_DoServer) error {
+	result := func(code agentpb.Error_Code, err error) error {
+		return stream.Send(&agentpb.ActionEvent{Event: &agentpb.ActionEvent_Result{Result: &agentpb.Result{
+			Result:   string(ResultFailed),
+			ExitCode: -1,
+			Error:    &agentpb.Error{Code: code, Message: err.Error()},
+		}}})
+	}
+	action, ok := actionOfPB[req.Action]
+	if !ok {
+		return result(agentpb.Error_UNSUPPORTED, errors.New("action can't be "+req.Action.String()))
+	}
+	if req.App == nil {
+		return result(agentpb.Error_INVALID_ARGUMENT, errors.New("app is needed"))
+	}
+	appInfo := appInfoFromPB(req.App)
+	appInfo.OperationID = req.OperationId
+
+	log.Println("Action: " + string(action))
+	log.Println("AppInfo: " + appInfo.Print())
+
+	rememberNodeOf(appInfo)
+
+	// the output of stdout and stderr is sent by two goroutines, a stream can't be sent to concurrently.
+	// A broken stream doesn't stop the action, like a dropped connection of the json api.
+	var lock sync.Mutex
+	var broken bool
+	send := func(ev *agentpb.ActionEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		if broken {
+			return
+		}
+		if err := stream.Send(ev); err != nil {
+			log.Printf("Send events of <%s> failed, the action goes on: %s", appInfo.Name, err)
+			broken = true
+		}
+	}
+	events := &actionEvents{
+		progress: func(step agentpb.Progress_Step, msg string) {
+			send(&agentpb.ActionEvent{Event: &agentpb.ActionEvent_Progress{Progress: &agentpb.Progress{Step: step, Message: msg}}})
+		},
+		output: func(out agentpb.Output_Stream, line string) {
+			send(&agentpb.ActionEvent{Event: &agentpb.ActionEvent_Output{Output: &agentpb.Output{Stream: out, Line: line}}})
+		},
+	}
+
+	scriptResult, err := doAction(action, appInfo, events)
+	res := &agentpb.Result{Result: string(scriptResult), ExitCode: int32(exitCodeOf(err))}
+	if err != nil {
+		res.Error = &agentpb.Error{Code: errorCodeOf(err), Message: err.Error()}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	return stream.Send(&agentpb.ActionEvent{Event: &agentpb.ActionEvent_Result{Result: res}})
+}
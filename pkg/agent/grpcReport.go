@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+)
+
+// reportStream is the Report stream of protocol v1 to the apiserver, it's opened when the first report
+// is sent and again after it breaks. It's used by the sender of the report queue only.
+type reportStream struct {
+	addr   string
+	conn   *grpc.ClientConn
+	stream agentpb.Operator_ReportClient
+	cancel context.CancelFunc
+}
+
+// reportGRPCAddr return the address of the grpc api of the apiserver the report goes to, "" if it's sent
+// by the json api: the apiserver doesn't serve grpc, or the agent reaches it by a relay or its tunnel.
+func reportGRPCAddr(r *checkReport) string {
+	info := loadNodeInfo()
+	if info == nil || info.OperatorGRPCPort == "" || info.RelayToken != "" || operatorTunnel() != nil {
+		return ""
+	}
+	if info.OperatorIp != r.OperatorIp {
+		return ""
+	}
+	return net.JoinHostPort(info.OperatorIp, info.OperatorGRPCPort)
+}
+
+// send sends the report and waits for the command of the apiserver about it
+func (rs *reportStream) send(addr string, r *checkReport, timeout time.Duration) (agentpb.ReportCommand_Command, error) {
+	if rs.stream == nil || rs.addr != addr {
+		if err := rs.open(addr, timeout); err != nil {
+			return agentpb.ReportCommand_COMMAND_UNSPECIFIED, err
+		}
+	}
+	report := &agentpb.CheckReport{AppType: r.AppType, AppName: r.Name, Health: r.Msg, Time: r.Time}
+	if err := rs.stream.Send(report); err != nil {
+		rs.close()
+		return agentpb.ReportCommand_COMMAND_UNSPECIFIED, err
+	}
+
+	type reply struct {
+		cmd *agentpb.ReportCommand
+		err error
+	}
+	replies := make(chan reply, 1)
+	go func(stream agentpb.Operator_ReportClient) {
+		cmd, err := stream.Recv()
+		replies <- reply{cmd, err}
+	}(rs.stream)
+
+	select {
+	case rep := <-replies:
+		if rep.err != nil {
+			rs.close()
+			return agentpb.ReportCommand_COMMAND_UNSPECIFIED, rep.err
+		}
+		// commands come in the order of reports, another one means the stream is out of step
+		if rep.cmd.AppType != r.AppType || rep.cmd.AppName != r.Name {
+			rs.close()
+			return agentpb.ReportCommand_COMMAND_UNSPECIFIED,
+				fmt.Errorf("expect the command of <%s_%s>, got <%s_%s>", r.AppType, r.Name, rep.cmd.AppType, rep.cmd.AppName)
+		}
+		return rep.cmd.Command, nil
+	case <-time.After(timeout):
+		// closing the stream ends the Recv
+		rs.close()
+		return agentpb.ReportCommand_COMMAND_UNSPECIFIED, fmt.Errorf("no command from %s in %s", addr, timeout)
+	}
+}
+
+func (rs *reportStream) open(addr string, timeout time.Duration) error {
+	rs.close()
+	dialCtx, cancelDial := context.WithTimeout(context.Background(), timeout)
+	defer cancelDial()
+	conn, err := grpc.DialContext(dialCtx, addr, grpc.WithInsecure(), grpc.WithBlock(), grpc.FailOnNonTempDialError(true))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := agentpb.NewOperatorClient(conn).Report(ctx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return err
+	}
+	rs.addr, rs.conn, rs.stream, rs.cancel = addr, conn, stream, cancel
+	return nil
+}
+
+func (rs *reportStream) close() {
+	if rs.conn == nil {
+		return
+	}
+	rs.cancel()
+	rs.conn.Close()
+	rs.conn, rs.stream, rs.cancel = nil, nil, nil
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+)
+
+// fakeOperator takes the reports of mysql, and stops the checks of other apps
+type fakeOperator struct {
+	lock    sync.Mutex
+	reports []*agentpb.CheckReport
+}
+
+func (o *fakeOperator) Report(stream agentpb.Operator_ReportServer) error {
+	for {
+		report, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cmd := agentpb.ReportCommand_STOP_CHECK
+		if report.AppName == "mysql" {
+			o.lock.Lock()
+			o.reports = append(o.reports, report)
+			o.lock.Unlock()
+			cmd = agentpb.ReportCommand_ACK
+		}
+		if err := stream.Send(&agentpb.ReportCommand{AppType: report.AppType, AppName: report.AppName, Command: cmd}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestReportQueueByGRPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	operator := &fakeOperator{}
+	s := grpc.NewServer()
+	agentpb.RegisterOperatorServer(s, operator)
+	go s.Serve(l)
+	defer s.Stop()
+	_, grpcPort, _ := net.SplitHostPort(l.Addr().String())
+
+	// the json api isn't used while grpc works
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expect reports sent by grpc, got %s %s", r.Method, r.URL.Path)
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	if err := rememberNode(&NodeInfo{OperatorIp: "127.0.0.1", OperatorPort: port, OperatorGRPCPort: grpcPort}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the check loop of an app the apiserver doesn't know is stopped
+	gone := CheckArg{Name: "gone", AppType: "database", OperatorIp: "127.0.0.1", OperatorPort: port, WorkDir: dir, ScriptPath: "check.sh"}
+	startCheck(gone)
+	defer stopCheck(gone.AppType, gone.Name)
+
+	q := &reportQueue{wake: make(chan struct{}, 1)}
+	ran := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	for _, r := range []*checkReport{
+		{AppType: "database", Name: "mysql", OperatorIp: "127.0.0.1", OperatorPort: port, Msg: `{"code":"0","msg":"1"}`, Time: ran},
+		{AppType: "database", Name: "gone", OperatorIp: "127.0.0.1", OperatorPort: port, Msg: `{"code":"0","msg":"x"}`, Time: ran},
+		{AppType: "database", Name: "mysql", OperatorIp: "127.0.0.1", OperatorPort: port, Msg: `{"code":"1","msg":"2"}`, Time: ran},
+	} {
+		if err := q.push(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go q.send(&http.Client{Timeout: time.Second}, stop)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	operator.lock.Lock()
+	defer operator.lock.Unlock()
+	if len(operator.reports) != 2 || operator.reports[0].Health != `{"code":"0","msg":"1"}` ||
+		operator.reports[1].Health != `{"code":"1","msg":"2"}` || operator.reports[1].Time != ran {
+		t.Fatalf("expect reports of mysql in order with the time they ran, got %v", operator.reports)
+	}
+	globalCheckers.lock.Lock()
+	_, ok := globalCheckers.loops[gone.Key()]
+	globalCheckers.lock.Unlock()
+	if ok {
+		t.Errorf("expect the check loop of <%s> stopped by the apiserver", gone.Key())
+	}
+}
+
+func TestReportQueueFallsBackToJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+
+	// nothing serves grpc on the port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, grpcPort, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	var lock sync.Mutex
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		got = append(got, r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	if err := rememberNode(&NodeInfo{OperatorIp: "127.0.0.1", OperatorPort: port, OperatorGRPCPort: grpcPort}); err != nil {
+		t.Fatal(err)
+	}
+
+	q := &reportQueue{wake: make(chan struct{}, 1)}
+	r := &checkReport{AppType: "database", Name: "mysql", OperatorIp: "127.0.0.1", OperatorPort: port,
+		Msg: `{"code":"0","msg":"1"}`, Time: time.Now().Format(time.RFC3339)}
+	if err := q.push(r); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go q.send(&http.Client{Timeout: 200 * time.Millisecond}, stop)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(got) != 1 || got[0] != "/apis/v1alpha1/database/mysql/check" {
+		t.Fatalf("expect the report sent by the json api, got %v", got)
+	}
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+)
+
+func TestAppInfoPB(t *testing.T) {
+	ai := &AppInfo{
+		Name: "mysql", Type: "database", OperatorIp: "192.168.19.100", OperatorPort: "3334", RepoURL: "http://repo/",
+		Install: "install.sh", Check: "check.sh", Package: "mysql.tar.gz", Version: "5.7",
+		Mirrors: []string{"http://mirror/"}, Checksums: map[string]string{"install.sh": "9f86d0"},
+		Bundle: &Bundle{Name: "mysql.tar.gz", Version: "5.7", SHA256: "9f86d0"},
+		Probes: []Probe{{Name: "port", Type: ProbeTCP, Address: "127.0.0.1:3306", FailureThreshold: 3}},
+		HostIP: "192.168.19.101", RelayToken: "secret", OperatorGRPCPort: "3333",
+		Metadata: map[string]string{"APP_USER": "mysql"},
+	}
+	if got := appInfoFromPB(AppInfoToPB(ai)); !reflect.DeepEqual(got, ai) {
+		t.Errorf("expect the app info kept by protocol v1, got %+v", got)
+	}
+	for action := range ActionMap {
+		if got := actionOfPB[ActionToPB(action)]; got != action {
+			t.Errorf("expect action %s kept by protocol v1, got %s", action, got)
+		}
+	}
+}
+
+func TestGRPCDo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+	oldRetries := DownloadRetries
+	DownloadRetries = 0
+	defer func() { DownloadRetries = oldRetries }()
+
+	repo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start.sh":
+			w.Write([]byte("echo starting\necho oops >&2\nexit 0\n"))
+		case "/stop.sh":
+			w.Write([]byte("exit 5\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer repo.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewGRPCServer()
+	go s.Serve(l)
+	defer s.Stop()
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := agentpb.NewAgentClient(conn)
+
+	ping, err := client.Ping(context.Background(), &agentpb.PingRequest{})
+	if err != nil || ping.Version != Version || !HasCapability(ping.Capabilities, CapGRPC) {
+		t.Fatalf("expect the version and capabilities of the agent, got %v, %v", ping, err)
+	}
+
+	do := func(action agentpb.Action, app *agentpb.AppInfo) ([]*agentpb.ActionEvent, *agentpb.Result) {
+		stream, err := client.Do(context.Background(), &agentpb.ActionRequest{OperationId: "op-1", Action: action, App: app})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var events []*agentpb.ActionEvent
+		for {
+			ev, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, ev)
+		}
+		if len(events) == 0 || events[len(events)-1].GetResult() == nil {
+			t.Fatalf("expect a result at the end of the stream, got %v", events)
+		}
+		return events[:len(events)-1], events[len(events)-1].GetResult()
+	}
+	app := &agentpb.AppInfo{Name: "mysql", Type: "database", RepoUrl: repo.URL + "/", Start: "start.sh", Stop: "stop.sh", Install: "install.sh"}
+
+	events, result := do(agentpb.Action_START, app)
+	if result.Result != string(ResultOK) || result.Error != nil {
+		t.Errorf("expect start ok, got %v", result)
+	}
+	var steps []agentpb.Progress_Step
+	var lines []string
+	for _, ev := range events {
+		if p := ev.GetProgress(); p != nil {
+			steps = append(steps, p.Step)
+		}
+		if o := ev.GetOutput(); o != nil {
+			lines = append(lines, o.Stream.String()+" "+o.Line)
+		}
+	}
+	if !reflect.DeepEqual(steps, []agentpb.Progress_Step{agentpb.Progress_DOWNLOAD_SCRIPT, agentpb.Progress_RUN_SCRIPT}) {
+		t.Errorf("expect the script downloaded then run, got %v", steps)
+	}
+	// stdout and stderr are read concurrently
+	if len(lines) != 2 || (lines[0] != "STDOUT starting" && lines[1] != "STDOUT starting") ||
+		(lines[0] != "STDERR oops" && lines[1] != "STDERR oops") {
+		t.Errorf("expect the output of the script, got %v", lines)
+	}
+
+	cases := []struct {
+		action agentpb.Action
+		result ScriptResult
+		code   agentpb.Error_Code
+	}{
+		{agentpb.Action_STOP, ResultAlreadyStopped, agentpb.Error_SCRIPT_FAILED},
+		{agentpb.Action_INSTALL, ResultFailed, agentpb.Error_DOWNLOAD_FAILED},
+		{agentpb.Action_ACTION_UNSPECIFIED, ResultFailed, agentpb.Error_UNSUPPORTED},
+	}
+	for _, c := range cases {
+		_, result := do(c.action, app)
+		if result.Result != string(c.result) || result.Error == nil || result.Error.Code != c.code {
+			t.Errorf("expect %s of %s and %s, got %v", c.action, c.result, c.code, result)
+		}
+	}
+	bad := &agentpb.AppInfo{Name: "../mysql", Type: "database", Start: "start.sh"}
+	if _, result := do(agentpb.Action_START, bad); result.Error == nil || result.Error.Code != agentpb.Error_INVALID_ARGUMENT {
+		t.Errorf("expect an illegal name refused, got %v", result)
+	}
+}
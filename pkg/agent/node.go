@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Port is the port the agent listens on
+var Port = "3335"
+
+// GRPCPort is the port the agent serves the grpc api on, see ServeGRPC
+var GRPCPort = "3337"
+
+// HeartbeatInterval is how often the agent reports itself to the apiserver
+var HeartbeatInterval = 10 * time.Second
+
+// nodeInfoName is the file under WorkDir remembering where the apiserver is
+const nodeInfoName = "node.json"
+
+var startTime = time.Now()
+
+func init() {
+	if os.Getenv("AGENT_PORT") != "" {
+		Port = os.Getenv("AGENT_PORT")
+	}
+	if os.Getenv("AGENT_GRPC_PORT") != "" {
+		GRPCPort = os.Getenv("AGENT_GRPC_PORT")
+	}
+}
+
+// NodeStatus is reported by the agent in every heartbeat
+type NodeStatus struct {
+	IP            string `json:"ip"`
+	Hostname      string `json:"hostname"`
+	AgentVersion  string `json:"agent_version"`
+	AgentPort     string `json:"agent_port"`
+	Mode          string `json:"mode"`       // push or pull, see Mode
+	StartTime     string `json:"start_time"` // 2006-01-02 15:04:05
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	// Capabilities are the protocol features the agent understands, see Discovery
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// NodeInfo is how the agent reaches the apiserver, it's learned from the first action
+// the apiserver sent or from onboarding, or from AGENT_OPERATOR_IP, AGENT_OPERATOR_PORT, AGENT_HOST_IP
+// and AGENT_TUNNEL_TOKEN.
+type NodeInfo struct {
+	OperatorIp   string `json:"operator_ip"`
+	OperatorPort string `json:"operator_port"`
+	HostIP       string `json:"host_ip"`
+	// RelayToken authorizes the agent at the relay if it reaches the apiserver by the relay of its zone
+	RelayToken string `json:"relay_token,omitempty"`
+	// OperatorGRPCPort is the port of the grpc api of the apiserver, check reports are sent by it if it's set
+	OperatorGRPCPort string `json:"operator_grpc_port,omitempty"`
+	// TunnelToken authorizes the tunnel of the agent in tunnel mode, it's issued by the apiserver at onboarding
+	TunnelToken string `json:"tunnel_token,omitempty"`
+}
+
+var nodeLock sync.Mutex
+
+func nodeInfoPath() string {
+	return filepath.Join(WorkDir, nodeInfoName)
+}
+
+// loadNodeInfo return the saved node info overridden by environment, nil if the apiserver is unknown
+func loadNodeInfo() *NodeInfo {
+	nodeLock.Lock()
+	defer nodeLock.Unlock()
+
+	var info NodeInfo
+	if infoBytes, err := ioutil.ReadFile(nodeInfoPath()); err == nil {
+		if err := json.Unmarshal(infoBytes, &info); err != nil {
+			log.Printf("Warning: <%s> is illegal: %s", nodeInfoPath(), err)
+		}
+	}
+	if v := os.Getenv("AGENT_OPERATOR_IP"); v != "" {
+		info.OperatorIp = v
+	}
+	if v := os.Getenv("AGENT_OPERATOR_PORT"); v != "" {
+		info.OperatorPort = v
+	}
+	if v := os.Getenv("AGENT_HOST_IP"); v != "" {
+		info.HostIP = v
+	}
+	if v := os.Getenv("AGENT_RELAY_TOKEN"); v != "" {
+		info.RelayToken = v
+	}
+	if v := os.Getenv("AGENT_TUNNEL_TOKEN"); v != "" {
+		info.TunnelToken = v
+	}
+	if info.OperatorIp == "" || info.OperatorPort == "" {
+		return nil
+	}
+	return &info
+}
+
+// rememberNode saves how the apiserver reached the agent, so heartbeats survive restarts.
+// The saved tunnel token is kept if info hasn't one, only onboarding tells it.
+func rememberNode(info *NodeInfo) error {
+	if info.OperatorIp == "" || info.OperatorPort == "" {
+		return errors.New("operator ip and port are needed")
+	}
+	nodeLock.Lock()
+	defer nodeLock.Unlock()
+
+	var saved NodeInfo
+	infoBytes, err := ioutil.ReadFile(nodeInfoPath())
+	if err == nil && json.Unmarshal(infoBytes, &saved) == nil {
+		if info.TunnelToken == "" {
+			merged := *info
+			merged.TunnelToken = saved.TunnelToken
+			info = &merged
+		}
+		if saved == *info {
+			return nil
+		}
+	}
+	infoBytes, err = json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(WorkDir, os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(nodeInfoPath(), infoBytes, 0600)
+}
+
+// setRelayAuth authorizes a request to the apiserver at the relay of the zone, if the agent reaches it by one
+func setRelayAuth(h http.Header) {
+	if info := loadNodeInfo(); info != nil && info.RelayToken != "" {
+		h.Set("Proxy-Authorization", "Bearer "+info.RelayToken)
+	}
+}
+
+// SetNode is called by the apiserver when it onboards the host, so heartbeats start before any action.
+func SetNode(c *gin.Context) {
+	var info NodeInfo
+	if err := c.ShouldBindJSON(&info); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := rememberNode(&info); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"msg":     "ok",
+		"version": Version,
+	})
+}
+
+// currentNodeStatus return the status of this node, the ip is the one the apiserver knows the host by,
+// or the local address used to reach the apiserver.
+func currentNodeStatus(info *NodeInfo) (*NodeStatus, error) {
+	ip := info.HostIP
+	if ip == "" {
+		conn, err := net.Dial("udp", net.JoinHostPort(info.OperatorIp, info.OperatorPort))
+		if err != nil {
+			return nil, err
+		}
+		ip = conn.LocalAddr().(*net.UDPAddr).IP.String()
+		conn.Close()
+	}
+	hostname, _ := os.Hostname()
+	return &NodeStatus{
+		IP:            ip,
+		Hostname:      hostname,
+		AgentVersion:  Version,
+		AgentPort:     Port,
+		Mode:          Mode,
+		Capabilities:  Capabilities,
+		StartTime:     startTime.Format("2006-01-02 15:04:05"),
+		UptimeSeconds: int64(time.Since(startTime) / time.Second),
+	}, nil
+}
+
+// Heartbeat registers the agent with the apiserver and reports it every HeartbeatInterval, it never returns.
+// Facts of the host are reported after registering and every FactsInterval.
+// It waits until the apiserver is known, see loadNodeInfo.
+func Heartbeat() {
+	c := &http.Client{Timeout: 10 * time.Second}
+	var factsTime time.Time
+	for {
+		if info := loadNodeInfo(); info != nil {
+			status, err := currentNodeStatus(info)
+			if err == nil {
+				err = putToOperator(c, info, fmt.Sprintf("nodes/%s/heartbeat", status.IP), status)
+			}
+			if err != nil {
+				log.Printf("Heartbeat failed: %s", err)
+			} else if time.Since(factsTime) >= FactsInterval {
+				if err := putToOperator(c, info, fmt.Sprintf("nodes/%s/facts", status.IP), GatherFacts()); err != nil {
+					log.Printf("Report facts failed: %s", err)
+				} else {
+					factsTime = time.Now()
+				}
+			}
+		}
+		time.Sleep(HeartbeatInterval)
+	}
+}
+
+// putToOperator puts v as json to the path under /apis/v1alpha1/ of the apiserver, 200 is expected
+func putToOperator(c *http.Client, info *NodeInfo, path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	status, err := operatorDo(c, info.OperatorIp, info.OperatorPort, "PUT", path, body)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return errNotFound
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("%d %s", status, http.StatusText(status))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestRememberNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+
+	if info := loadNodeInfo(); info != nil {
+		t.Fatalf("expect no apiserver before any action, got %+v", info)
+	}
+
+	if err := rememberNode(&NodeInfo{OperatorIp: "192.168.19.100", OperatorPort: "3334", HostIP: "192.168.19.101"}); err != nil {
+		t.Fatal(err)
+	}
+	info := loadNodeInfo()
+	if info == nil || info.OperatorIp != "192.168.19.100" || info.OperatorPort != "3334" || info.HostIP != "192.168.19.101" {
+		t.Fatalf("expect the remembered apiserver, got %+v", info)
+	}
+
+	status, err := currentNodeStatus(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.IP != "192.168.19.101" || status.AgentVersion != Version || status.AgentPort != Port {
+		t.Errorf("unexpected node status %+v", status)
+	}
+}
+
+func TestRememberNodeKeepsTunnelToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+
+	if err := rememberNode(&NodeInfo{OperatorIp: "192.168.19.100", OperatorPort: "3334", TunnelToken: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	// actions don't carry the tunnel token
+	if err := rememberNode(&NodeInfo{OperatorIp: "192.168.19.102", OperatorPort: "3334"}); err != nil {
+		t.Fatal(err)
+	}
+	if info := loadNodeInfo(); info == nil || info.OperatorIp != "192.168.19.102" || info.TunnelToken != "secret" {
+		t.Fatalf("expect the tunnel token kept, got %+v", info)
+	}
+	if err := rememberNode(&NodeInfo{OperatorIp: "192.168.19.102", OperatorPort: "3334", TunnelToken: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if info := loadNodeInfo(); info == nil || info.TunnelToken != "new" {
+		t.Fatalf("expect the new tunnel token, got %+v", info)
+	}
+}
+
+func TestSetRelayAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+
+	h := http.Header{}
+	setRelayAuth(h)
+	if auth := h.Get("Proxy-Authorization"); auth != "" {
+		t.Errorf("expect no token out of a zone, got <%s>", auth)
+	}
+
+	if err := rememberNode(&NodeInfo{OperatorIp: "10.10.0.5", OperatorPort: "3336", RelayToken: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	setRelayAuth(h)
+	if auth := h.Get("Proxy-Authorization"); auth != "Bearer secret" {
+		t.Errorf("expect the token of the relay, got <%s>", auth)
+	}
+}
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// ModePush is the default, the apiserver posts actions to the agent
+	ModePush = "push"
+	// ModePull is for hosts the apiserver can't reach, the agent polls the apiserver for actions
+	ModePull = "pull"
+	// ModeTunnel is for hosts the apiserver can't reach, the agent keeps a websocket to the apiserver
+	// and actions come through it like in push mode, see Tunnel
+	ModeTunnel = "tunnel"
+)
+
+// Mode is how the agent gets actions, set by AGENT_MODE
+var Mode = ModePush
+
+// pollWait is how long the apiserver holds a poll when no operation is pending
+const pollWait = 30 * time.Second
+
+// reportRetries is how many times a result is reported before it's given up
+const reportRetries = 5
+
+// errNotFound is returned by putToOperator if the apiserver doesn't know the resource
+var errNotFound = errors.New("404 Not Found")
+
+// runningOps holds the ids of operations being run in pull mode
+var runningOps sync.Map
+
+func init() {
+	if os.Getenv("AGENT_MODE") != "" {
+		Mode = os.Getenv("AGENT_MODE")
+	}
+	if Mode != ModePush && Mode != ModePull && Mode != ModeTunnel {
+		log.Printf("Warning: AGENT_MODE <%s> is illegal, use default value: %s", Mode, ModePush)
+		Mode = ModePush
+	}
+}
+
+// Operation is an action of an app queued by the apiserver for the agent in pull mode,
+// it's the same as a POST /:action in push mode.
+type Operation struct {
+	ID      string  `json:"operation_id"`
+	Action  Action  `json:"action"`
+	AppInfo AppInfo `json:"app_info"`
+}
+
+// OperationResult is reported by the agent when an operation is done, like the body returned by DoAction
+type OperationResult struct {
+	Result   ScriptResult `json:"result"`
+	ExitCode int          `json:"exit_code"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// PollOperations long-polls the apiserver for operations on this host and runs them, it never returns.
+// Operations run concurrently like actions posted in push mode, results are put back to the apiserver.
+// It waits until the apiserver is known, see loadNodeInfo.
+func PollOperations() {
+	c := &http.Client{Timeout: pollWait + 10*time.Second}
+	for {
+		info := loadNodeInfo()
+		if info == nil {
+			time.Sleep(HeartbeatInterval)
+			continue
+		}
+		status, err := currentNodeStatus(info)
+		if err != nil {
+			log.Printf("Poll operations failed: %s", err)
+			time.Sleep(HeartbeatInterval)
+			continue
+		}
+
+		ops, err := pollOperations(c, info, status.IP)
+		if err != nil {
+			log.Printf("Poll operations failed: %s", err)
+			time.Sleep(HeartbeatInterval)
+			continue
+		}
+		for i := range ops {
+			// an operation is polled again if the apiserver didn't see it running yet
+			if _, loaded := runningOps.LoadOrStore(ops[i].ID, struct{}{}); loaded {
+				continue
+			}
+			go runOperation(c, info, status.IP, ops[i])
+		}
+	}
+}
+
+// pollOperations gets the pending operations of the host, the apiserver returns an empty list after pollWait
+func pollOperations(c *http.Client, info *NodeInfo, ip string) ([]Operation, error) {
+	url := fmt.Sprintf("http://%s/apis/v1alpha1/nodes/%s/operations?wait=%d",
+		net.JoinHostPort(info.OperatorIp, info.OperatorPort), ip, int(pollWait/time.Second))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRelayAuth(req.Header)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	var ops []Operation
+	if err := json.NewDecoder(resp.Body).Decode(&ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// runOperation runs the operation and reports its result, it's retried if the apiserver is unreachable
+func runOperation(c *http.Client, info *NodeInfo, ip string, op Operation) {
+	defer runningOps.Delete(op.ID)
+
+	log.Printf("Operation: %s, Action: %s", op.ID, op.Action)
+	log.Println("AppInfo: " + op.AppInfo.Print())
+
+	var opResult OperationResult
+	if _, ok := ActionMap[op.Action]; !ok {
+		opResult = OperationResult{Result: ResultFailed, ExitCode: -1, Error: "action can't be " + string(op.Action)}
+	} else {
+		op.AppInfo.OperationID = op.ID
+		result, err := doAction(op.Action, &op.AppInfo, nil)
+		opResult = OperationResult{Result: result, ExitCode: exitCodeOf(err)}
+		if err != nil {
+			opResult.Error = err.Error()
+		}
+	}
+
+	path := fmt.Sprintf("nodes/%s/operations/%s", ip, op.ID)
+	for i := 0; i < reportRetries; i++ {
+		err := putToOperator(c, info, path, opResult)
+		if err == nil {
+			return
+		}
+		if err == errNotFound {
+			log.Printf("Operation <%s> is gone from the apiserver, drop its result", op.ID)
+			return
+		}
+		log.Printf("Report result of operation <%s> failed, retry %d/%d: %s", op.ID, i+1, reportRetries, err)
+		time.Sleep(HeartbeatInterval)
+	}
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRunPolledOperation(t *testing.T) {
+	results := make(chan OperationResult, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/apis/v1alpha1/nodes/192.168.19.101/operations":
+			json.NewEncoder(w).Encode([]Operation{{ID: "op-1", Action: "reboot", AppInfo: AppInfo{Name: "mysql", Type: "database"}}})
+		case r.Method == "PUT" && r.URL.Path == "/apis/v1alpha1/nodes/192.168.19.101/operations/op-1":
+			var result OperationResult
+			json.NewDecoder(r.Body).Decode(&result)
+			results <- result
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	info := &NodeInfo{OperatorIp: host, OperatorPort: port, HostIP: "192.168.19.101"}
+	ops, err := pollOperations(server.Client(), info, info.HostIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].ID != "op-1" {
+		t.Fatalf("expect operation op-1, got %+v", ops)
+	}
+
+	// an illegal action fails without running anything
+	runOperation(server.Client(), info, info.HostIP, ops[0])
+	result := <-results
+	if result.Result != ResultFailed || result.Error == "" {
+		t.Errorf("expect a failed result, got %+v", result)
+	}
+	if _, ok := runningOps.Load("op-1"); ok {
+		t.Error("expect op-1 not running after its result is reported")
+	}
+}
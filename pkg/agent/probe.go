@@ -0,0 +1,288 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils"
+)
+
+type ProbeType string
+
+const (
+	ProbeTCP     ProbeType = "tcp"     // connect to Address
+	ProbeHTTP    ProbeType = "http"    // GET URL, http or https
+	ProbeProcess ProbeType = "process" // a process named Process or with the pid in PidFile is alive
+	ProbeExec    ProbeType = "exec"    // Command exits with 0
+)
+
+// Probe is a health check run by the agent natively, without a check script.
+type Probe struct {
+	Name string    `json:"name"`
+	Type ProbeType `json:"type"`
+
+	// tcp, eg. 127.0.0.1:3306
+	Address string `json:"address,omitempty"`
+
+	// http, eg. http://127.0.0.1:8080/health; ExpectStatus is 200 by default,
+	// ExpectBody is a substring the body must contain if it's set
+	URL                string `json:"url,omitempty"`
+	ExpectStatus       int    `json:"expect_status,omitempty"`
+	ExpectBody         string `json:"expect_body,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+	// process, eg. mysqld or /var/run/mysqld/mysqld.pid
+	Process string `json:"process,omitempty"`
+	PidFile string `json:"pid_file,omitempty"`
+
+	// exec, eg. ["mysqladmin", "ping"], run in the app dir with the script environment
+	Command []string `json:"command,omitempty"`
+
+	IntervalSeconds  int `json:"interval_seconds,omitempty"`  // 10 by default
+	TimeoutSeconds   int `json:"timeout_seconds,omitempty"`   // 3 by default
+	SuccessThreshold int `json:"success_threshold,omitempty"` // 1 by default
+	FailureThreshold int `json:"failure_threshold,omitempty"` // 3 by default
+}
+
+// withDefaults return a copy of the probe with all unset numbers defaulted
+func (p Probe) withDefaults() Probe {
+	if p.IntervalSeconds <= 0 {
+		p.IntervalSeconds = 10
+	}
+	if p.TimeoutSeconds <= 0 {
+		p.TimeoutSeconds = 3
+	}
+	if p.SuccessThreshold <= 0 {
+		p.SuccessThreshold = 1
+	}
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = 3
+	}
+	if p.Type == ProbeHTTP && p.ExpectStatus == 0 {
+		p.ExpectStatus = http.StatusOK
+	}
+	if p.Name == "" {
+		p.Name = string(p.Type)
+	}
+	return p
+}
+
+// Validate return an error if the probe misses fields needed by its type
+func (p *Probe) Validate() error {
+	switch p.Type {
+	case ProbeTCP:
+		if _, _, err := net.SplitHostPort(p.Address); err != nil {
+			return fmt.Errorf("tcp probe <%s> needs address like 127.0.0.1:3306: %s", p.Name, err)
+		}
+	case ProbeHTTP:
+		if !strings.HasPrefix(p.URL, "http://") && !strings.HasPrefix(p.URL, "https://") {
+			return fmt.Errorf("http probe <%s> needs an http(s) url, got <%s>", p.Name, p.URL)
+		}
+	case ProbeProcess:
+		if p.Process == "" && p.PidFile == "" {
+			return fmt.Errorf("process probe <%s> needs process or pid_file", p.Name)
+		}
+	case ProbeExec:
+		if len(p.Command) == 0 {
+			return fmt.Errorf("exec probe <%s> needs command", p.Name)
+		}
+	default:
+		return fmt.Errorf("probe <%s> has illegal type <%s>", p.Name, p.Type)
+	}
+	return nil
+}
+
+// probeOnce runs the probe one time, nil means healthy
+func (p *Probe) probeOnce(workDir string, env []string) error {
+	timeout := time.Duration(p.TimeoutSeconds) * time.Second
+
+	switch p.Type {
+	case ProbeTCP:
+		conn, err := net.DialTimeout("tcp", p.Address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProbeHTTP:
+		// the client lives for one run, so no connection is kept for the next one
+		c := &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: p.InsecureSkipVerify},
+				DisableKeepAlives: true,
+			},
+		}
+		resp, err := c.Get(p.URL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != p.ExpectStatus {
+			return fmt.Errorf("expect status %d, got %s", p.ExpectStatus, resp.Status)
+		}
+		if p.ExpectBody != "" {
+			body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(string(body), p.ExpectBody) {
+				return fmt.Errorf("body doesn't contain <%s>", p.ExpectBody)
+			}
+		}
+		return nil
+	case ProbeProcess:
+		if p.PidFile != "" {
+			return pidFileAlive(p.PidFile)
+		}
+		return processAlive(p.Process)
+	case ProbeExec:
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+		cmd.Dir = workDir
+		cmd.Env = append(os.Environ(), env...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	return errors.New("illegal probe type: " + string(p.Type))
+}
+
+func pidFileAlive(pidFile string) error {
+	pidBytes, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil {
+		return fmt.Errorf("pid file <%s> is illegal: %s", pidFile, err)
+	}
+	// signal 0 checks the process exists without touching it; EPERM means it exists but isn't ours
+	if err := syscall.Kill(pid, syscall.Signal(0)); err != nil && err != syscall.EPERM {
+		return fmt.Errorf("process %d in <%s> isn't alive: %s", pid, pidFile, err)
+	}
+	return nil
+}
+
+func processAlive(name string) error {
+	comms, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil {
+		return err
+	}
+	for _, comm := range comms {
+		b, err := ioutil.ReadFile(comm)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(b)) == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("no process named <%s>", name)
+}
+
+// probeState is the thresholded result of one probe
+type probeState struct {
+	known     bool // false until a threshold is reached for the first time
+	healthy   bool
+	successes int // consecutive
+	failures  int // consecutive
+	lastErr   error
+}
+
+// probeSet runs all probes of an app, each on its own interval, and aggregates their states.
+type probeSet struct {
+	lock   sync.Mutex
+	probes []Probe
+	states []probeState
+}
+
+func newProbeSet(probes []Probe) *probeSet {
+	ps := &probeSet{states: make([]probeState, len(probes))}
+	for _, p := range probes {
+		ps.probes = append(ps.probes, p.withDefaults())
+	}
+	return ps
+}
+
+// run starts a goroutine for every probe, they exit when stop is closed
+func (ps *probeSet) run(workDir string, env []string, stop <-chan struct{}) {
+	for i := range ps.probes {
+		go func(i int) {
+			p := ps.probes[i]
+			for {
+				ps.record(i, p.probeOnce(workDir, env))
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Duration(p.IntervalSeconds) * time.Second):
+				}
+			}
+		}(i)
+	}
+}
+
+func (ps *probeSet) record(i int, err error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	p := ps.probes[i]
+	s := &ps.states[i]
+	s.lastErr = err
+	if err == nil {
+		s.successes++
+		s.failures = 0
+		if s.successes >= p.SuccessThreshold {
+			s.known, s.healthy = true, true
+		}
+	} else {
+		s.failures++
+		s.successes = 0
+		if s.failures >= p.FailureThreshold {
+			s.known, s.healthy = true, false
+		}
+	}
+}
+
+// healthMsg return the app healthy json like a check script prints, see utils.AppHealthy;
+// it return "" if some probe hasn't reached a threshold yet.
+func (ps *probeSet) healthMsg() string {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	var failed []string
+	for i, s := range ps.states {
+		if !s.known {
+			return ""
+		}
+		if !s.healthy {
+			failed = append(failed, fmt.Sprintf("%s: %v", ps.probes[i].Name, s.lastErr))
+		}
+	}
+	ah := utils.AppHealthy{Code: "0", Msg: "all probes passed"}
+	if len(failed) > 0 {
+		ah = utils.AppHealthy{Code: "1", Msg: strings.Join(failed, "; ")}
+	}
+	msg, err := json.Marshal(ah)
+	if err != nil {
+		return ""
+	}
+	return string(msg)
+}
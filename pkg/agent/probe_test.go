@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProbeOnce(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status":"UP"}`))
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		probe   Probe
+		healthy bool
+	}{
+		{Probe{Type: ProbeTCP, Address: ln.Addr().String()}, true},
+		{Probe{Type: ProbeHTTP, URL: server.URL + "/health", ExpectBody: `"UP"`}, true},
+		{Probe{Type: ProbeHTTP, URL: server.URL + "/health", ExpectBody: `"DOWN"`}, false},
+		{Probe{Type: ProbeHTTP, URL: server.URL + "/other"}, false},
+		{Probe{Type: ProbeExec, Command: []string{"sh", "-c", "test \"$APP_NAME\" = mysql"}}, true},
+		{Probe{Type: ProbeExec, Command: []string{"false"}}, false},
+		{Probe{Type: ProbeProcess, PidFile: "/nonexistent.pid"}, false},
+	}
+
+	for _, c := range cases {
+		p := c.probe.withDefaults()
+		if err := p.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		err := p.probeOnce("/", []string{"APP_NAME=mysql"})
+		if (err == nil) != c.healthy {
+			t.Errorf("probe %+v: expect healthy=%v, got err %v", c.probe, c.healthy, err)
+		}
+	}
+}
+
+func TestProbeHTTPClosesConnections(t *testing.T) {
+	var lock sync.Mutex
+	open := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"UP"}`))
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch state {
+		case http.StateNew:
+			open++
+		case http.StateClosed, http.StateHijacked:
+			open--
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	p := Probe{Type: ProbeHTTP, URL: server.URL, ExpectBody: `"UP"`}.withDefaults()
+	for i := 0; i < 3; i++ {
+		if err := p.probeOnce("/", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		lock.Lock()
+		n := open
+		lock.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect connections of probes closed, %d still open", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProbeSetThresholds(t *testing.T) {
+	ps := newProbeSet([]Probe{
+		{Name: "port", Type: ProbeTCP, Address: "127.0.0.1:3306", FailureThreshold: 2, SuccessThreshold: 2},
+	})
+
+	if msg := ps.healthMsg(); msg != "" {
+		t.Fatalf("expect no result before any threshold, got %s", msg)
+	}
+
+	ps.record(0, nil)
+	if msg := ps.healthMsg(); msg != "" {
+		t.Fatalf("expect no result before success threshold, got %s", msg)
+	}
+	ps.record(0, nil)
+	if msg := ps.healthMsg(); !strings.Contains(msg, `"code":"0"`) {
+		t.Fatalf("expect healthy, got %s", msg)
+	}
+
+	// one failure is below the failure threshold
+	ps.record(0, net.UnknownNetworkError("refused"))
+	if msg := ps.healthMsg(); !strings.Contains(msg, `"code":"0"`) {
+		t.Fatalf("expect still healthy, got %s", msg)
+	}
+	ps.record(0, net.UnknownNetworkError("refused"))
+	if msg := ps.healthMsg(); !strings.Contains(msg, `"code":"1"`) || !strings.Contains(msg, "port") {
+		t.Fatalf("expect unhealthy with probe name, got %s", msg)
+	}
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Relay forwards traffic between the apiserver and the agents in a network zone the apiserver can't reach.
+// The apiserver connects to hosts in the zone (ssh for bootstrapping, the agent api for actions) by CONNECT
+// through the relay, and agents in the zone reach the apiserver by the relay, which proxies /apis/ to it.
+type Relay struct {
+	// Operator is the address of the apiserver, eg. 192.168.19.100:3334
+	Operator string
+	// Allow are the networks of the zone, only hosts in them can be connected to
+	Allow []*net.IPNet
+	// Ports can be connected to, eg. 22, the agent port and its grpc port, and the ssh_port and agent_port of host profiles
+	Ports map[string]bool
+	// Token must be sent in Proxy-Authorization if it isn't empty, by the apiserver and by agents in the zone
+	Token string
+
+	proxy *httputil.ReverseProxy
+	once  sync.Once
+}
+
+// NewRelayFromEnv builds a relay from AGENT_RELAY_OPERATOR, AGENT_RELAY_ALLOW, AGENT_RELAY_PORTS and AGENT_RELAY_TOKEN
+func NewRelayFromEnv() (*Relay, error) {
+	r := &Relay{
+		Operator: os.Getenv("AGENT_RELAY_OPERATOR"),
+		Ports:    map[string]bool{},
+		Token:    os.Getenv("AGENT_RELAY_TOKEN"),
+	}
+	if r.Operator == "" {
+		return nil, errors.New("AGENT_RELAY_OPERATOR is needed, eg. 192.168.19.100:3334")
+	}
+	for _, cidr := range strings.Split(os.Getenv("AGENT_RELAY_ALLOW"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("AGENT_RELAY_ALLOW <%s> is illegal: %s", cidr, err)
+		}
+		r.Allow = append(r.Allow, ipnet)
+	}
+	if len(r.Allow) == 0 {
+		return nil, errors.New("AGENT_RELAY_ALLOW is needed, eg. 10.10.0.0/16")
+	}
+	ports := os.Getenv("AGENT_RELAY_PORTS")
+	if ports == "" {
+		ports = "22," + Port + "," + GRPCPort
+	}
+	for _, port := range strings.Split(ports, ",") {
+		r.Ports[strings.TrimSpace(port)] = true
+	}
+	return r, nil
+}
+
+// RunRelay runs the relay configured by environment on AGENT_RELAY_PORT (3336 by default), it returns only on errors
+func RunRelay() error {
+	r, err := NewRelayFromEnv()
+	if err != nil {
+		return err
+	}
+	port := os.Getenv("AGENT_RELAY_PORT")
+	if port == "" {
+		port = "3336"
+	}
+	log.Printf("Relay on :%s for %s to the apiserver %s", port, os.Getenv("AGENT_RELAY_ALLOW"), r.Operator)
+	return http.ListenAndServe(":"+port, r)
+}
+
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.Method == "CONNECT":
+		r.connect(w, req)
+	case req.URL.Path == "/ping":
+		w.Write([]byte(`{"message":"pong"}`))
+	case strings.HasPrefix(req.URL.Path, "/apis/"):
+		if !r.authorized(w, req) {
+			return
+		}
+		// Proxy-Authorization is a hop-by-hop header, the token isn't passed to the apiserver
+		r.once.Do(func() {
+			r.proxy = httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: r.Operator})
+		})
+		r.proxy.ServeHTTP(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// allowed return nil if addr is a permitted port of a host in the zone
+func (r *Relay) allowed(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("<%s> isn't an ip", host)
+	}
+	if !r.Ports[port] {
+		return fmt.Errorf("port <%s> isn't allowed", port)
+	}
+	for _, ipnet := range r.Allow {
+		if ipnet.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("<%s> isn't in the zone", host)
+}
+
+// authorized return true if the request has the token of the relay, it's refused if it hasn't
+func (r *Relay) authorized(w http.ResponseWriter, req *http.Request) bool {
+	if r.Token == "" {
+		return true
+	}
+	auth := req.Header.Get("Proxy-Authorization")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+r.Token)) != 1 {
+		http.Error(w, "token is illegal", http.StatusProxyAuthRequired)
+		return false
+	}
+	return true
+}
+
+// connect forwards the connection to a host in the zone
+func (r *Relay) connect(w http.ResponseWriter, req *http.Request) {
+	if !r.authorized(w, req) {
+		return
+	}
+	if err := r.allowed(req.Host); err != nil {
+		log.Printf("Relay refused <%s>: %s", req.Host, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	target, err := net.DialTimeout("tcp", req.Host, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		target.Close()
+		http.Error(w, "connect isn't supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		target.Close()
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		conn.Close()
+		target.Close()
+		return
+	}
+
+	// bytes sent by the client after CONNECT may be buffered already
+	go func() {
+		io.Copy(target, rw.Reader)
+		target.Close()
+	}()
+	io.Copy(conn, target)
+	conn.Close()
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/farmer-hutao/paas-operator/pkg/tunnel"
+)
+
+func TestRelay(t *testing.T) {
+	// a host in the zone echoing the first line
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte(line))
+			conn.Close()
+		}
+	}()
+	operator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + r.Header.Get("Proxy-Authorization")))
+	}))
+	defer operator.Close()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	_, zone, _ := net.ParseCIDR("127.0.0.0/8")
+	relay := httptest.NewServer(&Relay{
+		Operator: strings.TrimPrefix(operator.URL, "http://"),
+		Allow:    []*net.IPNet{zone},
+		Ports:    map[string]bool{port: true},
+		Token:    "secret",
+	})
+	defer relay.Close()
+	relayAddr := strings.TrimPrefix(relay.URL, "http://")
+
+	conn, err := tunnel.DialConnect(relayAddr, "secret", l.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello\n"))
+	if got, _ := bufio.NewReader(conn).ReadString('\n'); got != "hello\n" {
+		t.Errorf("expect the echo through the relay, got %q", got)
+	}
+	conn.Close()
+
+	denied := []struct {
+		token, addr string
+	}{
+		{"wrong", l.Addr().String()},
+		{"secret", "127.0.0.1:1"},
+		{"secret", "10.0.0.1:" + port},
+	}
+	for _, c := range denied {
+		if conn, err := tunnel.DialConnect(relayAddr, c.token, c.addr, 5*time.Second); err == nil {
+			conn.Close()
+			t.Errorf("expect <%s> with token <%s> refused", c.addr, c.token)
+		}
+	}
+
+	// agents in the zone reach the apiserver by the relay with its token
+	resp, err := http.Get(relay.URL + "/apis/v1alpha1/nodes/127.0.0.1/heartbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("expect a request without the token refused, got %s", resp.Status)
+	}
+	req, _ := http.NewRequest("GET", relay.URL+"/apis/v1alpha1/nodes/127.0.0.1/heartbeat", nil)
+	req.Header.Set("Proxy-Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "/apis/v1alpha1/nodes/127.0.0.1/heartbeat" {
+		t.Errorf("expect the request proxied to the apiserver without the token, got %q", body)
+	}
+}
@@ -0,0 +1,296 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils"
+)
+
+// reportsDir is the dir under WorkDir holding the check reports not sent to the apiserver yet,
+// one file per report named by its sequence, so they survive a restart of the agent.
+const reportsDir = "reports"
+
+// ReportQueueSize is the max number of reports kept while the apiserver can't be reached,
+// the oldest ones are dropped beyond it
+var ReportQueueSize = 10000
+
+// reportBackoff is how long the sender waits before sending a report again, the last one is
+// repeated until the apiserver is back. It never slows the check loops down.
+var reportBackoff = []time.Duration{time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second}
+
+func init() {
+	if v := os.Getenv("AGENT_REPORT_QUEUE_SIZE"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			log.Printf("Warning: AGENT_REPORT_QUEUE_SIZE <%s> is illegal, use default value: %d", v, ReportQueueSize)
+		} else {
+			ReportQueueSize = size
+		}
+	}
+}
+
+// checkReport is the result of one check of an app, waiting to be sent
+type checkReport struct {
+	AppType      string `json:"app_type"`
+	Name         string `json:"name"`
+	OperatorIp   string `json:"operator_ip"`
+	OperatorPort string `json:"operator_port"`
+	Msg          string `json:"msg"`
+	// Time is when the check ran in RFC 3339, it's sent with the report so a late one isn't taken as fresh
+	Time string `json:"time"`
+}
+
+// body return the json sent to the apiserver, see utils.AppHealthy
+func (r *checkReport) body() ([]byte, error) {
+	var ah utils.AppHealthy
+	if err := json.Unmarshal([]byte(r.Msg), &ah); err != nil {
+		return nil, err
+	}
+	ah.Time = r.Time
+	return json.Marshal(&ah)
+}
+
+// reportQueue is a FIFO of check reports on disk, a single sender sends them in order.
+type reportQueue struct {
+	lock sync.Mutex
+	// seqs are the sequences of the reports in the queue, the head first
+	seqs   []uint64
+	loaded bool
+	// wake tells the sender a report is queued
+	wake  chan struct{}
+	start sync.Once
+	// rpc is used by the sender only
+	rpc reportStream
+}
+
+var globalReports = &reportQueue{wake: make(chan struct{}, 1)}
+
+// enqueueReport queues the report of the app and makes sure the sender is running
+func enqueueReport(ca *CheckArg, msg string) {
+	r := &checkReport{
+		AppType:      ca.AppType,
+		Name:         ca.Name,
+		OperatorIp:   ca.OperatorIp,
+		OperatorPort: ca.OperatorPort,
+		Msg:          msg,
+		Time:         time.Now().Format(time.RFC3339),
+	}
+	if err := globalReports.push(r); err != nil {
+		log.Printf("Error: queue report of <%s> failed: %s", ca.Key(), err)
+	}
+	globalReports.startSender()
+}
+
+// startSender starts the sender once, the reports queued by a previous agent are sent first
+func (q *reportQueue) startSender() {
+	q.start.Do(func() {
+		go q.send(&http.Client{Timeout: 10 * time.Second}, nil)
+	})
+}
+
+func reportPath(seq uint64) string {
+	return filepath.Join(WorkDir, reportsDir, fmt.Sprintf("%020d.json", seq))
+}
+
+// load reads the sequences of the reports left on disk, it's called with the lock held
+func (q *reportQueue) load() error {
+	if q.loaded {
+		return nil
+	}
+	files, err := ioutil.ReadDir(filepath.Join(WorkDir, reportsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.seqs = append(q.seqs, seq)
+	}
+	sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+	q.loaded = true
+	return nil
+}
+
+// push writes the report at the tail, the oldest reports are dropped if the queue is full
+func (q *reportQueue) push(r *checkReport) error {
+	rBytes, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err := q.load(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(WorkDir, reportsDir), os.ModePerm); err != nil {
+		return err
+	}
+	var seq uint64 = 1
+	if len(q.seqs) > 0 {
+		seq = q.seqs[len(q.seqs)-1] + 1
+	}
+	// a report is never read half written
+	tmp := reportPath(seq) + ".tmp"
+	if err := ioutil.WriteFile(tmp, rBytes, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, reportPath(seq)); err != nil {
+		return err
+	}
+	q.seqs = append(q.seqs, seq)
+
+	for len(q.seqs) > ReportQueueSize {
+		log.Printf("Warning: report queue is full (%d), drop the oldest report %d", ReportQueueSize, q.seqs[0])
+		q.removeHead()
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// head return the oldest report and its sequence, false if the queue is empty.
+// A report can't be read is dropped.
+func (q *reportQueue) head() (uint64, *checkReport, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err := q.load(); err != nil {
+		log.Printf("Error: load report queue failed: %s", err)
+		return 0, nil, false
+	}
+	for len(q.seqs) > 0 {
+		seq := q.seqs[0]
+		rBytes, err := ioutil.ReadFile(reportPath(seq))
+		if err == nil {
+			var r checkReport
+			if err = json.Unmarshal(rBytes, &r); err == nil {
+				return seq, &r, true
+			}
+		}
+		log.Printf("Error: read report %d failed: %s, drop it", seq, err)
+		q.removeHead()
+	}
+	return 0, nil, false
+}
+
+// done removes the report of seq if it's still the head, it may be dropped by push meanwhile
+func (q *reportQueue) done(seq uint64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.seqs) > 0 && q.seqs[0] == seq {
+		q.removeHead()
+	}
+}
+
+// removeHead is called with the lock held
+func (q *reportQueue) removeHead() {
+	if err := os.Remove(reportPath(q.seqs[0])); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error: remove report %d failed: %s", q.seqs[0], err)
+	}
+	q.seqs = q.seqs[1:]
+}
+
+func (q *reportQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.seqs)
+}
+
+// send sends the reports in order until stop is closed. A report is retried by reportBackoff
+// while the apiserver can't take it, and dropped if the apiserver refuses it.
+func (q *reportQueue) send(c *http.Client, stop chan struct{}) {
+	defer q.rpc.close()
+	failures := 0
+	for {
+		seq, r, ok := q.head()
+		if !ok {
+			select {
+			case <-stop:
+				return
+			case <-q.wake:
+			}
+			continue
+		}
+
+		body, err := r.body()
+		if err != nil {
+			log.Printf("Error: report of <%s_%s> is illegal: %s, drop it", r.AppType, r.Name, err)
+			q.done(seq)
+			continue
+		}
+		status, err := q.deliver(c, r, body)
+		if err == nil && retryableStatus(status) {
+			err = fmt.Errorf("%d %s", status, http.StatusText(status))
+		}
+		if err == nil {
+			if status != http.StatusAccepted {
+				log.Printf("Error: report of <%s_%s> at %s is refused: %d %s, drop it",
+					r.AppType, r.Name, r.Time, status, http.StatusText(status))
+			} else if failures > 0 {
+				log.Printf("apiserver is back, send %d queued reports", q.len())
+			}
+			failures = 0
+			q.done(seq)
+			continue
+		}
+
+		wait := reportBackoff[len(reportBackoff)-1]
+		if failures < len(reportBackoff) {
+			wait = reportBackoff[failures]
+		}
+		failures++
+		log.Printf("Error: send report of <%s_%s> failed: %s, %d reports queued, retry in %s",
+			r.AppType, r.Name, err, q.len(), wait)
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// deliver sends the report by grpc if the apiserver serves it, or by the json api, and return the status
+// of the json api. A STOP_CHECK of grpc stops the check loop of the app, the report is taken as gone then.
+func (q *reportQueue) deliver(c *http.Client, r *checkReport, body []byte) (int, error) {
+	if addr := reportGRPCAddr(r); addr != "" {
+		cmd, err := q.rpc.send(addr, r, c.Timeout)
+		if err == nil {
+			if cmd == agentpb.ReportCommand_STOP_CHECK {
+				log.Printf("apiserver stops the check of <%s_%s>", r.AppType, r.Name)
+				stopCheck(r.AppType, r.Name)
+				return http.StatusGone, nil
+			}
+			return http.StatusAccepted, nil
+		}
+		log.Printf("Error: send report of <%s_%s> by grpc failed: %s, send it by the json api", r.AppType, r.Name, err)
+	}
+
+	path := fmt.Sprintf("%s/%s/check", r.AppType, r.Name)
+	return operatorDo(c, r.OperatorIp, r.OperatorPort, "PUT", path, body)
+}
+
+// retryableStatus return true if the apiserver may take the report later
+func retryableStatus(status int) bool {
+	return status >= http.StatusInternalServerError ||
+		status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils"
+)
+
+func TestReportQueueReplaysInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+	oldBackoff := reportBackoff
+	reportBackoff = []time.Duration{10 * time.Millisecond}
+	defer func() { reportBackoff = oldBackoff }()
+
+	// reports are sent with the time the check ran
+	ran := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	report := func(ca *CheckArg, msg string) *checkReport {
+		return &checkReport{AppType: ca.AppType, Name: ca.Name, OperatorIp: ca.OperatorIp, OperatorPort: ca.OperatorPort,
+			Msg: `{"code":"0","msg":"` + msg + `"}`, Time: ran}
+	}
+
+	var lock sync.Mutex
+	var got []string
+	down := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/apis/v1alpha1/database/gone/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var ah utils.AppHealthy
+		if err := json.NewDecoder(r.Body).Decode(&ah); err != nil || ah.Time != ran {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got = append(got, ah.Msg)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	ip, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+
+	mysql := &CheckArg{Name: "mysql", AppType: "database", OperatorIp: ip, OperatorPort: port}
+	gone := &CheckArg{Name: "gone", AppType: "database", OperatorIp: ip, OperatorPort: port}
+	// a new agent restores the reports left by the previous one
+	old := &reportQueue{wake: make(chan struct{}, 1)}
+	for _, msg := range []string{"1", "2"} {
+		if err := old.push(report(mysql, msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q := &reportQueue{wake: make(chan struct{}, 1)}
+	stop := make(chan struct{})
+	defer close(stop)
+	go q.send(&http.Client{Timeout: time.Second}, stop)
+
+	for _, r := range []*checkReport{report(mysql, "3"), report(gone, "x"), report(mysql, "4")} {
+		if err := q.push(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := q.len(); n != 5 {
+		t.Fatalf("expect 5 reports queued while apiserver is down, got %d", n)
+	}
+
+	lock.Lock()
+	down = false
+	lock.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if strings.Join(got, ",") != "1,2,3,4" {
+		t.Fatalf("expect reports 1,2,3,4 in order, got %v", got)
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(dir, reportsDir)); len(files) != 0 {
+		t.Fatalf("expect sent reports removed, got %d files", len(files))
+	}
+}
+
+func TestReportQueueBounded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+	oldSize := ReportQueueSize
+	ReportQueueSize = 3
+	defer func() { ReportQueueSize = oldSize }()
+
+	q := &reportQueue{wake: make(chan struct{}, 1)}
+	for _, msg := range []string{"1", "2", "3", "4", "5"} {
+		if err := q.push(&checkReport{AppType: "database", Name: "mysql", Msg: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := q.len(); n != 3 {
+		t.Fatalf("expect 3 reports kept, got %d", n)
+	}
+	if _, r, ok := q.head(); !ok || r.Msg != "3" {
+		t.Fatalf("expect the oldest reports dropped, got head %v", r)
+	}
+}
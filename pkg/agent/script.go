@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"log"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Exit codes a script can use to tell the agent more than success or failure.
+// Any other non-zero exit code means the action failed.
+const (
+	ExitOK             = 0
+	ExitNotInstalled   = 3  // the app isn't installed on the host
+	ExitAlreadyRunning = 4  // the app is running already, nothing was done
+	ExitAlreadyStopped = 5  // the app is stopped already, nothing was done
+	ExitRetryable      = 75 // a temporary failure, the same action can be retried (EX_TEMPFAIL)
+)
+
+// ScriptResult is the status of an action derived from the exit code of its script
+type ScriptResult string
+
+const (
+	ResultOK             ScriptResult = "ok"
+	ResultFailed         ScriptResult = "failed"
+	ResultNotInstalled   ScriptResult = "not-installed"
+	ResultAlreadyRunning ScriptResult = "already-running"
+	ResultAlreadyStopped ScriptResult = "already-stopped"
+	ResultRetryable      ScriptResult = "retryable-failure"
+)
+
+var exitCodeResultMap = map[int]ScriptResult{
+	ExitOK:             ResultOK,
+	ExitNotInstalled:   ResultNotInstalled,
+	ExitAlreadyRunning: ResultAlreadyRunning,
+	ExitAlreadyStopped: ResultAlreadyStopped,
+	ExitRetryable:      ResultRetryable,
+}
+
+// ResultOfExitCode maps the exit code of a script to a ScriptResult
+func ResultOfExitCode(code int) ScriptResult {
+	if result, ok := exitCodeResultMap[code]; ok {
+		return result
+	}
+	return ResultFailed
+}
+
+// exitCodeOf return the exit code of the script from the error returned by execInSystem;
+// -1 means the script wasn't run or was killed.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// Standard environment variables set by the agent for every script.
+const (
+	EnvAppName     = "APP_NAME"
+	EnvAppType     = "APP_TYPE"
+	EnvAction      = "ACTION"
+	EnvWorkDir     = "WORK_DIR"
+	EnvOperationID = "OPERATION_ID"
+	EnvHostIP      = "HOST_IP"
+	EnvOperatorURL = "OPERATOR_URL"
+	EnvPackagePath = "PACKAGE_PATH"
+)
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ScriptEnv return the environment of the script for the action in appDir, for running
+// scripts out of the agent, eg. over ssh
+func ScriptEnv(action Action, appInfo *AppInfo, appDir string) []string {
+	return scriptEnv(action, appInfo, appDir)
+}
+
+// scriptEnv return the environment a script gets besides the agent's own environment:
+// all metadata, then the standard variables, so metadata can never overwrite the standard variables.
+// Metadata with a name which isn't a legal environment name is skipped.
+func scriptEnv(action Action, appInfo *AppInfo, appDir string) []string {
+	env := make([]string, 0, len(appInfo.Metadata)+8)
+
+	// sort to make the env stable
+	keys := make([]string, 0, len(appInfo.Metadata))
+	for k := range appInfo.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !envNameRegexp.MatchString(k) {
+			log.Printf("metadata <%s> of <%s> isn't a legal environment name, skip it", k, appInfo.Name)
+			continue
+		}
+		env = append(env, k+"="+appInfo.Metadata[k])
+	}
+
+	// the package is downloaded by the agent when installing
+	if appInfo.Package != "" {
+		env = append(env, EnvPackagePath+"="+filepath.Join(appDir, appInfo.Package))
+	}
+
+	return append(env,
+		EnvAppName+"="+appInfo.Name,
+		EnvAppType+"="+appInfo.Type,
+		EnvAction+"="+string(action),
+		EnvWorkDir+"="+appDir,
+		EnvOperationID+"="+appInfo.OperationID,
+		EnvHostIP+"="+appInfo.HostIP,
+		EnvOperatorURL+"=http://"+net.JoinHostPort(appInfo.OperatorIp, appInfo.OperatorPort),
+	)
+}
+
+// legacyArgsToEnv converts the "k=v k=v " args saved by older agents to environment variables
+func legacyArgsToEnv(args string) []string {
+	var env []string
+	for _, kv := range strings.Fields(args) {
+		if i := strings.Index(kv, "="); i > 0 && envNameRegexp.MatchString(kv[:i]) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
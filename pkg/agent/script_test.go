@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScriptEnv(t *testing.T) {
+	appInfo := &AppInfo{
+		Name: "mysql",
+		Type: "database",
+		Metadata: map[string]string{
+			"APP_PASSWD":     "a b;$(rm -rf /)",
+			"APP_NAME":       "fake",
+			"// some remark": "not an env",
+		},
+	}
+	env := scriptEnv(Install, appInfo, "/opt/app/apps/database/mysql/default")
+
+	values := map[string]string{}
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		values[kv[:i]] = kv[i+1:]
+	}
+	if values["APP_PASSWD"] != "a b;$(rm -rf /)" {
+		t.Errorf("metadata should be passed as is, got <%s>", values["APP_PASSWD"])
+	}
+	if values[EnvAppName] != "mysql" {
+		t.Errorf("metadata shouldn't overwrite %s, got <%s>", EnvAppName, values[EnvAppName])
+	}
+	if _, ok := values["// some remark"]; ok {
+		t.Errorf("illegal env name should be skipped")
+	}
+	if values[EnvAction] != string(Install) {
+		t.Errorf("expect %s=%s, got <%s>", EnvAction, Install, values[EnvAction])
+	}
+}
+
+func TestScriptExitCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "start.sh")
+	content := "echo \"$APP_PASSWD\"\nexit 4\n"
+	if err := ioutil.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = execInSystem(dir, []string{script}, []string{"APP_PASSWD=a b;c"}, &buf, false, nil)
+	if result := ResultOfExitCode(exitCodeOf(err)); result != ResultAlreadyRunning {
+		t.Errorf("expect result <%s>, got <%s>", ResultAlreadyRunning, result)
+	}
+	if strings.TrimSpace(buf.String()) != "a b;c" {
+		t.Errorf("expect output <a b;c>, got <%s>", buf.String())
+	}
+}
+
+func TestLegacyArgsToEnv(t *testing.T) {
+	env := legacyArgsToEnv("APP_USER=mysql APP_PASSWD=MYSQL123 broken ")
+	if len(env) != 2 || env[0] != "APP_USER=mysql" || env[1] != "APP_PASSWD=MYSQL123" {
+		t.Errorf("unexpected env: %v", env)
+	}
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/farmer-hutao/paas-operator/pkg/tunnel"
+)
+
+// maxTunnelBackoff is the longest wait before dialing the apiserver again
+const maxTunnelBackoff = time.Minute
+
+var (
+	tunnelLock    sync.Mutex
+	currentTunnel *tunnel.Tunnel
+)
+
+// operatorTunnel return the connected tunnel to the apiserver, nil if there isn't one
+func operatorTunnel() *tunnel.Tunnel {
+	tunnelLock.Lock()
+	defer tunnelLock.Unlock()
+	return currentTunnel
+}
+
+func setOperatorTunnel(t *tunnel.Tunnel) {
+	tunnelLock.Lock()
+	defer tunnelLock.Unlock()
+	currentTunnel = t
+}
+
+// Tunnel keeps a websocket to the apiserver in tunnel mode, it never returns. Actions of the apiserver
+// come through it and are served by handler, reports of the agent go through it, and the log of the
+// agent (with output of scripts) is streamed to the apiserver. It's dialed again when it's closed.
+// It waits until the apiserver is known, see loadNodeInfo.
+func Tunnel(handler http.Handler) {
+	log.SetOutput(io.MultiWriter(os.Stderr, tunnelLogWriter{}))
+
+	backoff := HeartbeatInterval
+	for {
+		info := loadNodeInfo()
+		if info == nil {
+			time.Sleep(HeartbeatInterval)
+			continue
+		}
+		status, err := currentNodeStatus(info)
+		if err == nil {
+			var conn *tunnel.Conn
+			url := fmt.Sprintf("ws://%s/apis/v1alpha1/nodes/%s/tunnel", net.JoinHostPort(info.OperatorIp, info.OperatorPort), status.IP)
+			header := http.Header{}
+			setRelayAuth(header)
+			if info.TunnelToken != "" {
+				header.Set("Authorization", "Bearer "+info.TunnelToken)
+			}
+			if conn, err = tunnel.Dial(url, header, 10*time.Second); err == nil {
+				t := tunnel.New(conn, handler)
+				setOperatorTunnel(t)
+				log.Printf("Tunnel to the apiserver is connected")
+				err = t.Run()
+				setOperatorTunnel(nil)
+				backoff = HeartbeatInterval
+			}
+		}
+		log.Printf("Tunnel to the apiserver is closed: %s, dial again in %s", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxTunnelBackoff {
+			backoff = maxTunnelBackoff
+		}
+	}
+}
+
+// tunnelLogWriter sends every log line through the tunnel if it's connected
+type tunnelLogWriter struct{}
+
+func (w tunnelLogWriter) Write(p []byte) (int, error) {
+	if t := operatorTunnel(); t != nil {
+		t.Log(strings.TrimRight(string(p), "\n"))
+	}
+	return len(p), nil
+}
+
+// operatorDo sends body to the path under /apis/v1alpha1/ of the apiserver and return the status code,
+// through the tunnel if it's connected.
+func operatorDo(c *http.Client, operatorIp, operatorPort, method, path string, body []byte) (int, error) {
+	path = "/apis/v1alpha1/" + path
+	if t := operatorTunnel(); t != nil {
+		status, _, err := t.Do(method, path, body, c.Timeout)
+		return status, err
+	}
+
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(operatorIp, operatorPort), path)
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Add("Content-Type", "application/json;charset=utf-8")
+	setRelayAuth(req.Header)
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpgradeHealthTimeout is how long the new agent may take to answer /ping with its version,
+// the old one is restored after that
+var UpgradeHealthTimeout = 2 * time.Minute
+
+// UpgradeRequest asks the agent to replace itself with the binary of the version at the url
+type UpgradeRequest struct {
+	Version string `json:"version"`
+	// URL is where the binary is served, eg. http://{apiserver}/apis/v1alpha1/agents/v0.2.0/agent
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+var (
+	upgradeLock sync.Mutex
+	upgrading   bool
+)
+
+// upgradeCmd runs a command for the upgrade, it's replaced in tests
+var upgradeCmd = func(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %s, %s", name, strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+// Upgrade replaces the running binary with a new version: it's downloaded and verified by its
+// checksum, swapped atomically keeping the old one aside, and the agent restarts through systemd.
+// A watcher in its own systemd unit restores the old binary if the new agent isn't healthy, see WatchUpgrade.
+func Upgrade(c *gin.Context) {
+	var req UpgradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Version == "" || req.URL == "" || req.SHA256 == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version, url and sha256 are needed"})
+		return
+	}
+	if req.Version == Version {
+		c.JSON(http.StatusOK, gin.H{"msg": "agent is " + Version + " already", "version": Version})
+		return
+	}
+
+	upgradeLock.Lock()
+	if upgrading {
+		upgradeLock.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "an upgrade is running"})
+		return
+	}
+	upgrading = true
+	upgradeLock.Unlock()
+	done := func() {
+		upgradeLock.Lock()
+		upgrading = false
+		upgradeLock.Unlock()
+	}
+
+	bin, err := agentBin()
+	if err == nil {
+		err = prepareUpgrade(&req, bin)
+	}
+	if err == nil {
+		err = startUpgradeWatch(&req, bin)
+	}
+	if err != nil {
+		done()
+		log.Printf("Upgrade to %s failed: %s", req.Version, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Upgrade from %s to %s, restart agent", Version, req.Version)
+	c.JSON(http.StatusAccepted, gin.H{"msg": "restarting", "version": req.Version})
+	go func() {
+		// let the response out before systemd stops the agent
+		time.Sleep(time.Second)
+		if err := upgradeCmd("systemctl", "--no-block", "restart", "agent.service"); err != nil {
+			log.Printf("Restart agent for the upgrade failed: %s", err)
+		}
+		done()
+	}()
+}
+
+// agentBin return the path of the running binary
+func agentBin() (string, error) {
+	bin, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(bin)
+}
+
+// prepareUpgrade downloads the new binary, and swaps it with bin keeping the old one as bin.old
+func prepareUpgrade(req *UpgradeRequest, bin string) error {
+	i := strings.LastIndex(req.URL, "/")
+	if i < 0 {
+		return errors.New("url is illegal: " + req.URL)
+	}
+	newBin := bin + ".new"
+	// the binary is served by the apiserver, through the relay if the agent is in a zone
+	d := NewDownloader(req.URL[:i+1])
+	d.Header = http.Header{}
+	setRelayAuth(d.Header)
+	if _, err := d.Fetch(req.URL[i+1:], req.SHA256, newBin); err != nil {
+		return err
+	}
+	defer os.Remove(newBin)
+	if err := os.Chmod(newBin, 0755); err != nil {
+		return err
+	}
+	// a binary of another version would never be healthy, and be rolled back after a while
+	out, err := exec.Command(newBin, "-version").Output()
+	if err != nil {
+		return fmt.Errorf("run the new agent failed: %s", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != req.Version {
+		return fmt.Errorf("the new agent is %s, expect %s", got, req.Version)
+	}
+
+	if err := copyFile(bin, bin+".old"); err != nil {
+		return err
+	}
+	// rename is atomic, a running binary can't be written
+	return os.Rename(newBin, bin)
+}
+
+// startUpgradeWatch runs the old binary as the watcher of the upgrade in its own systemd unit,
+// so it survives the restart of agent.service. The new binary is rolled back if it fails.
+func startUpgradeWatch(req *UpgradeRequest, bin string) error {
+	unit := "agent-upgrade-" + strconv.FormatInt(time.Now().Unix(), 10)
+	err := upgradeCmd("systemd-run", "--unit", unit, "--setenv=AGENT_PORT="+Port,
+		bin+".old", "-upgrade-watch", req.Version)
+	if err != nil {
+		if rerr := os.Rename(bin+".old", bin); rerr != nil {
+			log.Printf("Restore agent failed: %s", rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// WatchUpgrade waits for the agent of the version to answer /ping. It's run by the old binary,
+// which is removed if the new agent is healthy, or it's restored and the agent is restarted.
+func WatchUpgrade(version string) error {
+	self, err := agentBin()
+	if err != nil {
+		return err
+	}
+	return watchUpgrade(version, strings.TrimSuffix(self, ".old"))
+}
+
+func watchUpgrade(version, bin string) error {
+	c := &http.Client{Timeout: 5 * time.Second}
+	url := fmt.Sprintf("http://%s/ping", net.JoinHostPort("127.0.0.1", Port))
+	deadline := time.Now().Add(UpgradeHealthTimeout)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		if lastErr = pingVersion(c, url, version); lastErr == nil {
+			log.Printf("Agent %s is healthy, upgrade is done", version)
+			return os.Remove(bin + ".old")
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	log.Printf("Agent %s isn't healthy in %s: %s, roll back", version, UpgradeHealthTimeout, lastErr)
+	if err := os.Rename(bin+".old", bin); err != nil {
+		return err
+	}
+	if err := upgradeCmd("systemctl", "restart", "agent.service"); err != nil {
+		return err
+	}
+	return fmt.Errorf("agent %s isn't healthy, rolled back: %s", version, lastErr)
+}
+
+// pingVersion return nil if the agent at url answers with the version
+func pingVersion(c *http.Client, url, version string) error {
+	resp, err := c.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var pong struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pong); err != nil {
+		return err
+	}
+	if pong.Version != version {
+		return fmt.Errorf("agent is %s", pong.Version)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WorkDir
+	WorkDir = dir
+	defer func() { WorkDir = oldWorkDir }()
+
+	bin := filepath.Join(dir, "agent")
+	oldBin := "#!/bin/sh\necho v0.1.0\n"
+	newBin := "#!/bin/sh\necho v0.2.0\n"
+	if err := ioutil.WriteFile(bin, []byte(oldBin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(newBin))
+	}))
+	defer server.Close()
+
+	// a wrong checksum leaves the agent as it is
+	req := &UpgradeRequest{Version: "v0.2.0", URL: server.URL + "/agents/v0.2.0/agent", SHA256: hashString("other")}
+	if err := prepareUpgrade(req, bin); err == nil {
+		t.Fatal("expect a wrong checksum refused")
+	}
+	if b, _ := ioutil.ReadFile(bin); string(b) != oldBin {
+		t.Fatal("expect the agent not replaced")
+	}
+
+	// so is a binary of another version
+	req.Version, req.SHA256 = "v0.3.0", hashString(newBin)
+	if err := prepareUpgrade(req, bin); err == nil || !strings.Contains(err.Error(), "v0.2.0") {
+		t.Fatalf("expect a binary of another version refused, got %v", err)
+	}
+
+	req.Version = "v0.2.0"
+	if err := prepareUpgrade(req, bin); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(bin); string(b) != newBin {
+		t.Error("expect the agent replaced")
+	}
+	if b, _ := ioutil.ReadFile(bin + ".old"); string(b) != oldBin {
+		t.Error("expect the old agent kept")
+	}
+
+	// the new agent never answers with its version, the old one is restored
+	var cmds []string
+	oldCmd := upgradeCmd
+	upgradeCmd = func(name string, args ...string) error {
+		cmds = append(cmds, name+" "+strings.Join(args, " "))
+		return nil
+	}
+	defer func() { upgradeCmd = oldCmd }()
+	ping := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message":"pong","version":"v0.1.0"}`))
+	}))
+	defer ping.Close()
+	_, port, _ := net.SplitHostPort(ping.Listener.Addr().String())
+	oldPort, oldTimeout := Port, UpgradeHealthTimeout
+	Port, UpgradeHealthTimeout = port, 3*time.Second
+	defer func() { Port, UpgradeHealthTimeout = oldPort, oldTimeout }()
+
+	if err := watchUpgrade("v0.2.0", bin); err == nil {
+		t.Fatal("expect the upgrade rolled back")
+	}
+	if b, _ := ioutil.ReadFile(bin); string(b) != oldBin {
+		t.Error("expect the old agent restored")
+	}
+	if len(cmds) != 1 || cmds[0] != "systemctl restart agent.service" {
+		t.Errorf("expect the agent restarted, got %v", cmds)
+	}
+}
@@ -0,0 +1,5 @@
+package agent
+
+// Version of the agent, set at build time by
+// -ldflags "-X github.com/farmer-hutao/paas-operator/pkg/agent.Version=v0.2.0"
+var Version = "v0.1.0"
@@ -0,0 +1,75 @@
+package apiserver
+
+import (
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/application"
+)
+
+// ListAgentReleases return the versions of agent the apiserver serves with their sha256
+func ListAgentReleases(ctx iris.Context) {
+	releases, err := application.ListAgentReleases()
+	if err != nil {
+		ctx.Application().Logger().Errorf("List agent releases failed: %s", err)
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(releases)
+}
+
+// GetAgentBinary serves the agent binary of the version, agents download it to upgrade themselves
+func GetAgentBinary(ctx iris.Context) {
+	path, err := application.AgentReleasePath(ctx.Params().GetString("version"))
+	if err != nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString(err.Error())
+		return
+	}
+	if err := ctx.ServeFile(path, false); err != nil {
+		ctx.Application().Logger().Errorf("Serve agent <%s> failed: %s", path, err)
+	}
+}
+
+// CreateAgentRollout upgrades agents of nodes to a version batch by batch in background,
+// query the rollout by its id for the progress
+func CreateAgentRollout(ctx iris.Context) {
+	var rollout application.AgentRollout
+	if err := ctx.ReadJSON(&rollout); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		ctx.Application().Logger().Errorf("CreateAgentRollout Error, json is illegal: %s", err)
+		return
+	}
+	if err := application.StartRollout(&rollout, ctx); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	ctx.StatusCode(iris.StatusAccepted)
+	ctx.JSON(rollout)
+}
+
+// ListAgentRollouts return all rollouts with their progress
+func ListAgentRollouts(ctx iris.Context) {
+	rollouts := application.GetETCDRollouts().List(ctx)
+	if rollouts == nil {
+		rollouts = []*application.AgentRollout{}
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(rollouts)
+}
+
+// GetAgentRollout return the rollout with the id
+func GetAgentRollout(ctx iris.Context) {
+	id := ctx.Params().GetString("id")
+	rollout, ok := application.GetETCDRollouts().Get(id, ctx)
+	if !ok {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString("rollout <" + id + "> is not exist")
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(rollout)
+}
@@ -0,0 +1,105 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/kataras/iris"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+)
+
+// agentGRPCPort return the port of the grpc api of the agent on the host, empty if it's called by the json api:
+// the agent is older than grpc, can't be discovered, or is reached by its tunnel, which carries only the json api.
+func agentGRPCPort(host Hostx, ctx iris.Context) string {
+	if agentTunnel(host.IP) != nil {
+		return ""
+	}
+	d, err := discoverAgent(host, ctx)
+	if err != nil || !agent.HasCapability(d.Capabilities, agent.CapGRPC) {
+		return ""
+	}
+	return d.GRPCPort
+}
+
+// dialAgentGRPC connects to the grpc api of the agent on the host, by the relay of its zone if it's in one
+func dialAgentGRPC(host Hostx, port string, ctx iris.Context) (*grpc.ClientConn, error) {
+	dial, err := dialerFor(host, ctx)
+	if err != nil {
+		return nil, err
+	}
+	network := host.network()
+	dialer := func(addr string, timeout time.Duration) (net.Conn, error) {
+		if dial != nil {
+			return dial(network, addr)
+		}
+		return net.DialTimeout(network, addr, timeout)
+	}
+	dialCtx, cancel := context.WithTimeout(context.Background(), agentTimeout)
+	defer cancel()
+	return grpc.DialContext(dialCtx, net.JoinHostPort(host.IP, port),
+		grpc.WithInsecure(), grpc.WithBlock(), grpc.FailOnNonTempDialError(true), grpc.WithDialer(dialer))
+}
+
+// agentGRPCDo runs the action by the grpc api of the agent on the host, the steps and the output of the
+// script are logged while it runs. delivered is false if the agent got nothing, the json api can be tried then.
+func agentGRPCDo(host Hostx, port string, action ApplicationAction, appInfo *agent.AppInfo, ctx iris.Context) (delivered bool, err error) {
+	conn, err := dialAgentGRPC(host, port, ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	stream, err := agentpb.NewAgentClient(conn).Do(context.Background(), &agentpb.ActionRequest{
+		OperationId: appInfo.OperationID,
+		Action:      agent.ActionToPB(agent.Action(action)),
+		App:         agent.AppInfoToPB(appInfo),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var result *agentpb.Result
+	received := false
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// an agent which isn't serving grpc fails before any event
+			code := status.Code(err)
+			return received || (code != codes.Unavailable && code != codes.Unimplemented), err
+		}
+		received = true
+		switch e := ev.Event.(type) {
+		case *agentpb.ActionEvent_Progress:
+			ctx.Application().Logger().Infof("[%s] %s %s: %s", host.IP, action, e.Progress.Step, e.Progress.Message)
+		case *agentpb.ActionEvent_Output:
+			ctx.Application().Logger().Infof("[%s] %s: %s", host.IP, e.Output.Stream, e.Output.Line)
+		case *agentpb.ActionEvent_Result:
+			result = e.Result
+		}
+	}
+
+	if result == nil {
+		return true, fmt.Errorf("agent on <%s> ends %s without a result", host.IP, action)
+	}
+	if result.Error == nil {
+		return true, nil
+	}
+	return true, &ActionError{
+		Action:   action,
+		Result:   agent.ScriptResult(result.Result),
+		ExitCode: int(result.ExitCode),
+		Msg:      result.Error.Message,
+		Code:     result.Error.Code.String(),
+	}
+}
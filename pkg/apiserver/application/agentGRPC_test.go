@@ -0,0 +1,88 @@
+package application
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris"
+	irisctx "github.com/kataras/iris/context"
+	"google.golang.org/grpc"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+)
+
+// fakeAgent streams a step and a line, then fails a stop as the app is stopped already
+type fakeAgent struct {
+	requests []*agentpb.ActionRequest
+}
+
+func (a *fakeAgent) Ping(ctx context.Context, req *agentpb.PingRequest) (*agentpb.PingResponse, error) {
+	return &agentpb.PingResponse{Version: agent.Version}, nil
+}
+
+func (a *fakeAgent) SetNode(ctx context.Context, info *agentpb.NodeInfo) (*agentpb.SetNodeResponse, error) {
+	return &agentpb.SetNodeResponse{Version: agent.Version}, nil
+}
+
+func (a *fakeAgent) Do(req *agentpb.ActionRequest, stream agentpb.Agent_DoServer) error {
+	a.requests = append(a.requests, req)
+	stream.Send(&agentpb.ActionEvent{Event: &agentpb.ActionEvent_Progress{Progress: &agentpb.Progress{Step: agentpb.Progress_RUN_SCRIPT, Message: "stop.sh"}}})
+	stream.Send(&agentpb.ActionEvent{Event: &agentpb.ActionEvent_Output{Output: &agentpb.Output{Line: "stopping"}}})
+	result := &agentpb.Result{Result: string(agent.ResultOK)}
+	if req.Action == agentpb.Action_STOP {
+		result = &agentpb.Result{Result: string(agent.ResultAlreadyStopped), ExitCode: agent.ExitAlreadyStopped,
+			Error: &agentpb.Error{Code: agentpb.Error_SCRIPT_FAILED, Message: "exit status 5"}}
+	}
+	return stream.Send(&agentpb.ActionEvent{Event: &agentpb.ActionEvent_Result{Result: result}})
+}
+
+func TestAgentGRPCDo(t *testing.T) {
+	ctx := irisctx.NewContext(iris.New())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeAgent{}
+	s := grpc.NewServer()
+	agentpb.RegisterAgentServer(s, fake)
+	go s.Serve(l)
+	defer s.Stop()
+	ip, port, _ := net.SplitHostPort(l.Addr().String())
+	host := Hostx{IP: ip}
+
+	// the grpc port is learned from the discovery of an agent with grpc
+	discoveries.Store(ip, cachedDiscovery{discovery: &agent.Discovery{Capabilities: []string{agent.CapResults}, GRPCPort: port}, at: time.Now()})
+	if got := agentGRPCPort(host, ctx); got != "" {
+		t.Errorf("expect an agent without grpc called by the json api, got port %s", got)
+	}
+	discoveries.Store(ip, cachedDiscovery{discovery: &agent.Discovery{Capabilities: agent.Capabilities, GRPCPort: port}, at: time.Now()})
+	defer forgetDiscovery(ip)
+	if got := agentGRPCPort(host, ctx); got != port {
+		t.Errorf("expect grpc port %s, got %s", port, got)
+	}
+
+	appInfo := &agent.AppInfo{Name: "mysql", Type: "database", Stop: "stop.sh", OperationID: "op-1"}
+	if delivered, err := agentGRPCDo(host, port, AStart, appInfo, ctx); !delivered || err != nil {
+		t.Errorf("expect start done by grpc, got %t, %v", delivered, err)
+	}
+	delivered, err := agentGRPCDo(host, port, AStop, appInfo, ctx)
+	actionErr, ok := err.(*ActionError)
+	if !delivered || !ok {
+		t.Fatalf("expect an ActionError of stop, got %t, %v", delivered, err)
+	}
+	if actionErr.Result != agent.ResultAlreadyStopped || actionErr.ExitCode != agent.ExitAlreadyStopped || actionErr.Code != "SCRIPT_FAILED" {
+		t.Errorf("expect the result and the code of the agent, got %+v", actionErr)
+	}
+	if len(fake.requests) != 2 || fake.requests[1].OperationId != "op-1" || fake.requests[1].App.Stop != "stop.sh" {
+		t.Errorf("expect the operation and the app sent to the agent, got %v", fake.requests)
+	}
+
+	// an agent not serving grpc got nothing, the json api can be tried
+	s.Stop()
+	if delivered, err := agentGRPCDo(host, port, AStart, appInfo, ctx); delivered || err == nil {
+		t.Errorf("expect an undelivered action, got %t, %v", delivered, err)
+	}
+}
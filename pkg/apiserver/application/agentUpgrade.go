@@ -0,0 +1,463 @@
+package application
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/iris-contrib/go.uuid"
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+// rolloutPrefix holds all agent rollouts, eg. key=/paas-operator/rollouts/{id}
+var rolloutPrefix = os.Getenv("ETCD_ROLLOUT_PREFIX")
+
+func init() {
+	if rolloutPrefix == "" {
+		rolloutPrefix = "/paas-operator/rollouts"
+		log.Printf("Warning: %s is unset, use default value: %s", "ETCD_ROLLOUT_PREFIX", rolloutPrefix)
+	}
+}
+
+const (
+	// agentUpgradeTimeout is how long the agent may take to download and swap the new binary
+	agentUpgradeTimeout = 5 * time.Minute
+	// agentUpgradeReadyTimeout is how long the upgraded agent may take to answer /ping with the new version,
+	// it's longer than agent.UpgradeHealthTimeout so a rolled back agent is seen
+	agentUpgradeReadyTimeout = 3 * time.Minute
+)
+
+// agentReleaseDir holds the agent binaries served to agents, eg. {dir}/v0.2.0/agent.
+// The agent bundled with the apiserver is extracted from AGENT_ZIP_NAME into it.
+func agentReleaseDir() string {
+	if dir := os.Getenv("AGENT_RELEASE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(WORK_DIR, "agents")
+}
+
+// AgentRelease is an agent binary the apiserver serves
+type AgentRelease struct {
+	Version string `json:"version"`
+	SHA256  string `json:"sha256"`
+}
+
+var releaseLock sync.Mutex
+
+// AgentReleasePath return the path of the agent binary of the version
+func AgentReleasePath(version string) (string, error) {
+	if version == "" || version == "." || version == ".." || strings.ContainsAny(version, `/\`) {
+		return "", fmt.Errorf("version <%s> is illegal", version)
+	}
+	path := filepath.Join(agentReleaseDir(), version, "agent")
+	releaseLock.Lock()
+	defer releaseLock.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if version != agent.Version {
+		return "", fmt.Errorf("agent %s is not exist", version)
+	}
+	return path, extractBundledAgent(path)
+}
+
+// extractBundledAgent extracts agent/agent of AGENT_ZIP_NAME to dst
+func extractBundledAgent(dst string) error {
+	f, err := os.Open(filepath.Join(WORK_DIR, AGENT_ZIP_NAME))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("agent/agent isn't in %s", AGENT_ZIP_NAME)
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimPrefix(hdr.Name, "./") != "agent/agent" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(dst+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		return os.Rename(dst+".tmp", dst)
+	}
+}
+
+// ListAgentReleases return the agents the apiserver serves, the bundled one included
+func ListAgentReleases() ([]AgentRelease, error) {
+	if _, err := AgentReleasePath(agent.Version); err != nil {
+		log.Printf("Extract the bundled agent failed: %s", err)
+	}
+	dirs, err := ioutil.ReadDir(agentReleaseDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []AgentRelease{}, nil
+		}
+		return nil, err
+	}
+	releases := make([]AgentRelease, 0, len(dirs))
+	for _, dir := range dirs {
+		sum, err := fileSHA256(filepath.Join(agentReleaseDir(), dir.Name(), "agent"))
+		if err != nil {
+			continue
+		}
+		releases = append(releases, AgentRelease{Version: dir.Name(), SHA256: sum})
+	}
+	return releases, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// agentVersion return the version the agent on the host answers /ping with
+func agentVersion(host Hostx, ctx iris.Context) (string, error) {
+	status, body, err := agentDo(host, "GET", "ping", nil, agentTimeout, ctx)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("ping agent on <%s> got %d %s", host.IP, status, http.StatusText(status))
+	}
+	var pong struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &pong); err != nil {
+		return "", fmt.Errorf("ping of agent on <%s> is illegal: %s", host.IP, err)
+	}
+	return pong.Version, nil
+}
+
+// UpgradeAgent upgrades the agent on the host to the version, and waits until it answers with the version.
+// The agent rolls back by itself if the new version isn't healthy, an error is returned then.
+func UpgradeAgent(host Hostx, version string, ctx iris.Context) error {
+	path, err := AgentReleasePath(version)
+	if err != nil {
+		return err
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	// the agent authorizes itself at the relay by the token it's told by actions, see agent.NodeInfo
+	operatorIp, operatorPort, _, err := operatorFor(host, ctx)
+	if err != nil {
+		return err
+	}
+	if d, err := discoverAgent(host, ctx); err != nil {
+		return err
+	} else if d.Version == version {
+		return nil
+	} else if !agent.HasCapability(d.Capabilities, agent.CapUpgrade) {
+		return fmt.Errorf("agent %s on <%s> can't upgrade itself, onboard the node again", d.Version, host.IP)
+	}
+	defer forgetDiscovery(host.IP)
+	body, err := json.Marshal(agent.UpgradeRequest{
+		Version: version,
+		URL:     fmt.Sprintf("http://%s/apis/v1alpha1/agents/%s/agent", net.JoinHostPort(operatorIp, operatorPort), version),
+		SHA256:  sum,
+	})
+	if err != nil {
+		return err
+	}
+
+	status, respBody, err := agentDo(host, "PUT", "upgrade", body, agentUpgradeTimeout, ctx)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusOK:
+		return nil
+	case http.StatusAccepted:
+	case http.StatusNotFound:
+		return fmt.Errorf("agent on <%s> can't upgrade itself, onboard the node again", host.IP)
+	default:
+		return fmt.Errorf("upgrade agent on <%s> got %d %s: %s", host.IP, status, http.StatusText(status), respBody)
+	}
+
+	deadline := time.Now().Add(agentUpgradeReadyTimeout)
+	var got string
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		if got, err = agentVersion(host, ctx); err == nil && got == version {
+			ctx.Application().Logger().Infof("Agent on <%s> is upgraded to %s", host.IP, version)
+			return nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("agent is %s", got)
+	}
+	return fmt.Errorf("agent on <%s> isn't %s in %s, it's rolled back: %s", host.IP, version, agentUpgradeReadyTimeout, err)
+}
+
+// hostOfNode return how to connect to the node, by a host of the apps on it
+func hostOfNode(ip string, ctx iris.Context) Hostx {
+	for _, appType := range []AppType{APP_DATABASE, APP_MIDDLEWARE} {
+		for _, app := range GetETCDApplications(appType).List(ctx) {
+			for _, host := range app.GetHosts() {
+				if host.IP == ip {
+					return host
+				}
+			}
+		}
+	}
+	return Hostx{IP: ip}
+}
+
+type RolloutState string
+
+const (
+	RolloutRunning RolloutState = "running"
+	RolloutDone    RolloutState = "done"
+	RolloutFailed  RolloutState = "failed"
+
+	RolloutPending   RolloutState = "pending"
+	RolloutUpgrading RolloutState = "upgrading"
+	RolloutUpgraded  RolloutState = "upgraded"
+	RolloutSkipped   RolloutState = "skipped"
+)
+
+// AgentRollout upgrades the agents of nodes to a version batch by batch, it stops when
+// more than MaxFailures nodes failed
+type AgentRollout struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	// Nodes are the ips of nodes to upgrade, all nodes if it's empty
+	Nodes       []string       `json:"nodes,omitempty"`
+	BatchSize   int            `json:"batch_size"`
+	MaxFailures int            `json:"max_failures"`
+	State       RolloutState   `json:"state"`
+	CreateTime  string         `json:"create_time"` // 2006-01-02 15:04:05
+	FinishTime  string         `json:"finish_time,omitempty"`
+	Progress    []RolloutNodex `json:"progress"`
+}
+
+type RolloutNodex struct {
+	IP          string       `json:"ip"`
+	FromVersion string       `json:"from_version"`
+	State       RolloutState `json:"state"`
+	Message     string       `json:"message,omitempty"`
+}
+
+type ETCDRollouts struct {
+	kapi   client.KeysAPI
+	prefix string
+}
+
+var etcdRollouts = &ETCDRollouts{}
+
+func GetETCDRollouts() *ETCDRollouts {
+	etcdRollouts.kapi = globalKapi
+	etcdRollouts.prefix = rolloutPrefix
+	return etcdRollouts
+}
+
+// Save adds or updates the rollout
+func (rollouts *ETCDRollouts) Save(r *AgentRollout, ctx iris.Context) error {
+	rolloutBytes, err := json.MarshalIndent(r, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = rollouts.kapi.Set(context.Background(), fmt.Sprintf("%s/%s", rollouts.prefix, r.ID), string(rolloutBytes), nil)
+	if err != nil {
+		ctx.Application().Logger().Errorf("Save rollout <%s> to etcd failed. with error: <%s>", r.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Get return the rollout with the id, false if it isn't exist
+func (rollouts *ETCDRollouts) Get(id string, ctx iris.Context) (*AgentRollout, bool) {
+	resp, err := rollouts.kapi.Get(context.Background(), fmt.Sprintf("%s/%s", rollouts.prefix, id), nil)
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("Get rollout <%s> from etcd failed: <%s>", id, err.Error())
+		}
+		return nil, false
+	}
+	var r = new(AgentRollout)
+	if err := json.Unmarshal([]byte(resp.Node.Value), r); err != nil {
+		ctx.Application().Logger().Errorf("Get rollout <%s>, json unmarshal failed: <%s>", id, err.Error())
+		return nil, false
+	}
+	return r, true
+}
+
+// List return all rollouts, rollouts which can't be unmarshaled are skipped
+func (rollouts *ETCDRollouts) List(ctx iris.Context) []*AgentRollout {
+	resp, err := rollouts.kapi.Get(context.Background(), rollouts.prefix, &client.GetOptions{Sort: true})
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("List rollouts from etcd failed: <%s>", err.Error())
+		}
+		return nil
+	}
+	var list = make([]*AgentRollout, 0, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		var r = new(AgentRollout)
+		if err := json.Unmarshal([]byte(n.Value), r); err != nil {
+			ctx.Application().Logger().Errorf("List rollout <%s>, json unmarshal failed: <%s>", n.Key, err.Error())
+			continue
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+// planRollout return the progress of the rollout from the nodes: nodes in pull mode, not ready
+// or of the version already are skipped
+func planRollout(r *AgentRollout, nodes []*Node) []RolloutNodex {
+	wanted := map[string]bool{}
+	for _, ip := range r.Nodes {
+		wanted[ip] = true
+	}
+	progress := make([]RolloutNodex, 0, len(nodes))
+	for _, node := range nodes {
+		if len(wanted) > 0 && !wanted[node.IP] {
+			continue
+		}
+		p := RolloutNodex{IP: node.IP, FromVersion: node.AgentVersion, State: RolloutPending}
+		switch {
+		case node.AgentVersion == r.Version:
+			p.State, p.Message = RolloutSkipped, "agent is "+r.Version+" already"
+		case node.Mode == agent.ModePull:
+			p.State, p.Message = RolloutSkipped, "agent in pull mode can't be reached, onboard the node again"
+		case !node.Ready():
+			p.State, p.Message = RolloutSkipped, "node isn't ready"
+		}
+		progress = append(progress, p)
+	}
+	return progress
+}
+
+// StartRollout plans the rollout and runs it in background, query it by the id for the progress
+func StartRollout(r *AgentRollout, ctx iris.Context) error {
+	if _, err := AgentReleasePath(r.Version); err != nil {
+		return err
+	}
+	if r.BatchSize < 1 {
+		r.BatchSize = 1
+	}
+	if r.MaxFailures < 0 {
+		return errors.New("max_failures can't be negative")
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	r.ID = id.String()
+	r.State = RolloutRunning
+	r.CreateTime = time.Now().Format("2006-01-02 15:04:05")
+	r.Progress = planRollout(r, GetETCDNodes().List(ctx))
+	if err := GetETCDRollouts().Save(r, ctx); err != nil {
+		return err
+	}
+	go runRollout(r, ctx)
+	return nil
+}
+
+// runRollout upgrades pending nodes batch by batch, nodes in a batch are upgraded at the same time
+func runRollout(r *AgentRollout, ctx iris.Context) {
+	var lock sync.Mutex
+	failures := 0
+	save := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		GetETCDRollouts().Save(r, ctx)
+	}
+
+	for start := 0; start < len(r.Progress); {
+		if failures > r.MaxFailures {
+			break
+		}
+		var batch []int
+		for ; start < len(r.Progress) && len(batch) < r.BatchSize; start++ {
+			if r.Progress[start].State == RolloutPending {
+				batch = append(batch, start)
+			}
+		}
+
+		var wg sync.WaitGroup
+		for _, i := range batch {
+			lock.Lock()
+			r.Progress[i].State = RolloutUpgrading
+			lock.Unlock()
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := UpgradeAgent(hostOfNode(r.Progress[i].IP, ctx), r.Version, ctx)
+				lock.Lock()
+				if err != nil {
+					failures++
+					r.Progress[i].State, r.Progress[i].Message = RolloutFailed, err.Error()
+					ctx.Application().Logger().Errorf("Rollout <%s>: %s", r.ID, err)
+				} else {
+					r.Progress[i].State = RolloutUpgraded
+				}
+				lock.Unlock()
+			}(i)
+		}
+		save()
+		wg.Wait()
+		save()
+	}
+
+	r.State = RolloutDone
+	if failures > r.MaxFailures {
+		r.State = RolloutFailed
+		for i := range r.Progress {
+			if r.Progress[i].State == RolloutPending {
+				r.Progress[i].State, r.Progress[i].Message = RolloutSkipped, "rollout stopped by failures"
+			}
+		}
+	}
+	r.FinishTime = time.Now().Format("2006-01-02 15:04:05")
+	ctx.Application().Logger().Infof("Rollout <%s> of agent %s is %s, failures: %d", r.ID, r.Version, r.State, failures)
+	save()
+}
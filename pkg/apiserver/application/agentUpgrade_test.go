@@ -0,0 +1,84 @@
+package application
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+func TestAgentReleasePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apiserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldWorkDir := WORK_DIR
+	WORK_DIR = dir
+	defer func() { WORK_DIR = oldWorkDir }()
+
+	f, err := os.Create(filepath.Join(dir, AGENT_ZIP_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range map[string]string{"agent/agent.sh": "exit 0\n", "agent/agent": "binary"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	path, err := AgentReleasePath(agent.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "binary" {
+		t.Errorf("expect the bundled agent extracted, got %q", b)
+	}
+	if _, err := AgentReleasePath("v9.9.9"); err == nil {
+		t.Error("expect an agent not exist refused")
+	}
+	if _, err := AgentReleasePath("../agents"); err == nil {
+		t.Error("expect an illegal version refused")
+	}
+
+	releases, err := ListAgentReleases()
+	if err != nil || len(releases) != 1 || releases[0].Version != agent.Version {
+		t.Errorf("expect the bundled agent listed, got %v, %v", releases, err)
+	}
+}
+
+func TestPlanRollout(t *testing.T) {
+	ready := func(ip, version, mode string) *Node {
+		n := &Node{NodeStatus: agent.NodeStatus{IP: ip, AgentVersion: version, Mode: mode}}
+		n.Conditions, _ = setCondition(n.Conditions, ConditionReady, true, "AgentHeartbeat", "")
+		return n
+	}
+	nodes := []*Node{
+		ready("192.168.19.101", "v0.1.0", agent.ModePush),
+		ready("192.168.19.102", "v0.2.0", agent.ModePush),
+		ready("192.168.19.103", "v0.1.0", agent.ModePull),
+		{NodeStatus: agent.NodeStatus{IP: "192.168.19.104", AgentVersion: "v0.1.0"}},
+		ready("192.168.19.105", "v0.1.0", agent.ModeTunnel),
+	}
+
+	progress := planRollout(&AgentRollout{Version: "v0.2.0"}, nodes)
+	expect := []RolloutState{RolloutPending, RolloutSkipped, RolloutSkipped, RolloutSkipped, RolloutPending}
+	for i, p := range progress {
+		if p.State != expect[i] {
+			t.Errorf("node <%s>: expect %s, got %s (%s)", p.IP, expect[i], p.State, p.Message)
+		}
+	}
+
+	progress = planRollout(&AgentRollout{Version: "v0.2.0", Nodes: []string{"192.168.19.105"}}, nodes)
+	if len(progress) != 1 || progress[0].IP != "192.168.19.105" {
+		t.Errorf("expect only the node wanted, got %v", progress)
+	}
+}
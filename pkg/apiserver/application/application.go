@@ -1,6 +1,8 @@
 package application
 
 import (
+	"time"
+
 	"github.com/kataras/iris"
 )
 
@@ -79,6 +81,8 @@ type Applications interface {
 	// 2. app not exist -> return (nil, nil)
 	// 3. some error occur -> return (nil, err)
 	Delete(name string, ctx iris.Context) (Application, error)
+	// List return all apps in Applications
+	List(ctx iris.Context) []Application
 }
 
 type AppsStatusChanged interface {
@@ -94,6 +98,15 @@ type AppsStatusChanged interface {
 type Application interface {
 	UpdateStatus(action ApplicationAction, ctx iris.Context)
 	SetStatus(expect, realtime ApplicationStatus, ctx iris.Context)
+	// RecordCheck records a check result of the time and return the status the latest results stand for
+	RecordCheck(healthy bool, msg string, at time.Time, ctx iris.Context) ApplicationStatus
+	// GetConditions return all conditions of the app, eg. Flapping
+	GetConditions() []Conditionx
+	// Reported records that a check report of the app is received just now
+	Reported(ctx iris.Context)
+	// AutoRestart restarts the app later if its restart policy asks for it
+	AutoRestart(ctx iris.Context)
+	EventLog
 	GetStatus() *Statusx
 	GetName() string
 	GetApp() *Appx
@@ -0,0 +1,123 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+// discoveryTTL is how long the discovery of an agent is trusted, an upgrade forgets it at once
+const discoveryTTL = time.Minute
+
+type cachedDiscovery struct {
+	discovery *agent.Discovery
+	at        time.Time
+}
+
+// discoveries caches the discovery of agents, key: ip of the node
+var discoveries sync.Map
+
+// discoverAgent return the version and capabilities of the agent on the host. An agent without
+// /discovery is older than capabilities, it's taken as having none of them.
+func discoverAgent(host Hostx, ctx iris.Context) (*agent.Discovery, error) {
+	if v, ok := discoveries.Load(host.IP); ok {
+		if cached := v.(cachedDiscovery); time.Since(cached.at) < discoveryTTL {
+			return cached.discovery, nil
+		}
+	}
+
+	status, body, err := agentDo(host, "GET", "discovery", nil, agentTimeout, ctx)
+	if err != nil {
+		return nil, err
+	}
+	var d agent.Discovery
+	switch status {
+	case http.StatusOK:
+		if err := json.Unmarshal(body, &d); err != nil {
+			return nil, fmt.Errorf("discovery of agent on <%s> is illegal: %s", host.IP, err)
+		}
+	case http.StatusNotFound:
+		version, err := agentVersion(host, ctx)
+		if err != nil {
+			return nil, err
+		}
+		d.Version = version
+	default:
+		return nil, fmt.Errorf("discover agent on <%s> got %d %s", host.IP, status, http.StatusText(status))
+	}
+	discoveries.Store(host.IP, cachedDiscovery{discovery: &d, at: time.Now()})
+	return &d, nil
+}
+
+// forgetDiscovery drops the cached discovery of the agent on the node, eg. after it's upgraded
+func forgetDiscovery(ip string) {
+	discoveries.Delete(ip)
+}
+
+// requiredCapabilities return the capabilities the agent needs to run the action of the app
+func requiredCapabilities(appInfo *agent.AppInfo) []string {
+	var required []string
+	if appInfo.Bundle != nil {
+		required = append(required, agent.CapBundles)
+	}
+	if len(appInfo.Probes) > 0 {
+		required = append(required, agent.CapProbes)
+	}
+	if len(appInfo.Mirrors) > 0 || len(appInfo.Checksums) > 0 {
+		required = append(required, agent.CapMirrors)
+	}
+	return required
+}
+
+// CapabilityError is returned when the agent can't perform an operation, it tells how to upgrade it
+type CapabilityError struct {
+	IP      string
+	Version string
+	Missing []string
+}
+
+func (e *CapabilityError) Error() string {
+	version := e.Version
+	if version == "" {
+		version = "(unknown version)"
+	}
+	return fmt.Sprintf("agent %s on <%s> doesn't support %s, upgrade it to %s: "+
+		`POST /apis/v1alpha1/agent-rollouts {"version":"%s","nodes":["%s"]}`,
+		version, e.IP, strings.Join(e.Missing, ", "), agent.Version, agent.Version, e.IP)
+}
+
+// missingCapabilities return an error if the capabilities lack any of the required ones
+func missingCapabilities(ip, version string, capabilities, required []string) error {
+	var missing []string
+	for _, c := range required {
+		if !agent.HasCapability(capabilities, c) {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return &CapabilityError{IP: ip, Version: version, Missing: missing}
+}
+
+// checkAgentCapabilities refuses the action if the agent on the host can't perform it. An agent
+// can't be discovered is not refused here, calling it tells why.
+func checkAgentCapabilities(host Hostx, appInfo *agent.AppInfo, ctx iris.Context) error {
+	required := requiredCapabilities(appInfo)
+	if len(required) == 0 {
+		return nil
+	}
+	d, err := discoverAgent(host, ctx)
+	if err != nil {
+		ctx.Application().Logger().Infof("Discover agent on <%s> failed: %s", host.IP, err)
+		return nil
+	}
+	return missingCapabilities(host.IP, d.Version, d.Capabilities, required)
+}
@@ -0,0 +1,80 @@
+package application
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+func TestCheckAgentCapabilities(t *testing.T) {
+	// an agent older than capabilities has no /discovery
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			w.Write([]byte(`{"message":"pong"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	ip, port, _ := net.SplitHostPort(u.Host)
+	oldPort := AGENT_PORT
+	AGENT_PORT = port
+	defer func() { AGENT_PORT = oldPort }()
+	host := Hostx{IP: ip}
+	defer forgetDiscovery(ip)
+
+	if err := checkAgentCapabilities(host, &agent.AppInfo{Name: "mysql", Check: "check.sh"}, nil); err != nil {
+		t.Errorf("expect an app of scripts only runs on any agent, got %s", err)
+	}
+	err := checkAgentCapabilities(host, &agent.AppInfo{Name: "mysql", Probes: []agent.Probe{{Name: "port", Type: "tcp"}}}, nil)
+	capErr, ok := err.(*CapabilityError)
+	if !ok {
+		t.Fatalf("expect a CapabilityError, got %v", err)
+	}
+	if len(capErr.Missing) != 1 || capErr.Missing[0] != agent.CapProbes {
+		t.Errorf("expect probes missing, got %v", capErr.Missing)
+	}
+	if !strings.Contains(err.Error(), "upgrade it to "+agent.Version) {
+		t.Errorf("expect an upgrade hint, got %s", err)
+	}
+
+	// the discovery is cached until it's forgotten
+	forgetDiscovery(ip)
+	discoveries.Store(ip, cachedDiscovery{discovery: &agent.Discovery{Version: "v0.2.0", Capabilities: agent.Capabilities}, at: time.Now()})
+	if err := checkAgentCapabilities(host, &agent.AppInfo{Name: "mysql", Bundle: &agent.Bundle{Name: "mysql.tar.gz"}}, nil); err != nil {
+		t.Errorf("expect a bundle runs on an agent with bundles, got %s", err)
+	}
+}
+
+func TestDiscoverAgentWithIllegalPing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			w.Write([]byte("pong"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	ip, port, _ := net.SplitHostPort(u.Host)
+	oldPort := AGENT_PORT
+	AGENT_PORT = port
+	defer func() { AGENT_PORT = oldPort }()
+	defer forgetDiscovery(ip)
+
+	if _, err := discoverAgent(Hostx{IP: ip}, nil); err == nil {
+		t.Error("expect an agent answering /ping without json isn't discovered")
+	}
+	if _, ok := discoveries.Load(ip); ok {
+		t.Error("expect a failed discovery isn't cached")
+	}
+}
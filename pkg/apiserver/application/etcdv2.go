@@ -144,6 +144,77 @@ func (apps *ETCDApplications) Get(name string, ctx iris.Context) (Application, b
 	return retApp, true
 }
 
+// maxChangeRetries bounds how many times Change applies a change when the app is saved by others meanwhile
+const maxChangeRetries = 5
+
+// Change applies change to the app in ETCDApplications and saves it only if nobody saved the app
+// meanwhile, or it's applied again to the latest app; so change may run more than once. It return
+// the saved app, false if the app doesn't exist or can't be read like Get.
+func (apps *ETCDApplications) Change(name string, change func(app Application), ctx iris.Context) (Application, bool, error) {
+	key := fmt.Sprintf("%s/%s", apps.prefix, name)
+	for i := 0; ; i++ {
+		resp, err := apps.kapi.Get(context.Background(), key, nil)
+		if client.IsKeyNotFound(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var app = new(GenericApplication)
+		if err := json.Unmarshal([]byte(resp.Node.Value), app); err != nil {
+			ctx.Application().Logger().Errorf("Change app <%s>, json unmarshal failed: <%s>", name, err.Error())
+			return nil, false, nil
+		}
+
+		change(app)
+		appBytes, err := json.MarshalIndent(app, "", " ")
+		if err != nil {
+			return nil, false, err
+		}
+		_, err = apps.kapi.Set(context.Background(), key, string(appBytes), &client.SetOptions{PrevIndex: resp.Node.ModifiedIndex})
+		if isConflict(err) && i < maxChangeRetries {
+			ctx.Application().Logger().Infof("App <%s> is saved by others, change it again", name)
+			continue
+		}
+		if err != nil {
+			ctx.Application().Logger().Errorf("Change app <%s> in etcd failed. with error: <%s>", name, err.Error())
+			return nil, false, err
+		}
+		return app, true, nil
+	}
+}
+
+// isConflict return true if a compare-and-swap failed as the node is modified
+func isConflict(err error) bool {
+	e, ok := err.(client.Error)
+	return ok && e.Code == client.ErrorCodeTestFailed
+}
+
+// List return all apps in ETCDApplications, apps which can't be unmarshaled are skipped
+func (apps *ETCDApplications) List(ctx iris.Context) []Application {
+	resp, err := apps.kapi.Get(context.Background(), apps.prefix, nil)
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("List applications from ETCDApplications failed: <%s>", err.Error())
+		}
+		return nil
+	}
+
+	var list = make([]Application, 0, len(resp.Node.Nodes))
+	for _, node := range resp.Node.Nodes {
+		if node.Dir {
+			continue
+		}
+		var app = new(GenericApplication)
+		if err := json.Unmarshal([]byte(node.Value), app); err != nil {
+			ctx.Application().Logger().Errorf("List app <%s>, json unmarshal failed: <%s>", node.Key, err.Error())
+			continue
+		}
+		list = append(list, app)
+	}
+	return list
+}
+
 func (apps *ETCDApplications) Delete(name string, ctx iris.Context) (Application, error) {
 	key := fmt.Sprintf("%s/%s", apps.prefix, name)
 	resp, err := apps.kapi.Get(context.Background(), key, nil)
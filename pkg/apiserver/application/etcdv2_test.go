@@ -0,0 +1,82 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kataras/iris"
+	irisctx "github.com/kataras/iris/context"
+)
+
+// fakeKeys keeps one app in memory, and lets a user save it right after it's read once
+type fakeKeys struct {
+	client.KeysAPI
+	value     string
+	index     uint64
+	userSaves func(app *GenericApplication)
+}
+
+func (k *fakeKeys) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	if k.value == "" {
+		return nil, client.Error{Code: client.ErrorCodeKeyNotFound}
+	}
+	resp := &client.Response{Node: &client.Node{Key: key, Value: k.value, ModifiedIndex: k.index}}
+	if k.userSaves != nil {
+		var app GenericApplication
+		json.Unmarshal([]byte(k.value), &app)
+		k.userSaves(&app)
+		k.userSaves = nil
+		b, _ := json.Marshal(&app)
+		k.value, k.index = string(b), k.index+1
+	}
+	return resp, nil
+}
+
+func (k *fakeKeys) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	if opts != nil && opts.PrevIndex != 0 && opts.PrevIndex != k.index {
+		return nil, client.Error{Code: client.ErrorCodeTestFailed}
+	}
+	k.value, k.index = value, k.index+1
+	return &client.Response{Node: &client.Node{Key: key, Value: value, ModifiedIndex: k.index}}, nil
+}
+
+func TestChangeKeepsConcurrentSaves(t *testing.T) {
+	ctx := irisctx.NewContext(iris.New())
+	app := &GenericApplication{Name: "mysql"}
+	app.App.Status = Statusx{Expect: Running, Realtime: Running}
+	b, _ := json.Marshal(app)
+	keys := &fakeKeys{value: string(b), index: 1}
+	apps := &ETCDApplications{kapi: keys, prefix: "/test"}
+
+	// a user stops the app while a check result is recorded
+	keys.userSaves = func(app *GenericApplication) { app.App.Status.Expect = Stopped }
+	changes := 0
+	saved, ok, err := apps.Change("mysql", func(app Application) {
+		changes++
+		app.RecordCheck(true, "ok", time.Now(), ctx)
+	}, ctx)
+	if err != nil || !ok {
+		t.Fatalf("expect the app changed, got %v, %v", ok, err)
+	}
+	if changes != 2 {
+		t.Errorf("expect the change applied again to the app the user saved, applied %d times", changes)
+	}
+
+	var stored GenericApplication
+	if err := json.Unmarshal([]byte(keys.value), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.App.Status.Expect != Stopped || len(stored.Health.History) != 1 {
+		t.Errorf("expect both the user's status and the check result kept, got %+v, %+v", stored.App.Status, stored.Health.History)
+	}
+	if saved.GetStatus().Expect != Stopped {
+		t.Errorf("expect the saved app returned, got %+v", saved.GetStatus())
+	}
+
+	if _, ok, err := (&ETCDApplications{kapi: &fakeKeys{}, prefix: "/test"}).Change("gone", func(Application) {}, ctx); ok || err != nil {
+		t.Errorf("expect a missing app not changed, got %v, %v", ok, err)
+	}
+}
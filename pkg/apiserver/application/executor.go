@@ -0,0 +1,166 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+const (
+	ExecutorAgent = "agent" // the agent on the host runs scripts, it's the default
+	ExecutorSSH   = "ssh"   // the apiserver runs scripts over ssh, no agent is needed on the host
+	ExecutorPull  = "pull"  // a pull mode agent polls actions from the apiserver, for hosts the apiserver can't reach
+)
+
+// Executor runs an action of an app on its host. An action whose script didn't succeed
+// returns an *ActionError, so the result can be told from resultOf.
+type Executor interface {
+	// Prepare makes sure the host can run actions before an install
+	Prepare(host Hostx, ctx iris.Context) error
+	Execute(action ApplicationAction, appInfo *agent.AppInfo, app *GenericApplication, ctx iris.Context) error
+}
+
+// executorOf return the executor of the app, the executor of the app overrides the one of its host
+func executorOf(app *GenericApplication) (Executor, error) {
+	name := app.GetApp().Executor
+	if name == "" && len(app.GetHosts()) > 0 {
+		name = app.GetHosts()[0].Executor
+	}
+	switch name {
+	case "", ExecutorAgent:
+		return &agentExecutor{}, nil
+	case ExecutorSSH:
+		return &sshExecutor{}, nil
+	case ExecutorPull:
+		return &pullExecutor{}, nil
+	}
+	return nil, fmt.Errorf("executor <%s> is illegal, expect agent, ssh or pull", name)
+}
+
+// ValidateExecutor return an error if the executor of the app or its hosts is illegal
+func ValidateExecutor(app Application) error {
+	names := []string{app.GetApp().Executor}
+	for _, host := range app.GetHosts() {
+		names = append(names, host.Executor)
+	}
+	for _, name := range names {
+		switch name {
+		case "", ExecutorAgent, ExecutorSSH, ExecutorPull:
+		default:
+			return fmt.Errorf("executor <%s> is illegal, expect agent, ssh or pull", name)
+		}
+	}
+	return nil
+}
+
+// agentExecutor posts the action to the agent on the host
+type agentExecutor struct{}
+
+// Prepare bootstraps the agent over ssh unless the node is ready
+func (e *agentExecutor) Prepare(host Hostx, ctx iris.Context) error {
+	return ensureAgent(host, ctx)
+}
+
+func (e *agentExecutor) Execute(action ApplicationAction, appInfo *agent.AppInfo, app *GenericApplication, ctx iris.Context) error {
+	host := app.GetHosts()[0]
+	if err := checkAgentCapabilities(host, appInfo, ctx); err != nil {
+		return err
+	}
+
+	// the grpc api of protocol v1 streams the progress, the json api is kept for agents without it
+	if port := agentGRPCPort(host, ctx); port != "" {
+		ctx.Application().Logger().Infof("call to agent by grpc: %s %s", net.JoinHostPort(host.IP, port), action)
+		ctx.Application().Logger().Infof("Call to agent with app:\n%s", appInfo.Print())
+		delivered, err := agentGRPCDo(host, port, action, appInfo, ctx)
+		if delivered {
+			return err
+		}
+		ctx.Application().Logger().Infof("grpc api of agent on <%s> is unavailable: %s, call the json api", host.IP, err)
+	}
+
+	ctx.Application().Logger().Infof("call to agent: %s", agentURL(host, string(action)))
+
+	jsonBody, err := json.Marshal(appInfo)
+	if err != nil {
+		ctx.Application().Logger().Error(err)
+		return err
+	}
+
+	ctx.Application().Logger().Infof("Call to agent with body:\n%s", appInfo.Print())
+
+	// through the tunnel of the agent if it's connected, or the relay of its zone, see agentDo
+	status, bodyBytes, err := agentDo(host, "POST", string(action), jsonBody, 0, ctx)
+	if err != nil {
+		ctx.Application().Logger().Error(err)
+
+		if !agentUnreachable(err) {
+			return err
+		}
+
+		waitTime := 5 * time.Second
+		retry := 5 // 5s;10s;20s;40s;80s
+		for i := 0; i < retry; i++ {
+			ctx.Application().Logger().Infof("wait for agent start, retry %d/%d", i+1, retry)
+			time.Sleep(waitTime)
+			waitTime = waitTime * 2
+			status, bodyBytes, err = agentDo(host, "POST", string(action), jsonBody, 0, ctx)
+			if err != nil {
+				if !agentUnreachable(err) {
+					return err
+				}
+				ctx.Application().Logger().Infof("Failed again: %s", err)
+				continue
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if status != 200 {
+		errMsg := fmt.Sprintf("status: <%d %s>; msg: <%s>", status, http.StatusText(status), string(bodyBytes))
+		ctx.Application().Logger().Errorf("Result code != 200: %s", errMsg)
+
+		// older agents return no result, treat it as failed
+		var agentResp agentResponse
+		if err := json.Unmarshal(bodyBytes, &agentResp); err != nil || agentResp.Result == "" {
+			return errors.New(errMsg)
+		}
+		return &ActionError{
+			Action:   action,
+			Result:   agentResp.Result,
+			ExitCode: agentResp.ExitCode,
+			Msg:      agentResp.Error,
+		}
+	}
+	return nil
+}
+
+// agentUnreachable return true if err tells the agent isn't listening yet or doesn't answer in time,
+// the call may be tried again then. An error of a tunnel isn't, the action may have run.
+func agentUnreachable(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
@@ -0,0 +1,60 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/farmer-hutao/paas-operator/pkg/tunnel"
+)
+
+func TestExecutorOf(t *testing.T) {
+	cases := []struct {
+		host, app string
+		expect    Executor
+	}{
+		{"", "", &agentExecutor{}},
+		{ExecutorSSH, "", &sshExecutor{}},
+		{ExecutorSSH, ExecutorAgent, &agentExecutor{}},
+		{ExecutorAgent, ExecutorSSH, &sshExecutor{}},
+		{ExecutorPull, "", &pullExecutor{}},
+	}
+	for i, c := range cases {
+		app := &GenericApplication{Host: []Hostx{{IP: "192.168.19.101", Executor: c.host}}, App: Appx{Executor: c.app}}
+		executor, err := executorOf(app)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if fmt.Sprintf("%T", executor) != fmt.Sprintf("%T", c.expect) {
+			t.Errorf("case %d: expect %T, got %T", i, c.expect, executor)
+		}
+	}
+
+	app := &GenericApplication{Host: []Hostx{{IP: "192.168.19.101", Executor: "winrm"}}}
+	if err := ValidateExecutor(app); err == nil {
+		t.Error("expect an error for an illegal executor")
+	}
+}
+
+func TestAgentUnreachable(t *testing.T) {
+	// nothing listens on the port of a closed listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, err = http.Get("http://" + addr + "/install")
+	if !agentUnreachable(err) {
+		t.Errorf("expect a refused connection unreachable, got %v", err)
+	}
+
+	if agentUnreachable(tunnel.ErrClosed) {
+		t.Error("expect a closed tunnel not unreachable, the action may have run")
+	}
+	if agentUnreachable(errors.New("connection refused by the script")) {
+		t.Error("expect only network errors unreachable")
+	}
+}
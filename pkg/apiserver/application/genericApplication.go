@@ -1,22 +1,18 @@
 package application
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/iris-contrib/go.uuid"
 	"github.com/kataras/iris"
 
 	"github.com/farmer-hutao/paas-operator/pkg/agent"
-	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils"
 	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils/sshcli"
 )
 
@@ -26,6 +22,8 @@ var (
 	AGENT_PORT     = os.Getenv("AGENT_PORT")
 	OPERATOR_IP    = os.Getenv("OPERATOR_IP")
 	OPERATOR_PORT  = os.Getenv("OPERATOR_PORT")
+	// OPERATOR_GRPC_PORT serves the grpc api of protocol v1 to agents, next to the json api
+	OPERATOR_GRPC_PORT = os.Getenv("OPERATOR_GRPC_PORT")
 )
 
 func init() {
@@ -49,6 +47,10 @@ func init() {
 		OPERATOR_PORT = "3334"
 		log.Printf("Warning: %s is unset, use default value: %s", "OPERATOR_PORT", OPERATOR_PORT)
 	}
+	if OPERATOR_GRPC_PORT == "" {
+		OPERATOR_GRPC_PORT = "3333"
+		log.Printf("Warning: %s is unset, use default value: %s", "OPERATOR_GRPC_PORT", OPERATOR_GRPC_PORT)
+	}
 }
 
 // GenericApplication is a generic implement of Application interface
@@ -59,16 +61,39 @@ type GenericApplication struct {
 	Host  []Hostx `json:"host"`
 	App   Appx    `json:"app"`
 	Event Eventx  `json:"event"`
+	// Health is maintained by check reports
+	Health Healthx `json:"health"`
+	// Restarts is maintained by the restart policy
+	Restarts Restartsx `json:"restarts"`
 }
 
 type Hostx struct {
 	IP   string  `json:"ip"`
 	Auth []Authx `json:"auth"`
+	// Executor runs actions of apps on the host: agent (default) or ssh, see Executor
+	Executor string `json:"executor,omitempty"`
+	// Zone is the name of the zone the host is in, the apiserver reaches it by the relay of the zone, see Zone
+	Zone string `json:"zone,omitempty"`
+	// Jumps are the ssh bastions to the host in order, the ones of its zone are used if it's empty
+	Jumps []Jumpx `json:"jumps,omitempty"`
+	// Profile is how the apiserver connects to the host: ports, address family and the saved credential
+	Profile *Profilex `json:"profile,omitempty"`
+}
+
+// Jumpx is an ssh bastion with its own credentials
+type Jumpx struct {
+	IP       string `json:"ip"`
+	Port     string `json:"port,omitempty"`
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 type Authx struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Become is how the ssh user becomes the agent user: su (default) or sudo, only for the first auth.
+	// With sudo the password of the ssh user is used, and it may be empty for passwordless sudo.
+	Become string `json:"become,omitempty"`
 }
 
 type Appx struct {
@@ -80,8 +105,30 @@ type Appx struct {
 	Uninstall string            `json:"uninstall"` // uninstall.sh
 	Check     string            `json:"check"`     // check.sh
 	Package   string            `json:"package"`   // mysql-5.7.tar.gz
-	Metadata  map[string]string `json:"metadata"`
-	Status    Statusx           `json:"status"`
+	Version   string            `json:"version"`   // 5.7.26
+	Mirrors   []string          `json:"mirrors"`   // [ http://192.168.19.102:8080/ ]
+	Checksums map[string]string `json:"checksums"` // sha256 of scripts and package, {"install.sh":"9f86d0..."}
+	Bundle    *Bundlex          `json:"bundle,omitempty"`
+	Probes    []agent.Probe     `json:"probes,omitempty"` // run by the agent instead of the check script
+	// how check results change the realtime status, see HealthPolicyx
+	HealthPolicy *HealthPolicyx `json:"health_policy,omitempty"`
+	// whether a failed app is restarted automatically, see RestartPolicyx
+	RestartPolicy *RestartPolicyx `json:"restart_policy,omitempty"`
+	// what to do if install.sh failed, see InstallFailurePolicyx
+	InstallFailurePolicy *InstallFailurePolicyx `json:"install_failure_policy,omitempty"`
+	// Executor overrides the executor of the host for the app, see Hostx
+	Executor string            `json:"executor,omitempty"`
+	Metadata map[string]string `json:"metadata"`
+	Status   Statusx           `json:"status"`
+}
+
+// Bundlex is an archive holding all scripts of an app version and a manifest.json like:
+// {"name":"mysql","version":"5.7.26","scripts":{"install":"scripts/install.sh",...}}
+// If it's set, the script names in Appx are ignored.
+type Bundlex struct {
+	Name    string `json:"name"`    // mysql-5.7.26-bundle.tar.gz, fetched from repo_url or mirrors
+	Version string `json:"version"` // 5.7.26, must be the same with the manifest
+	SHA256  string `json:"sha256"`
 }
 
 type Statusx struct {
@@ -98,6 +145,9 @@ type Statusx struct {
 // ]
 type Eventx []map[string]string
 
+// maxEvents bounds the events kept in an app, the oldest are dropped
+const maxEvents = 100
+
 func (a *GenericApplication) UpdateStatus(action ApplicationAction, ctx iris.Context) {
 	ctx.Application().Logger().Infof("The application with name <%s> start update status; "+
 		"expect status: <%s>; realtime status: <%s>;", a.Name, a.App.Status.Expect, a.App.Status.Realtime)
@@ -114,9 +164,25 @@ func (a *GenericApplication) UpdateStatus(action ApplicationAction, ctx iris.Con
 	updateFn()
 	defer updateFn()
 
+	// an app which becomes running is expected to be reported, or it'll be unknown after a stale period
+	defer func() {
+		if a.App.Status.Realtime == Running {
+			a.Reported(ctx)
+		}
+	}()
+
+	// a failed start or restart is tried again by the restart policy; saved by updateFn above
+	if action == AStart || action == ARestart {
+		defer a.AutoRestart(ctx)
+	}
+
 	switch action {
 	case AInstall:
 		defer func() {
+			// an app rolled back has nothing to check
+			if a.App.Status.Realtime == NotInstalled {
+				return
+			}
 			err := CallToAgent(ACheck, a, ctx)
 			if err != nil {
 				ctx.Application().Logger().Errorf("Call to agent to start check failed: <%s>", err)
@@ -124,45 +190,66 @@ func (a *GenericApplication) UpdateStatus(action ApplicationAction, ctx iris.Con
 			ctx.Application().Logger().Info("Call to agent to start check success")
 		}()
 
-		if err := InitAgent(a.Host[0].IP, a.Host[0].Auth, ctx); err != nil {
-			ctx.Application().Logger().Errorf("Init agent failed: <%s>", err.Error())
-			a.App.Status.Realtime = Failed
-			return
+		executor, err := executorOf(a)
+		if err == nil {
+			err = executor.Prepare(a.Host[0], ctx)
 		}
-		// wait the agent starting
-		time.Sleep(5 * time.Second)
-		if err := CallToAgent(AInstall, a, ctx); err != nil {
+		if err != nil {
+			ctx.Application().Logger().Errorf("Prepare host failed: <%s>", err.Error())
 			a.App.Status.Realtime = Failed
 			return
 		}
-		a.App.Status.Realtime = Running
-	case AStart:
-		if err := CallToAgent(AStart, a, ctx); err != nil {
-			a.App.Status.Realtime = Failed
-			return
+		a.App.Status.Realtime = a.install(ctx)
+		if a.App.Status.Realtime == NotInstalled {
+			// rolled back cleanly, a human decides whether to install again
+			a.App.Status.Expect = NotInstalled
 		}
-		a.App.Status.Realtime = Running
+	case AStart:
+		a.App.Status.Realtime = realtimeAfter(AStart, CallToAgent(AStart, a, ctx))
 	case AStop:
-		if err := CallToAgent(AStop, a, ctx); err != nil {
-			a.App.Status.Realtime = Failed
-			return
-		}
-		a.App.Status.Realtime = Stopped
+		a.App.Status.Realtime = realtimeAfter(AStop, CallToAgent(AStop, a, ctx))
 	case ARestart:
-		if err := CallToAgent(ARestart, a, ctx); err != nil {
-			a.App.Status.Realtime = Failed
-			return
-		}
-		a.App.Status.Realtime = Running
+		a.App.Status.Realtime = realtimeAfter(ARestart, CallToAgent(ARestart, a, ctx))
 	case AUninstall:
-		if err := CallToAgent(AUninstall, a, ctx); err != nil {
-			a.App.Status.Realtime = Failed
-			return
-		}
-		a.App.Status.Realtime = NotInstalled
+		a.App.Status.Realtime = realtimeAfter(AUninstall, CallToAgent(AUninstall, a, ctx))
 	}
 }
 
+// statusAfterAction maps the result of an action to the realtime status of the app;
+// a result not listed means the app failed.
+var statusAfterAction = map[ApplicationAction]map[agent.ScriptResult]ApplicationStatus{
+	AInstall: {
+		agent.ResultOK:             Running,
+		agent.ResultAlreadyRunning: Running,
+	},
+	AStart: {
+		agent.ResultOK:             Running,
+		agent.ResultAlreadyRunning: Running,
+		agent.ResultNotInstalled:   NotInstalled,
+	},
+	AStop: {
+		agent.ResultOK:             Stopped,
+		agent.ResultAlreadyStopped: Stopped,
+		agent.ResultNotInstalled:   NotInstalled,
+	},
+	ARestart: {
+		agent.ResultOK:           Running,
+		agent.ResultNotInstalled: NotInstalled,
+	},
+	AUninstall: {
+		agent.ResultOK:           NotInstalled,
+		agent.ResultNotInstalled: NotInstalled,
+	},
+}
+
+// realtimeAfter return the realtime status of an app after the action returned err
+func realtimeAfter(action ApplicationAction, err error) ApplicationStatus {
+	if status, ok := statusAfterAction[action][resultOf(err)]; ok {
+		return status
+	}
+	return Failed
+}
+
 func (a *GenericApplication) GetStatus() *Statusx {
 	return &a.App.Status
 }
@@ -204,37 +291,65 @@ func (a *GenericApplication) GetHosts() []Hostx {
 	return a.Host
 }
 
+// AddEvent adds an event to the app and saves the app, the event is stamped with "time"
 func (a *GenericApplication) AddEvent(event map[string]string, ctx iris.Context) (bool, error) {
-	return false, nil
+	a.appendEvent(event)
+	if err := GetETCDApplications(AppType(a.Type)).Update(a.GetName(), a, ctx); err != nil {
+		ctx.Application().Logger().Errorf("Save event of <%s> failed: %s", a.Name, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// appendEvent adds an event to the app without saving it
+func (a *GenericApplication) appendEvent(event map[string]string) {
+	e := map[string]string{"time": time.Now().Format("2006-01-02 15:04:05")}
+	for k, v := range event {
+		e[k] = v
+	}
+	a.Event = append(a.Event, e)
+	if len(a.Event) > maxEvents {
+		a.Event = a.Event[len(a.Event)-maxEvents:]
+	}
 }
 
 func (a *GenericApplication) GetEvents() []map[string]string {
-	return nil
+	return a.Event
 }
 
-func InitAgent(ip string, auth []Authx, ctx iris.Context) error {
-	var tmpDir = "/tmp/"
-	var sshPort = "22"
+// hostCredentials return the ssh user and how it becomes the agent user from the auth of a host:
+// if only one auth is set, use it anywhere; if two, ssh by the second and run as the first.
+func hostCredentials(auth []Authx) (string, string, sshcli.Become, error) {
+	if len(auth) < 1 {
+		return "", "", sshcli.Become{}, errors.New("Auth is nil")
+	}
+	sshAuth := auth[0]
+	if len(auth) > 1 {
+		sshAuth = auth[1]
+	}
+	become := sshcli.Become{Method: auth[0].Become, User: auth[0].Username, Password: auth[0].Password}
+	if become.Method == sshcli.BecomeSudo {
+		become.Password = sshAuth.Password
+	}
+	return sshAuth.Username, sshAuth.Password, become, nil
+}
 
-	var (
-		agentUser   string
-		agentPasswd string
-		sshUser     string
-		sshPasswd   string
-	)
+func InitAgent(host Hostx, ctx iris.Context) error {
+	ip := host.IP
+	var tmpDir = "/tmp/"
 
-	if len(auth) < 1 {
-		return errors.New("Auth is nil")
-	} else if len(auth) == 1 {
-		agentUser = auth[0].Username
-		agentPasswd = auth[0].Password
-		sshUser = agentUser
-		sshPasswd = agentPasswd
-	} else {
-		agentUser = auth[0].Username
-		agentPasswd = auth[0].Password
-		sshUser = auth[1].Username
-		sshPasswd = auth[1].Password
+	auth, err := hostAuth(host, ctx)
+	if err != nil {
+		return err
+	}
+	sshUser, sshPasswd, become, err := hostCredentials(auth)
+	if err != nil {
+		return err
+	}
+	// through the relay and the jump hosts of its zone if it's in one
+	sshCli, err := sshClientOf(host, sshUser, sshPasswd, ctx)
+	if err != nil {
+		return err
 	}
 
 	ctx.Application().Logger().Info("start to init agent!!!")
@@ -242,7 +357,6 @@ func InitAgent(ip string, auth []Authx, ctx iris.Context) error {
 	localAgentTarPath := filepath.Join(WORK_DIR, AGENT_ZIP_NAME)
 	remoteTmpTarPath := filepath.Join(tmpDir, AGENT_ZIP_NAME)
 
-	sshCli := sshcli.New(ip, sshUser, sshPasswd, sshPort)
 	// max -> 10 minutes = 30*20s
 	retryTimes := 30
 	retryGap := 20 * time.Second
@@ -262,17 +376,54 @@ func InitAgent(ip string, auth []Authx, ctx iris.Context) error {
 
 	defer sshCli.Cli.Close()
 
+	// the agent bundled with the apiserver is built with the same version, see build/common.sh
+	installed := installedAgentVersion(sshCli)
+	if installed == agent.Version {
+		if err := PingAgent(host, ctx); err == nil {
+			ctx.Application().Logger().Infof("Agent %s is installed and running on <%s>, skip bootstrapping", installed, ip)
+			return nil
+		}
+	}
+	// agent.sh sets the port of the agent, it may not be the one of the running agent
+	if installed == agent.Version && host.agentPort() == AGENT_PORT {
+		ctx.Application().Logger().Infof("Agent %s is installed but not running on <%s>, restart it", installed, ip)
+		return execAs(sshCli, become, "systemctl restart agent.service", ctx)
+	}
+	ctx.Application().Logger().Infof("Install agent %s on <%s>, installed: <%s>", agent.Version, ip, installed)
+
 	// upload
 	if err := sshCli.UploadFile(localAgentTarPath, remoteTmpTarPath); err != nil {
 		ctx.Application().Logger().Error(err)
 		return err
 	}
 
-	// start agent
-	doWithSuCmd := fmt.Sprintf("echo '%s' > dowithsu.sh && chmod +x dowithsu.sh && ./dowithsu.sh %s %s", utils.DoWithSu, agentUser, agentPasswd)
-	cmd := fmt.Sprintf("tar -xzvf %s -C %s && %s 'sh %sagent/agent.sh'", remoteTmpTarPath, tmpDir, doWithSuCmd, tmpDir)
-	ctx.Application().Logger().Infof("Prepare to exec cmd: %s", cmd)
-	result, err := sshCli.ExecCmd(cmd)
+	// agent.sh installs the agent to agentBinPath with agent.service, it does nothing if it's current
+	remoteDir := filepath.Join(tmpDir, "paas-agent-"+agent.Version)
+	cmd := fmt.Sprintf("rm -rf %s && mkdir -p %s && tar -xzf %s -C %s", remoteDir, remoteDir, remoteTmpTarPath, remoteDir)
+	if _, err := sshCli.ExecCmd(cmd); err != nil {
+		ctx.Application().Logger().Errorf("Exec cmd: <%s> get error: <%s>", cmd, err.Error())
+		return err
+	}
+	return execAs(sshCli, become, "sh "+filepath.Join(remoteDir, "agent", "agent.sh")+" "+sshcli.ShellQuote(host.agentPort()), ctx)
+}
+
+// agentBinPath is where agent.sh installs the agent, agent.service runs it
+const agentBinPath = "/usr/local/bin/agent"
+
+// installedAgentVersion return the version of the agent installed on the host, "" if there isn't.
+// An old agent without -version starts serving instead, so it's bounded by timeout.
+func installedAgentVersion(sshCli *sshcli.SSHClient) string {
+	out, err := sshCli.ExecCmd(fmt.Sprintf("timeout 5 %s -version 2>/dev/null", agentBinPath))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// execAs runs cmd on the host as the agent user
+func execAs(sshCli *sshcli.SSHClient, become sshcli.Become, cmd string, ctx iris.Context) error {
+	ctx.Application().Logger().Infof("Prepare to exec cmd as <%s> by %s: %s", become.User, become.Method, cmd)
+	result, err := sshCli.ExecAs(cmd, become)
 	ctx.Application().Logger().Infof("Exec cmd: <%s> get result: <%s>", cmd, result)
 	if err != nil {
 		ctx.Application().Logger().Errorf("Exec cmd: <%s> get error: <%s>", cmd, err.Error())
@@ -281,18 +432,83 @@ func InitAgent(ip string, auth []Authx, ctx iris.Context) error {
 	return nil
 }
 
+// ActionError is returned by CallToAgent when the agent got the action but its script didn't succeed.
+type ActionError struct {
+	Action   ApplicationAction
+	Result   agent.ScriptResult
+	ExitCode int
+	Msg      string
+	// Code tells why the action failed if the agent is called by grpc, eg. DOWNLOAD_FAILED, see agentpb.Error_Code
+	Code string
+}
+
+func (e *ActionError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("action <%s> got result <%s> with exit code <%d> (%s): %s", e.Action, e.Result, e.ExitCode, e.Code, e.Msg)
+	}
+	return fmt.Sprintf("action <%s> got result <%s> with exit code <%d>: %s", e.Action, e.Result, e.ExitCode, e.Msg)
+}
+
+// resultOf return the result of an action from the error returned by CallToAgent
+func resultOf(err error) agent.ScriptResult {
+	if err == nil {
+		return agent.ResultOK
+	}
+	if actionErr, ok := err.(*ActionError); ok {
+		return actionErr.Result
+	}
+	return agent.ResultFailed
+}
+
+// agentResponse is the body returned by the agent for an action
+type agentResponse struct {
+	Msg      string             `json:"msg"`
+	Error    string             `json:"error"`
+	Result   agent.ScriptResult `json:"result"`
+	ExitCode int                `json:"exit_code"`
+}
+
+// CallToAgent asks the agent on the app's host to do the action; an action whose script
+// exits with agent.ExitRetryable is retried with the same operation id.
 func CallToAgent(action ApplicationAction, app *GenericApplication, ctx iris.Context) error {
-	var agentUrlPrefix = fmt.Sprintf("http://%s:%s/", app.GetHosts()[0].IP, AGENT_PORT)
-	var agentUrl = agentUrlPrefix + string(action)
+	operationID, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
 
-	ctx.Application().Logger().Infof("call to agent: %s", agentUrl)
+	waitTime := 10 * time.Second
+	retry := 3 // 10s;20s;40s
+	for i := 0; ; i++ {
+		err = callToAgent(action, app, operationID.String(), ctx)
+		if resultOf(err) != agent.ResultRetryable || i >= retry {
+			return err
+		}
+		ctx.Application().Logger().Infof("action <%s> of <%s> is retryable: %s; retry %d/%d", action, app.GetName(), err, i+1, retry)
+		time.Sleep(waitTime)
+		waitTime = waitTime * 2
+	}
+}
+
+func callToAgent(action ApplicationAction, app *GenericApplication, operationID string, ctx iris.Context) error {
+	executor, err := executorOf(app)
+	if err != nil {
+		return err
+	}
+
+	// agents in a zone reach the apiserver by the relay of the zone
+	operatorIp, operatorPort, relayToken, err := operatorFor(app.GetHosts()[0], ctx)
+	if err != nil {
+		return err
+	}
 
 	var appInfo agent.AppInfo
 
 	appInfo.Name = app.GetName()
 	appInfo.Type = app.Type
-	appInfo.OperatorIp = OPERATOR_IP
-	appInfo.OperatorPort = OPERATOR_PORT
+	appInfo.OperatorIp = operatorIp
+	appInfo.RelayToken = relayToken
+	appInfo.OperatorPort = operatorPort
+	appInfo.OperatorGRPCPort = operatorGRPCPortOf(operatorIp)
 	appInfo.RepoURL = app.GetApp().RepoURL
 	appInfo.Install = app.GetApp().Install
 	appInfo.Start = app.GetApp().Start
@@ -301,6 +517,15 @@ func CallToAgent(action ApplicationAction, app *GenericApplication, ctx iris.Con
 	appInfo.Uninstall = app.GetApp().Uninstall
 	appInfo.Check = app.GetApp().Check
 	appInfo.Package = app.GetApp().Package
+	appInfo.Version = app.GetApp().Version
+	appInfo.Mirrors = app.GetApp().Mirrors
+	appInfo.Checksums = app.GetApp().Checksums
+	appInfo.Probes = app.GetApp().Probes
+	if b := app.GetApp().Bundle; b != nil {
+		appInfo.Bundle = &agent.Bundle{Name: b.Name, Version: b.Version, SHA256: b.SHA256}
+	}
+	appInfo.OperationID = operationID
+	appInfo.HostIP = app.GetHosts()[0].IP
 	appInfo.Metadata = app.GetApp().Metadata
 
 	// repo_url and package environment is needed by scripts.
@@ -311,51 +536,5 @@ func CallToAgent(action ApplicationAction, app *GenericApplication, ctx iris.Con
 		appInfo.Metadata["PACKAGE"] = appInfo.Package
 	}
 
-	jsonBody, err := json.Marshal(appInfo)
-	if err != nil {
-		ctx.Application().Logger().Error(err)
-		return err
-	}
-
-	ctx.Application().Logger().Infof("Call to agent with body:\n%s", string(jsonBody))
-
-	resp, err := http.Post(agentUrl, "application/json;charset=utf-8", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		ctx.Application().Logger().Error(err)
-
-		if !strings.Contains(err.Error(), "connection refused") && !strings.Contains(err.Error(), "timeout") {
-			return err
-		}
-
-		waitTime := 5 * time.Second
-		retry := 5 // 5s;10s;20s;40s;80s
-		for i := 0; i < retry; i++ {
-			ctx.Application().Logger().Infof("wait for agent start, retry %d/%d", i+1, retry)
-			time.Sleep(waitTime)
-			waitTime = waitTime * 2
-			resp, err = http.Post(agentUrl, "application/json;charset=utf-8", bytes.NewBuffer(jsonBody))
-			if err != nil {
-				if !strings.Contains(err.Error(), "connection refused") && !strings.Contains(err.Error(), "timeout") {
-					return err
-				}
-				ctx.Application().Logger().Infof("Failed again: %s", err)
-				continue
-			}
-			break
-		}
-	}
-
-	if resp.StatusCode != 200 {
-		// TODO(ht) add buf
-		var bodyBytes = make([]byte, 512)
-		n, err := resp.Body.Read(bodyBytes)
-		if err != nil && err != io.EOF {
-			ctx.Application().Logger().Errorf("Read resp failed: <%s>", err.Error())
-			return err
-		}
-		errMsg := fmt.Sprintf("status: <%s>; msg: <%s>", resp.Status, string(bodyBytes[0:n]))
-		ctx.Application().Logger().Errorf("Result code != 200: %s", errMsg)
-		return errors.New(errMsg)
-	}
-	return nil
+	return executor.Execute(action, &appInfo, app, ctx)
 }
@@ -1,9 +1,34 @@
 package application
 
 import (
+	"errors"
 	"testing"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
 )
 
 func TestInitAgent(t *testing.T) {
 
 }
+
+func TestRealtimeAfter(t *testing.T) {
+	cases := []struct {
+		action ApplicationAction
+		err    error
+		expect ApplicationStatus
+	}{
+		{AStart, nil, Running},
+		{AStart, &ActionError{Result: agent.ResultAlreadyRunning}, Running},
+		{AStart, &ActionError{Result: agent.ResultNotInstalled}, NotInstalled},
+		{AStop, &ActionError{Result: agent.ResultAlreadyStopped}, Stopped},
+		{AUninstall, &ActionError{Result: agent.ResultNotInstalled}, NotInstalled},
+		{AInstall, &ActionError{Result: agent.ResultRetryable}, Failed},
+		{AInstall, errors.New("connection refused"), Failed},
+	}
+
+	for _, c := range cases {
+		if status := realtimeAfter(c.action, c.err); status != c.expect {
+			t.Errorf("action <%s> with err <%v>: expect <%s>, got <%s>", c.action, c.err, c.expect, status)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package application
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kataras/iris"
+)
+
+// maxCheckHistory bounds the check results kept in an app
+const maxCheckHistory = 50
+
+// defaultStaleSeconds is how long an app may go without check reports before it's unknown
+const defaultStaleSeconds = 120
+
+// condition types of an app
+const (
+	// ConditionFlapping is true if the check results of the app change too often
+	ConditionFlapping = "Flapping"
+)
+
+const (
+	ConditionTrue  = "True"
+	ConditionFalse = "False"
+)
+
+// HealthPolicyx decides how check results change the realtime status of an app.
+type HealthPolicyx struct {
+	// consecutive failed checks before running -> failed, 3 by default
+	FailureThreshold int `json:"failure_threshold"`
+	// consecutive passed checks before failed -> running, 1 by default
+	SuccessThreshold int `json:"success_threshold"`
+	// how many latest check results are looked at to find flapping, 10 by default
+	FlapWindow int `json:"flap_window"`
+	// how many healthy <-> unhealthy changes in the window mean flapping, 4 by default
+	FlapThreshold int `json:"flap_threshold"`
+	// seconds without check reports before running/failed -> unknown, 120 by default
+	StaleSeconds int `json:"stale_seconds"`
+}
+
+func (p *HealthPolicyx) withDefaults() HealthPolicyx {
+	var policy HealthPolicyx
+	if p != nil {
+		policy = *p
+	}
+	if policy.FailureThreshold <= 0 {
+		policy.FailureThreshold = 3
+	}
+	if policy.SuccessThreshold <= 0 {
+		policy.SuccessThreshold = 1
+	}
+	if policy.FlapWindow <= 1 || policy.FlapWindow > maxCheckHistory {
+		policy.FlapWindow = 10
+	}
+	if policy.FlapThreshold <= 0 {
+		policy.FlapThreshold = 4
+	}
+	if policy.StaleSeconds <= 0 {
+		policy.StaleSeconds = defaultStaleSeconds
+	}
+	return policy
+}
+
+// Healthx keeps the latest check results of an app, so thresholds survive restarts.
+type Healthx struct {
+	ConsecutiveFailures  int            `json:"consecutive_failures"`
+	ConsecutiveSuccesses int            `json:"consecutive_successes"`
+	History              []CheckResultx `json:"history"` // oldest first, at most maxCheckHistory
+	Conditions           []Conditionx   `json:"conditions"`
+	LastReportTime       string         `json:"last_report_time"` // 2006-01-02 15:04:05
+}
+
+type CheckResultx struct {
+	Time    string `json:"time"` // 2006-01-02 15:04:05
+	Healthy bool   `json:"healthy"`
+	Msg     string `json:"msg"`
+}
+
+type Conditionx struct {
+	Type               string `json:"type"`   // Flapping
+	Status             string `json:"status"` // True / False
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+	LastTransitionTime string `json:"last_transition_time"`
+}
+
+// RecordCheck adds a check result of the time to the app's health, and return the status the results
+// stand for: Running if enough checks passed in a row, Failed if enough checks failed in a row,
+// "" if it's undecided yet. A result older than the stale seconds, eg. replayed by an agent after
+// the apiserver was unreachable, is only recorded and never decides the status. It doesn't save the app.
+func (a *GenericApplication) RecordCheck(healthy bool, msg string, at time.Time, ctx iris.Context) ApplicationStatus {
+	policy := a.App.HealthPolicy.withDefaults()
+	h := &a.Health
+
+	h.History = append(h.History, CheckResultx{
+		Time:    at.Local().Format("2006-01-02 15:04:05"),
+		Healthy: healthy,
+		Msg:     msg,
+	})
+	if len(h.History) > maxCheckHistory {
+		h.History = h.History[len(h.History)-maxCheckHistory:]
+	}
+
+	if healthy {
+		h.ConsecutiveSuccesses++
+		h.ConsecutiveFailures = 0
+	} else {
+		h.ConsecutiveFailures++
+		h.ConsecutiveSuccesses = 0
+	}
+
+	flapping, changes := isFlapping(h.History, policy)
+	reason := "StatusStable"
+	if flapping {
+		reason = "StatusFlapping"
+	}
+	if a.setCondition(ConditionFlapping, flapping, reason,
+		fmt.Sprintf("%d status changes in the latest %d checks", changes, policy.FlapWindow)) {
+		ctx.Application().Logger().Warnf("App <%s> flapping condition changed to <%v>", a.Name, flapping)
+	}
+
+	switch {
+	case time.Since(at) > a.staleAfter():
+		return ""
+	case h.ConsecutiveFailures >= policy.FailureThreshold:
+		return Failed
+	case h.ConsecutiveSuccesses >= policy.SuccessThreshold:
+		return Running
+	}
+	return ""
+}
+
+// isFlapping counts healthy <-> unhealthy changes in the latest window of history
+func isFlapping(history []CheckResultx, policy HealthPolicyx) (bool, int) {
+	if len(history) > policy.FlapWindow {
+		history = history[len(history)-policy.FlapWindow:]
+	}
+	changes := 0
+	for i := 1; i < len(history); i++ {
+		if history[i].Healthy != history[i-1].Healthy {
+			changes++
+		}
+	}
+	return changes >= policy.FlapThreshold, changes
+}
+
+func (a *GenericApplication) GetConditions() []Conditionx {
+	return a.Health.Conditions
+}
+
+// GetCondition return the condition of the type, nil if the app hasn't it
+func (a *GenericApplication) GetCondition(conditionType string) *Conditionx {
+	return findCondition(a.Health.Conditions, conditionType)
+}
+
+// setCondition sets the condition of the type, and return true if its status changed
+func (a *GenericApplication) setCondition(conditionType string, status bool, reason, message string) bool {
+	var changed bool
+	a.Health.Conditions, changed = setCondition(a.Health.Conditions, conditionType, status, reason, message)
+	return changed
+}
+
+// findCondition return the condition of the type in conditions, nil if there isn't
+func findCondition(conditions []Conditionx, conditionType string) *Conditionx {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition sets the condition of the type in conditions, and return them and whether its status changed
+func setCondition(conditions []Conditionx, conditionType string, status bool, reason, message string) ([]Conditionx, bool) {
+	s := ConditionFalse
+	if status {
+		s = ConditionTrue
+	}
+
+	c := findCondition(conditions, conditionType)
+	if c == nil {
+		// a false condition never had needn't be recorded
+		if !status {
+			return conditions, false
+		}
+		conditions = append(conditions, Conditionx{Type: conditionType})
+		c = &conditions[len(conditions)-1]
+	}
+
+	changed := c.Status != s
+	if changed {
+		c.LastTransitionTime = time.Now().Format("2006-01-02 15:04:05")
+	}
+	c.Status = s
+	c.Reason = reason
+	c.Message = message
+	return conditions, changed
+}
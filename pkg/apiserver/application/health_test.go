@@ -0,0 +1,92 @@
+package application
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+)
+
+func TestRecordCheckThresholds(t *testing.T) {
+	ctx := context.NewContext(iris.New())
+	app := &GenericApplication{Name: "mysql"}
+	app.App.HealthPolicy = &HealthPolicyx{FailureThreshold: 2, SuccessThreshold: 2}
+
+	steps := []struct {
+		healthy bool
+		expect  ApplicationStatus
+	}{
+		{true, ""},
+		{true, Running},
+		{false, ""}, // one slow check isn't a failure
+		{false, Failed},
+		{true, ""},
+		{true, Running},
+	}
+	for i, step := range steps {
+		if verdict := app.RecordCheck(step.healthy, "", time.Now(), ctx); verdict != step.expect {
+			t.Errorf("step %d: expect <%s>, got <%s>", i, step.expect, verdict)
+		}
+	}
+}
+
+func TestRecordCheckFlapping(t *testing.T) {
+	ctx := context.NewContext(iris.New())
+	app := &GenericApplication{Name: "mysql"}
+	app.App.HealthPolicy = &HealthPolicyx{FlapWindow: 6, FlapThreshold: 3}
+
+	for i := 0; i < 4; i++ {
+		app.RecordCheck(i%2 == 0, "", time.Now(), ctx)
+	}
+	if c := app.GetCondition(ConditionFlapping); c == nil || c.Status != ConditionTrue {
+		t.Fatalf("expect flapping, got %+v", c)
+	}
+
+	for i := 0; i < 6; i++ {
+		app.RecordCheck(true, "", time.Now(), ctx)
+	}
+	if c := app.GetCondition(ConditionFlapping); c == nil || c.Status != ConditionFalse {
+		t.Fatalf("expect not flapping, got %+v", c)
+	}
+}
+
+func TestCheckHistoryBounded(t *testing.T) {
+	ctx := context.NewContext(iris.New())
+	app := &GenericApplication{Name: "mysql"}
+	for i := 0; i < maxCheckHistory+10; i++ {
+		app.RecordCheck(true, "", time.Now(), ctx)
+	}
+	if len(app.Health.History) != maxCheckHistory {
+		t.Errorf("expect %d results kept, got %d", maxCheckHistory, len(app.Health.History))
+	}
+}
+
+func TestStaleAfter(t *testing.T) {
+	app := &GenericApplication{Name: "mysql"}
+	if got := app.staleAfter(); got != time.Duration(defaultStaleSeconds)*time.Second {
+		t.Errorf("expect default stale period, got %s", got)
+	}
+	app.App.HealthPolicy = &HealthPolicyx{StaleSeconds: 30}
+	if got := app.staleAfter(); got != 30*time.Second {
+		t.Errorf("expect 30s, got %s", got)
+	}
+}
+
+func TestRecordCheckLateReports(t *testing.T) {
+	ctx := context.NewContext(iris.New())
+	app := &GenericApplication{Name: "mysql"}
+	app.App.HealthPolicy = &HealthPolicyx{FailureThreshold: 1, StaleSeconds: 60}
+
+	// failures replayed after an outage are kept in the history, but don't fail the app
+	ran := time.Now().Add(-time.Hour)
+	if verdict := app.RecordCheck(false, "", ran, ctx); verdict != "" {
+		t.Errorf("expect a stale report decides nothing, got <%s>", verdict)
+	}
+	if got := app.Health.History[0].Time; got != ran.Format("2006-01-02 15:04:05") {
+		t.Errorf("expect the time the check ran recorded, got <%s>", got)
+	}
+	if verdict := app.RecordCheck(false, "", time.Now().Add(-time.Second), ctx); verdict != Failed {
+		t.Errorf("expect a recent report decides the status, got <%s>", verdict)
+	}
+}
@@ -0,0 +1,167 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+// nodePrefix holds all nodes, eg. key=/paas-operator/nodes/192.168.19.101
+var nodePrefix = os.Getenv("ETCD_NODE_PREFIX")
+
+// nodeGracePeriod is how long a node may go without heartbeats before it's not ready
+const nodeGracePeriod = 40 * time.Second
+
+const (
+	// ConditionReady is true if the agent on the node sends heartbeats
+	ConditionReady = "Ready"
+)
+
+func init() {
+	if nodePrefix == "" {
+		nodePrefix = "/paas-operator/nodes"
+		log.Printf("Warning: %s is unset, use default value: %s", "ETCD_NODE_PREFIX", nodePrefix)
+	}
+}
+
+// Node is a host with an agent, it's created by the first heartbeat of the agent.
+type Node struct {
+	agent.NodeStatus
+	LastHeartbeatTime string       `json:"last_heartbeat_time"` // 2006-01-02 15:04:05
+	Conditions        []Conditionx `json:"conditions"`
+	// Facts are gathered by the agent periodically, nil before the first report
+	Facts *agent.Facts `json:"facts,omitempty"`
+}
+
+// Ready return true if the node has a true Ready condition
+func (n *Node) Ready() bool {
+	c := findCondition(n.Conditions, ConditionReady)
+	return c != nil && c.Status == ConditionTrue
+}
+
+// Heartbeat records a heartbeat with the agent's status, the node is ready then
+func (n *Node) Heartbeat(status *agent.NodeStatus, ctx iris.Context) {
+	n.NodeStatus = *status
+	n.LastHeartbeatTime = time.Now().Format("2006-01-02 15:04:05")
+
+	var changed bool
+	n.Conditions, changed = setCondition(n.Conditions, ConditionReady, true, "AgentHeartbeat",
+		fmt.Sprintf("agent %s is sending heartbeats", status.AgentVersion))
+	if changed {
+		ctx.Application().Logger().Infof("Node <%s> is ready", n.IP)
+	}
+}
+
+// stale return true if the node has no heartbeat in the grace period before now
+func (n *Node) stale(now time.Time) bool {
+	last, err := time.ParseInLocation("2006-01-02 15:04:05", n.LastHeartbeatTime, time.Local)
+	if err != nil {
+		return true
+	}
+	return now.Sub(last) > nodeGracePeriod
+}
+
+type ETCDNodes struct {
+	kapi   client.KeysAPI
+	prefix string
+}
+
+var etcdNodes = &ETCDNodes{}
+
+func GetETCDNodes() *ETCDNodes {
+	etcdNodes.kapi = globalKapi
+	etcdNodes.prefix = nodePrefix
+	return etcdNodes
+}
+
+func (nodes *ETCDNodes) key(ip string) string {
+	return fmt.Sprintf("%s/%s", nodes.prefix, ip)
+}
+
+// Save adds or updates the node
+func (nodes *ETCDNodes) Save(node *Node, ctx iris.Context) error {
+	nodeBytes, err := json.MarshalIndent(node, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = nodes.kapi.Set(context.Background(), nodes.key(node.IP), string(nodeBytes), nil)
+	if err != nil {
+		ctx.Application().Logger().Errorf("Save node <%s> to etcd failed. with error: <%s>", node.IP, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Get return the node with the ip, false if it isn't exist
+func (nodes *ETCDNodes) Get(ip string, ctx iris.Context) (*Node, bool) {
+	resp, err := nodes.kapi.Get(context.Background(), nodes.key(ip), nil)
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("Get node <%s> from etcd failed: <%s>", ip, err.Error())
+		}
+		return nil, false
+	}
+	var node = new(Node)
+	if err := json.Unmarshal([]byte(resp.Node.Value), node); err != nil {
+		ctx.Application().Logger().Errorf("Get node <%s>, json unmarshal failed: <%s>", ip, err.Error())
+		return nil, false
+	}
+	return node, true
+}
+
+// List return all nodes, nodes which can't be unmarshaled are skipped
+func (nodes *ETCDNodes) List(ctx iris.Context) []*Node {
+	resp, err := nodes.kapi.Get(context.Background(), nodes.prefix, &client.GetOptions{Sort: true})
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("List nodes from etcd failed: <%s>", err.Error())
+		}
+		return nil
+	}
+	var list = make([]*Node, 0, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		var node = new(Node)
+		if err := json.Unmarshal([]byte(n.Value), node); err != nil {
+			ctx.Application().Logger().Errorf("List node <%s>, json unmarshal failed: <%s>", n.Key, err.Error())
+			continue
+		}
+		list = append(list, node)
+	}
+	return list
+}
+
+// Delete removes the node, a node not exist isn't an error
+func (nodes *ETCDNodes) Delete(ip string, ctx iris.Context) error {
+	_, err := nodes.kapi.Delete(context.Background(), nodes.key(ip), nil)
+	if err != nil && !client.IsKeyNotFound(err) {
+		ctx.Application().Logger().Errorf("Delete node <%s> from etcd failed: <%s>", ip, err.Error())
+		return err
+	}
+	return nil
+}
+
+// WatchNodeHeartbeats marks nodes without heartbeats in the grace period not ready, it never returns.
+func WatchNodeHeartbeats(ctx iris.Context) {
+	for {
+		time.Sleep(nodeGracePeriod / 4)
+
+		now := time.Now()
+		for _, node := range GetETCDNodes().List(ctx) {
+			if !node.Ready() || !node.stale(now) {
+				continue
+			}
+			node.Conditions, _ = setCondition(node.Conditions, ConditionReady, false, "HeartbeatMissed",
+				fmt.Sprintf("no heartbeat since %s", node.LastHeartbeatTime))
+			ctx.Application().Logger().Warnf("Node <%s> is not ready, no heartbeat since %s", node.IP, node.LastHeartbeatTime)
+			GetETCDNodes().Save(node, ctx)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package application
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+func TestNodeHeartbeat(t *testing.T) {
+	ctx := context.NewContext(iris.New())
+	node := &Node{}
+	if node.Ready() {
+		t.Error("expect a new node not ready")
+	}
+
+	node.Heartbeat(&agent.NodeStatus{IP: "192.168.19.101", AgentVersion: "v0.1.0"}, ctx)
+	if !node.Ready() {
+		t.Error("expect a node ready after a heartbeat")
+	}
+	if node.stale(time.Now()) {
+		t.Error("expect a node with a heartbeat just now not stale")
+	}
+	if !node.stale(time.Now().Add(nodeGracePeriod + time.Second)) {
+		t.Error("expect a node without heartbeats in the grace period stale")
+	}
+}
@@ -0,0 +1,136 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+// agentReadyTimeout is how long a bootstrapped agent may take to answer /ping
+const agentReadyTimeout = 2 * time.Minute
+
+const (
+	// ConditionBootstrapped is true if the agent is bootstrapped on the node by onboarding or an install
+	ConditionBootstrapped = "Bootstrapped"
+)
+
+// agentTimeout is how long a call to the agent api other than actions may take
+const agentTimeout = 5 * time.Second
+
+func agentURL(host Hostx, path string) string {
+	return fmt.Sprintf("http://%s/%s", net.JoinHostPort(host.IP, host.agentPort()), path)
+}
+
+// PingAgent return nil if the agent on the host answers /ping
+func PingAgent(host Hostx, ctx iris.Context) error {
+	status, _, err := agentDo(host, "GET", "ping", nil, agentTimeout, ctx)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("ping agent on <%s> got %d %s", host.IP, status, http.StatusText(status))
+	}
+	return nil
+}
+
+// WaitAgentReady pings the agent on the host until it answers or timeout
+func WaitAgentReady(host Hostx, timeout time.Duration, ctx iris.Context) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := PingAgent(host, ctx)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("agent on <%s> isn't ready in %s: %s", host.IP, timeout, err)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// tellAgentNode tells the agent how to reach the apiserver, so it sends heartbeats before any action,
+// and the token of its tunnel. Agents in a zone reach it by the relay of the zone.
+func tellAgentNode(host Hostx, ctx iris.Context) error {
+	operatorIp, operatorPort, relayToken, err := operatorFor(host, ctx)
+	if err != nil {
+		return err
+	}
+	tunnelToken, err := tunnelTokenOf(host.IP)
+	if err != nil {
+		return err
+	}
+	infoBytes, err := json.Marshal(agent.NodeInfo{OperatorIp: operatorIp, OperatorPort: operatorPort, HostIP: host.IP, RelayToken: relayToken,
+		OperatorGRPCPort: operatorGRPCPortOf(operatorIp), TunnelToken: tunnelToken})
+	if err != nil {
+		return err
+	}
+	status, _, err := agentDo(host, "PUT", "node", infoBytes, agentTimeout, ctx)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("set node of agent on <%s> got %d %s", host.IP, status, http.StatusText(status))
+	}
+	return nil
+}
+
+// Bootstrapped return true if the agent was bootstrapped on the node
+func (n *Node) Bootstrapped() bool {
+	c := findCondition(n.Conditions, ConditionBootstrapped)
+	return c != nil && c.Status == ConditionTrue
+}
+
+// Onboard bootstraps the agent on the host over ssh, verifies it by /ping and records the node ready.
+// The node is saved with a false Bootstrapped condition telling the reason if it failed.
+func Onboard(host Hostx, ctx iris.Context) error {
+	node, ok := GetETCDNodes().Get(host.IP, ctx)
+	if !ok {
+		node = &Node{NodeStatus: agent.NodeStatus{IP: host.IP}}
+	}
+	fail := func(reason string, err error) error {
+		ctx.Application().Logger().Errorf("Onboard node <%s> failed: %s", host.IP, err)
+		node.Conditions, _ = setCondition(node.Conditions, ConditionBootstrapped, false, reason, err.Error())
+		GetETCDNodes().Save(node, ctx)
+		return err
+	}
+
+	if err := InitAgent(host, ctx); err != nil {
+		return fail("BootstrapFailed", err)
+	}
+	if err := WaitAgentReady(host, agentReadyTimeout, ctx); err != nil {
+		return fail("AgentNotResponding", err)
+	}
+	if err := tellAgentNode(host, ctx); err != nil {
+		return fail("AgentNotResponding", err)
+	}
+
+	// the heartbeats of the agent keep it ready from now on
+	node.LastHeartbeatTime = time.Now().Format("2006-01-02 15:04:05")
+	node.Conditions, _ = setCondition(node.Conditions, ConditionBootstrapped, true, "Onboarded", "agent is bootstrapped and answers ping")
+	node.Conditions, _ = setCondition(node.Conditions, ConditionReady, true, "AgentPing", "agent answers ping")
+	ctx.Application().Logger().Infof("Node <%s> is onboarded", host.IP)
+	return GetETCDNodes().Save(node, ctx)
+}
+
+// ensureAgent makes sure the agent on the host is running before an install. A ready node
+// bootstrapped before is used as it is, otherwise the host is onboarded over ssh.
+func ensureAgent(host Hostx, ctx iris.Context) error {
+	// an agent in tunnel mode is running while its tunnel is connected, even if it wasn't bootstrapped
+	if agentTunnel(host.IP) != nil && PingAgent(host, ctx) == nil {
+		ctx.Application().Logger().Infof("Node <%s> is connected by a tunnel, skip bootstrapping the agent", host.IP)
+		return nil
+	}
+	if node, ok := GetETCDNodes().Get(host.IP, ctx); ok && node.Bootstrapped() && node.Ready() {
+		if err := PingAgent(host, ctx); err == nil {
+			ctx.Application().Logger().Infof("Node <%s> is ready, skip bootstrapping the agent", host.IP)
+			return nil
+		}
+	}
+	return Onboard(host, ctx)
+}
@@ -0,0 +1,43 @@
+package application
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWaitAgentReady(t *testing.T) {
+	pings := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pings++
+		// the agent is starting at the first ping
+		if pings == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"message":"pong"}`))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	ip, port, _ := net.SplitHostPort(u.Host)
+	host := Hostx{IP: ip}
+	oldPort := AGENT_PORT
+	AGENT_PORT = port
+	defer func() { AGENT_PORT = oldPort }()
+
+	if err := PingAgent(host, nil); err == nil {
+		t.Error("expect the first ping failed")
+	}
+	if err := WaitAgentReady(host, 10*time.Second, nil); err != nil {
+		t.Error(err)
+	}
+
+	server.Close()
+	if err := WaitAgentReady(host, time.Second, nil); err == nil {
+		t.Error("expect a stopped agent not ready")
+	}
+}
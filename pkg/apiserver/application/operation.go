@@ -0,0 +1,169 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+// operationPrefix holds operations queued for pull mode agents,
+// eg. key=/paas-operator/operations/192.168.19.101/{operation_id}
+var operationPrefix = os.Getenv("ETCD_OPERATION_PREFIX")
+
+// maxPollWait is the longest time a poll of an agent is held
+const maxPollWait = 60 * time.Second
+
+type OperationState string
+
+const (
+	OperationPending OperationState = "pending" // queued, the agent hasn't polled it
+	OperationRunning OperationState = "running" // polled by the agent
+	OperationDone    OperationState = "done"    // the agent reported the result
+)
+
+func init() {
+	if operationPrefix == "" {
+		operationPrefix = "/paas-operator/operations"
+		log.Printf("Warning: %s is unset, use default value: %s", "ETCD_OPERATION_PREFIX", operationPrefix)
+	}
+}
+
+// Operation is an action queued for the pull mode agent on a host, it's removed when
+// the action returns to its caller.
+type Operation struct {
+	agent.Operation
+	HostIP       string                 `json:"host_ip"`
+	State        OperationState         `json:"state"`
+	CreateTime   string                 `json:"create_time"`   // 2006-01-02 15:04:05
+	DispatchTime string                 `json:"dispatch_time"` // when the agent polled it
+	Result       *agent.OperationResult `json:"result,omitempty"`
+}
+
+type ETCDOperations struct {
+	kapi   client.KeysAPI
+	prefix string
+}
+
+var etcdOperations = &ETCDOperations{}
+
+func GetETCDOperations() *ETCDOperations {
+	etcdOperations.kapi = globalKapi
+	etcdOperations.prefix = operationPrefix
+	return etcdOperations
+}
+
+func (ops *ETCDOperations) key(ip, id string) string {
+	return fmt.Sprintf("%s/%s/%s", ops.prefix, ip, id)
+}
+
+// Save adds or updates the operation
+func (ops *ETCDOperations) Save(op *Operation, ctx iris.Context) error {
+	opBytes, err := json.MarshalIndent(op, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = ops.kapi.Set(context.Background(), ops.key(op.HostIP, op.ID), string(opBytes), nil)
+	if err != nil {
+		ctx.Application().Logger().Errorf("Save operation <%s> to etcd failed. with error: <%s>", op.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Get return the operation of the host, false if it isn't exist
+func (ops *ETCDOperations) Get(ip, id string, ctx iris.Context) (*Operation, bool) {
+	resp, err := ops.kapi.Get(context.Background(), ops.key(ip, id), nil)
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("Get operation <%s> from etcd failed: <%s>", id, err.Error())
+		}
+		return nil, false
+	}
+	var op = new(Operation)
+	if err := json.Unmarshal([]byte(resp.Node.Value), op); err != nil {
+		ctx.Application().Logger().Errorf("Get operation <%s>, json unmarshal failed: <%s>", id, err.Error())
+		return nil, false
+	}
+	return op, true
+}
+
+// List return all operations of the host in the order they were queued
+func (ops *ETCDOperations) List(ip string, ctx iris.Context) []*Operation {
+	resp, err := ops.kapi.Get(context.Background(), fmt.Sprintf("%s/%s", ops.prefix, ip), nil)
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("List operations of <%s> from etcd failed: <%s>", ip, err.Error())
+		}
+		return nil
+	}
+	var list = make([]*Operation, 0, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		var op = new(Operation)
+		if err := json.Unmarshal([]byte(n.Value), op); err != nil {
+			ctx.Application().Logger().Errorf("List operation <%s>, json unmarshal failed: <%s>", n.Key, err.Error())
+			continue
+		}
+		list = append(list, op)
+	}
+	// keys are operation ids, the time tells the order
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CreateTime < list[j].CreateTime })
+	return list
+}
+
+// Delete removes the operation, an operation not exist isn't an error
+func (ops *ETCDOperations) Delete(ip, id string, ctx iris.Context) error {
+	_, err := ops.kapi.Delete(context.Background(), ops.key(ip, id), nil)
+	if err != nil && !client.IsKeyNotFound(err) {
+		ctx.Application().Logger().Errorf("Delete operation <%s> from etcd failed: <%s>", id, err.Error())
+		return err
+	}
+	return nil
+}
+
+// PollOperations return the pending operations of the host and marks them running.
+// If none is pending, it waits until one is queued or wait is over, then an empty list is returned.
+func PollOperations(ip string, wait time.Duration, ctx iris.Context) []agent.Operation {
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		var polled = []agent.Operation{}
+		for _, op := range GetETCDOperations().List(ip, ctx) {
+			if op.State != OperationPending {
+				continue
+			}
+			op.State = OperationRunning
+			op.DispatchTime = time.Now().Format("2006-01-02 15:04:05")
+			if err := GetETCDOperations().Save(op, ctx); err != nil {
+				continue
+			}
+			polled = append(polled, op.Operation)
+		}
+		if len(polled) > 0 || !time.Now().Before(deadline) {
+			return polled
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// FinishOperation records the result reported by the agent, false if the operation isn't exist,
+// eg. its caller gave up waiting.
+func FinishOperation(ip, id string, result *agent.OperationResult, ctx iris.Context) (bool, error) {
+	op, ok := GetETCDOperations().Get(ip, id, ctx)
+	if !ok {
+		return false, nil
+	}
+	op.State = OperationDone
+	op.Result = result
+	return true, GetETCDOperations().Save(op, ctx)
+}
@@ -0,0 +1,231 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kataras/iris"
+)
+
+// credentialPrefix holds all credentials, eg. key=/paas-operator/credentials/db-root
+var credentialPrefix = os.Getenv("ETCD_CREDENTIAL_PREFIX")
+
+func init() {
+	if credentialPrefix == "" {
+		credentialPrefix = "/paas-operator/credentials"
+		log.Printf("Warning: %s is unset, use default value: %s", "ETCD_CREDENTIAL_PREFIX", credentialPrefix)
+	}
+}
+
+// Profilex is how the apiserver connects to a host, a field left empty takes the default
+type Profilex struct {
+	// SSHPort is 22 by default
+	SSHPort string `json:"ssh_port,omitempty"`
+	// AgentPort is AGENT_PORT by default, the agent bootstrapped on the host listens on it
+	AgentPort string `json:"agent_port,omitempty"`
+	// AddressFamily is ipv4 or ipv6, any by default
+	AddressFamily string `json:"address_family,omitempty"`
+	// Credential is the name of a saved credential, it's used if the host has no auth
+	Credential string `json:"credential,omitempty"`
+}
+
+const (
+	AddressFamilyIPv4 = "ipv4"
+	AddressFamilyIPv6 = "ipv6"
+)
+
+func (h *Hostx) sshPort() string {
+	if h.Profile != nil && h.Profile.SSHPort != "" {
+		return h.Profile.SSHPort
+	}
+	return "22"
+}
+
+func (h *Hostx) agentPort() string {
+	if h.Profile != nil && h.Profile.AgentPort != "" {
+		return h.Profile.AgentPort
+	}
+	return AGENT_PORT
+}
+
+// network return the network to dial the host by: tcp, tcp4 or tcp6
+func (h *Hostx) network() string {
+	if h.Profile != nil {
+		switch h.Profile.AddressFamily {
+		case AddressFamilyIPv4:
+			return "tcp4"
+		case AddressFamilyIPv6:
+			return "tcp6"
+		}
+	}
+	return "tcp"
+}
+
+// Validate return an error if the profile can't be used to connect to the host
+func (p *Profilex) Validate(ip string) error {
+	for _, port := range []string{p.SSHPort, p.AgentPort} {
+		if port == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("port <%s> is illegal", port)
+		}
+	}
+	parsed := net.ParseIP(ip)
+	switch p.AddressFamily {
+	case "":
+	case AddressFamilyIPv4:
+		if parsed != nil && parsed.To4() == nil {
+			return fmt.Errorf("<%s> isn't an ipv4 address", ip)
+		}
+	case AddressFamilyIPv6:
+		if parsed != nil && parsed.To4() != nil {
+			return fmt.Errorf("<%s> isn't an ipv6 address", ip)
+		}
+	default:
+		return fmt.Errorf("address family <%s> is illegal, expect ipv4 or ipv6", p.AddressFamily)
+	}
+	return nil
+}
+
+// Credential is a saved auth of hosts, hosts refer to it by Profilex.Credential
+type Credential struct {
+	Name string `json:"name"`
+	// Auth is the same as the auth of a host, see hostCredentials
+	Auth []Authx `json:"auth"`
+}
+
+type ETCDCredentials struct {
+	kapi   client.KeysAPI
+	prefix string
+}
+
+var etcdCredentials = &ETCDCredentials{}
+
+func GetETCDCredentials() *ETCDCredentials {
+	etcdCredentials.kapi = globalKapi
+	etcdCredentials.prefix = credentialPrefix
+	return etcdCredentials
+}
+
+func (creds *ETCDCredentials) key(name string) string {
+	return fmt.Sprintf("%s/%s", creds.prefix, name)
+}
+
+// Save adds or updates the credential
+func (creds *ETCDCredentials) Save(cred *Credential, ctx iris.Context) error {
+	credBytes, err := json.MarshalIndent(cred, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = creds.kapi.Set(context.Background(), creds.key(cred.Name), string(credBytes), nil)
+	if err != nil {
+		ctx.Application().Logger().Errorf("Save credential <%s> to etcd failed. with error: <%s>", cred.Name, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Get return the credential with the name, false if it isn't exist
+func (creds *ETCDCredentials) Get(name string, ctx iris.Context) (*Credential, bool) {
+	resp, err := creds.kapi.Get(context.Background(), creds.key(name), nil)
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("Get credential <%s> from etcd failed: <%s>", name, err.Error())
+		}
+		return nil, false
+	}
+	var cred = new(Credential)
+	if err := json.Unmarshal([]byte(resp.Node.Value), cred); err != nil {
+		ctx.Application().Logger().Errorf("Get credential <%s>, json unmarshal failed: <%s>", name, err.Error())
+		return nil, false
+	}
+	return cred, true
+}
+
+// List return all credentials, credentials which can't be unmarshaled are skipped
+func (creds *ETCDCredentials) List(ctx iris.Context) []*Credential {
+	resp, err := creds.kapi.Get(context.Background(), creds.prefix, &client.GetOptions{Sort: true})
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("List credentials from etcd failed: <%s>", err.Error())
+		}
+		return nil
+	}
+	var list = make([]*Credential, 0, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		var cred = new(Credential)
+		if err := json.Unmarshal([]byte(n.Value), cred); err != nil {
+			ctx.Application().Logger().Errorf("List credential <%s>, json unmarshal failed: <%s>", n.Key, err.Error())
+			continue
+		}
+		list = append(list, cred)
+	}
+	return list
+}
+
+// Delete removes the credential, a credential not exist isn't an error
+func (creds *ETCDCredentials) Delete(name string, ctx iris.Context) error {
+	_, err := creds.kapi.Delete(context.Background(), creds.key(name), nil)
+	if err != nil && !client.IsKeyNotFound(err) {
+		ctx.Application().Logger().Errorf("Delete credential <%s> from etcd failed: <%s>", name, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Validate return an error if the credential can't be used
+func (c *Credential) Validate() error {
+	if c.Name == "" || strings.Contains(c.Name, "/") {
+		return fmt.Errorf("credential name <%s> is illegal", c.Name)
+	}
+	if len(c.Auth) == 0 {
+		return fmt.Errorf("credential <%s> has no auth", c.Name)
+	}
+	return nil
+}
+
+// hostAuth return the auth of the host, the one of its saved credential if it has no auth
+func hostAuth(host Hostx, ctx iris.Context) ([]Authx, error) {
+	if len(host.Auth) > 0 || host.Profile == nil || host.Profile.Credential == "" {
+		return host.Auth, nil
+	}
+	cred, ok := GetETCDCredentials().Get(host.Profile.Credential, ctx)
+	if !ok {
+		return nil, fmt.Errorf("credential <%s> of host <%s> is not exist", host.Profile.Credential, host.IP)
+	}
+	return cred.Auth, nil
+}
+
+// ValidateHosts return an error if a host of the app can't be connected to, see ValidateHost
+func ValidateHosts(app Application, ctx iris.Context) error {
+	for _, host := range app.GetHosts() {
+		if err := ValidateHost(host, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateHost return an error if the host can't be connected to by its profile, zone and jump hosts
+func ValidateHost(host Hostx, ctx iris.Context) error {
+	if host.Profile != nil {
+		if err := host.Profile.Validate(host.IP); err != nil {
+			return err
+		}
+		if _, err := hostAuth(host, ctx); err != nil {
+			return err
+		}
+	}
+	if _, err := zoneOf(host, ctx); err != nil {
+		return err
+	}
+	return validateJumps(host.Jumps)
+}
@@ -0,0 +1,30 @@
+package application
+
+import "testing"
+
+func TestHostProfile(t *testing.T) {
+	host := Hostx{IP: "fd00::8", Profile: &Profilex{SSHPort: "2222", AgentPort: "13335", AddressFamily: AddressFamilyIPv6}}
+	if err := host.Profile.Validate(host.IP); err != nil {
+		t.Fatal(err)
+	}
+	if got := agentURL(host, "ping"); got != "http://[fd00::8]:13335/ping" {
+		t.Errorf("unexpected agent url %s", got)
+	}
+	sshCli, err := sshClientOf(host, "root", "xxx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sshCli.Port != "2222" || sshCli.Network != "tcp6" {
+		t.Errorf("expect ssh by tcp6 on 2222, got %s on %s", sshCli.Network, sshCli.Port)
+	}
+
+	if (&Profilex{AddressFamily: AddressFamilyIPv4}).Validate(host.IP) == nil {
+		t.Error("expect an ipv6 address refused by ipv4")
+	}
+	if (&Profilex{SSHPort: "70000"}).Validate(host.IP) == nil {
+		t.Error("expect an illegal port refused")
+	}
+	if port := (&Hostx{IP: "192.168.19.101"}).agentPort(); port != AGENT_PORT {
+		t.Errorf("expect AGENT_PORT by default, got %s", port)
+	}
+}
@@ -0,0 +1,73 @@
+package application
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+)
+
+// pullOperationTimeout is how long an action waits for the pull mode agent to report its result
+const pullOperationTimeout = 30 * time.Minute
+
+// pullExecutor queues actions for a pull mode agent, for hosts the apiserver can't reach.
+// The agent polls them by GET /nodes/{ip}/operations and reports results by PUT /nodes/{ip}/operations/{id}.
+type pullExecutor struct{}
+
+// Prepare requires a ready node whose agent is in pull mode, the apiserver can't bootstrap it
+func (e *pullExecutor) Prepare(host Hostx, ctx iris.Context) error {
+	node, ok := GetETCDNodes().Get(host.IP, ctx)
+	if !ok || !node.Ready() {
+		return fmt.Errorf("node <%s> isn't ready, an agent in pull mode must be running on it", host.IP)
+	}
+	if node.Mode != agent.ModePull {
+		return fmt.Errorf("agent on node <%s> isn't in pull mode", host.IP)
+	}
+	return nil
+}
+
+func (e *pullExecutor) Execute(action ApplicationAction, appInfo *agent.AppInfo, app *GenericApplication, ctx iris.Context) error {
+	ip := app.GetHosts()[0].IP
+	// the pull mode agent reports its capabilities by heartbeats
+	if node, ok := GetETCDNodes().Get(ip, ctx); ok {
+		if err := missingCapabilities(ip, node.AgentVersion, node.Capabilities, requiredCapabilities(appInfo)); err != nil {
+			return err
+		}
+	}
+	op := &Operation{
+		Operation:  agent.Operation{ID: appInfo.OperationID, Action: agent.Action(action), AppInfo: *appInfo},
+		HostIP:     ip,
+		State:      OperationPending,
+		CreateTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	// a retried action has the same operation id, it's queued again
+	if err := GetETCDOperations().Save(op, ctx); err != nil {
+		return err
+	}
+	defer GetETCDOperations().Delete(ip, op.ID, ctx)
+	ctx.Application().Logger().Infof("Queue operation <%s> of <%s> for node <%s>", op.ID, action, ip)
+
+	deadline := time.Now().Add(pullOperationTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+		done, ok := GetETCDOperations().Get(ip, op.ID, ctx)
+		if !ok {
+			return fmt.Errorf("operation <%s> is gone from the queue of node <%s>", op.ID, ip)
+		}
+		if done.State != OperationDone || done.Result == nil {
+			continue
+		}
+		if done.Result.Error == "" {
+			return nil
+		}
+		return &ActionError{
+			Action:   action,
+			Result:   done.Result.Result,
+			ExitCode: done.Result.ExitCode,
+			Msg:      done.Result.Error,
+		}
+	}
+	return fmt.Errorf("operation <%s> of <%s> isn't done by node <%s> in %s", op.ID, action, ip, pullOperationTimeout)
+}
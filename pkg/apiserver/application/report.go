@@ -0,0 +1,127 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kataras/iris"
+)
+
+// reportPrefix holds a ttl key for every app which is refreshed by each check report,
+// eg. key=/paas-operator/reports/database/mysql-xxx value="2019-06-28 10:33:55".
+// The key expires when the reports of the app go stale.
+var reportPrefix = os.Getenv("ETCD_REPORT_PREFIX")
+
+func init() {
+	if reportPrefix == "" {
+		reportPrefix = "/paas-operator/reports"
+	}
+}
+
+func reportKey(appType AppType, name string) string {
+	return fmt.Sprintf("%s/%s/%s", reportPrefix, appType, name)
+}
+
+// staleAfter return how long the app may go without check reports before it's unknown
+func (a *GenericApplication) staleAfter() time.Duration {
+	return time.Duration(a.App.HealthPolicy.withDefaults().StaleSeconds) * time.Second
+}
+
+// Reported records that the app is reported just now, the record expires after
+// the app's stale seconds. It doesn't save the app.
+func (a *GenericApplication) Reported(ctx iris.Context) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	a.Health.LastReportTime = now
+
+	_, err := globalKapi.Set(context.Background(), reportKey(AppType(a.Type), a.Name), now,
+		&client.SetOptions{TTL: a.staleAfter()})
+	if err != nil {
+		ctx.Application().Logger().Errorf("Refresh report of <%s> failed: %s", a.Name, err)
+	}
+}
+
+// ForgetReport removes the report record of a deleted app
+func ForgetReport(appType AppType, name string, ctx iris.Context) {
+	_, err := globalKapi.Delete(context.Background(), reportKey(appType, name), nil)
+	if err != nil && !client.IsKeyNotFound(err) {
+		ctx.Application().Logger().Errorf("Forget report of <%s> failed: %s", name, err)
+	}
+}
+
+// WatchStaleReports marks an app unknown when its report record expires, it never returns.
+// Apps whose records expired while the apiserver was down are found by a sweep at first,
+// the sweep waits a stale period so that running agents can report once.
+func WatchStaleReports(ctx iris.Context) {
+	time.Sleep(time.Duration(defaultStaleSeconds) * time.Second)
+	for {
+		index := sweepStaleReports(ctx)
+
+		w := globalKapi.Watcher(reportPrefix, &client.WatcherOptions{AfterIndex: index, Recursive: true})
+		for {
+			resp, err := w.Next(context.Background())
+			if err != nil {
+				ctx.Application().Logger().Errorf("Watch reports failed: %s", err)
+				time.Sleep(5 * time.Second)
+				// the index may be cleared, sweep again and watch from a new index
+				break
+			}
+			if resp.Action != "expire" {
+				continue
+			}
+			// key: /paas-operator/reports/<type>/<name>
+			keySplit := strings.Split(strings.TrimPrefix(resp.Node.Key, reportPrefix+"/"), "/")
+			if len(keySplit) != 2 {
+				continue
+			}
+			markUnknown(AppType(keySplit[0]), keySplit[1], ctx)
+		}
+	}
+}
+
+// sweepStaleReports marks all apps without a report record unknown, and return the etcd index to watch after.
+func sweepStaleReports(ctx iris.Context) uint64 {
+	var index uint64
+	reported := map[string]struct{}{}
+	resp, err := globalKapi.Get(context.Background(), reportPrefix, &client.GetOptions{Recursive: true})
+	if err == nil {
+		index = resp.Index
+		for _, typeNode := range resp.Node.Nodes {
+			for _, node := range typeNode.Nodes {
+				reported[node.Key] = struct{}{}
+			}
+		}
+	} else if !client.IsKeyNotFound(err) {
+		ctx.Application().Logger().Errorf("Get reports failed: %s", err)
+		return 0
+	} else if etcdErr, ok := err.(client.Error); ok {
+		index = etcdErr.Index
+	}
+
+	for _, appType := range []AppType{APP_DATABASE, APP_MIDDLEWARE} {
+		for _, app := range GetETCDApplications(appType).List(ctx) {
+			if _, ok := reported[reportKey(appType, app.GetName())]; !ok {
+				markUnknown(appType, app.GetName(), ctx)
+			}
+		}
+	}
+	return index
+}
+
+// markUnknown sets the app unknown if it's expected running and was checked before
+func markUnknown(appType AppType, name string, ctx iris.Context) {
+	app, ok := GetETCDApplications(appType).Get(name, ctx)
+	if !ok {
+		return
+	}
+	status := app.GetStatus()
+	if status.Expect != Running || (status.Realtime != Running && status.Realtime != Failed) {
+		return
+	}
+	ctx.Application().Logger().Warnf("Check reports of <%s> went stale, set it unknown", name)
+	// not restarted, the agent which would restart it is unreachable
+	app.SetStatus(ApplicationStatus(""), Unknown, ctx)
+}
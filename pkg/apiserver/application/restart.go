@@ -0,0 +1,172 @@
+package application
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris"
+)
+
+type RestartPolicyType string
+
+const (
+	RestartNever     RestartPolicyType = "never"      // a failed app waits for a human
+	RestartOnFailure RestartPolicyType = "on-failure" // restart a failed app
+	RestartAlways    RestartPolicyType = "always"     // start an app expected running whenever it isn't, failed, stopped or unknown
+)
+
+// RestartPolicyx decides whether and when the apiserver restarts an app expected running.
+type RestartPolicyx struct {
+	Policy RestartPolicyType `json:"policy"` // never by default
+	// attempts before giving up, 3 by default, -1 means no limit
+	MaxAttempts int `json:"max_attempts"`
+	// delay before the first attempt, doubled by each attempt, 10 by default
+	BackoffSeconds int `json:"backoff_seconds"`
+	// max delay between attempts, 300 by default
+	MaxBackoffSeconds int `json:"max_backoff_seconds"`
+	// attempts are counted from 0 again if the app has no attempt in this window, 600 by default
+	ResetSeconds int `json:"reset_seconds"`
+}
+
+func (p *RestartPolicyx) withDefaults() RestartPolicyx {
+	var policy RestartPolicyx
+	if p != nil {
+		policy = *p
+	}
+	if policy.Policy == "" {
+		policy.Policy = RestartNever
+	}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = 3
+	}
+	if policy.BackoffSeconds <= 0 {
+		policy.BackoffSeconds = 10
+	}
+	if policy.MaxBackoffSeconds < policy.BackoffSeconds {
+		policy.MaxBackoffSeconds = 300
+		if policy.MaxBackoffSeconds < policy.BackoffSeconds {
+			policy.MaxBackoffSeconds = policy.BackoffSeconds
+		}
+	}
+	if policy.ResetSeconds <= 0 {
+		policy.ResetSeconds = 600
+	}
+	return policy
+}
+
+// Validate return an error if the policy type is illegal
+func (p *RestartPolicyx) Validate() error {
+	switch p.Policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return nil
+	}
+	return fmt.Errorf("restart policy <%s> is illegal, expect never, on-failure or always", p.Policy)
+}
+
+// restartOn return the action to bring the app back from realtime, "" if the policy doesn't restart it.
+// An unknown app isn't restarted, the restart would go to the same unreachable agent.
+func (p *RestartPolicyx) restartOn(realtime ApplicationStatus) ApplicationAction {
+	switch {
+	case p.Policy == RestartNever:
+		return ""
+	case realtime == Failed:
+		return ARestart
+	case p.Policy == RestartAlways && realtime == Stopped:
+		return AStart
+	}
+	return ""
+}
+
+// backoff return the delay before the attempt, attempts before it are given
+func (p *RestartPolicyx) backoff(attempts int) time.Duration {
+	delay := p.BackoffSeconds
+	for i := 0; i < attempts && delay < p.MaxBackoffSeconds; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoffSeconds {
+		delay = p.MaxBackoffSeconds
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// Restartsx counts the automatic restarts of an app
+type Restartsx struct {
+	Attempts        int    `json:"attempts"`
+	LastAttemptTime string `json:"last_attempt_time"` // 2006-01-02 15:04:05
+}
+
+// pendingRestarts holds the apps with a restart waiting for its backoff, key: type/name
+var pendingRestarts sync.Map
+
+// AutoRestart schedules a restart of the app if its restart policy asks for it,
+// the restart runs through UpdateStatus after the backoff.
+func (a *GenericApplication) AutoRestart(ctx iris.Context) {
+	policy := a.App.RestartPolicy.withDefaults()
+	if a.App.Status.Expect != Running || policy.restartOn(a.App.Status.Realtime) == "" {
+		return
+	}
+
+	key := a.Type + "/" + a.Name
+	if _, loaded := pendingRestarts.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	r := &a.Restarts
+	if last, err := time.ParseInLocation("2006-01-02 15:04:05", r.LastAttemptTime, time.Local); err == nil &&
+		time.Since(last) > time.Duration(policy.ResetSeconds)*time.Second {
+		r.Attempts = 0
+	}
+	if policy.MaxAttempts > 0 && r.Attempts >= policy.MaxAttempts {
+		pendingRestarts.Delete(key)
+		ctx.Application().Logger().Warnf("App <%s> isn't restarted, it has been restarted %d times", a.Name, r.Attempts)
+		a.AddEvent(map[string]string{
+			"reason":  "RestartGaveUp",
+			"message": fmt.Sprintf("%s after %d restart attempts", a.App.Status.Realtime, r.Attempts),
+		}, ctx)
+		return
+	}
+
+	delay := policy.backoff(r.Attempts)
+	r.Attempts++
+	r.LastAttemptTime = time.Now().Add(delay).Format("2006-01-02 15:04:05")
+	ctx.Application().Logger().Infof("App <%s> is %s, restart it after %s, attempt %d",
+		a.Name, a.App.Status.Realtime, delay, r.Attempts)
+	a.AddEvent(map[string]string{
+		"reason":  "RestartScheduled",
+		"message": fmt.Sprintf("%s, restart attempt %d after %s", a.App.Status.Realtime, r.Attempts, delay),
+	}, ctx)
+
+	appType, name := AppType(a.Type), a.Name
+	go func() {
+		time.Sleep(delay)
+		// a failed attempt schedules the next one in UpdateStatus
+		pendingRestarts.Delete(key)
+
+		// the app may be changed by humans or checks during the backoff
+		app, ok := GetETCDApplications(appType).Get(name, ctx)
+		if !ok {
+			return
+		}
+		current, ok := app.(*GenericApplication)
+		if !ok || current.App.Status.Expect != Running {
+			return
+		}
+		policy := current.App.RestartPolicy.withDefaults()
+		action := policy.restartOn(current.App.Status.Realtime)
+		if action == "" {
+			return
+		}
+
+		if action == AStart {
+			current.App.Status.Realtime = Starting
+		} else {
+			current.App.Status.Realtime = Restarting
+		}
+		current.appendEvent(map[string]string{
+			"reason":  "AutoRestart",
+			"message": fmt.Sprintf("%s the app, attempt %d", action, current.Restarts.Attempts),
+		})
+		current.UpdateStatus(action, ctx)
+	}()
+}
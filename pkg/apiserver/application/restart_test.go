@@ -0,0 +1,53 @@
+package application
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	policy := (&RestartPolicyx{Policy: RestartOnFailure, BackoffSeconds: 10, MaxBackoffSeconds: 60}).withDefaults()
+	expects := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 60 * time.Second, 60 * time.Second}
+	for attempts, expect := range expects {
+		if got := policy.backoff(attempts); got != expect {
+			t.Errorf("attempts %d: expect %s, got %s", attempts, expect, got)
+		}
+	}
+}
+
+func TestRestartOn(t *testing.T) {
+	cases := []struct {
+		policy   RestartPolicyType
+		realtime ApplicationStatus
+		expect   ApplicationAction
+	}{
+		{"", Failed, ""},
+		{RestartNever, Failed, ""},
+		{RestartOnFailure, Failed, ARestart},
+		{RestartOnFailure, Stopped, ""},
+		{RestartOnFailure, Unknown, ""},
+		{RestartAlways, Failed, ARestart},
+		{RestartAlways, Stopped, AStart},
+		{RestartAlways, Unknown, ""},
+		{RestartAlways, Running, ""},
+	}
+	for _, c := range cases {
+		policy := (&RestartPolicyx{Policy: c.policy}).withDefaults()
+		if got := policy.restartOn(c.realtime); got != c.expect {
+			t.Errorf("policy <%s> realtime <%s>: expect <%s>, got <%s>", c.policy, c.realtime, c.expect, got)
+		}
+	}
+}
+
+func TestAppendEventBounded(t *testing.T) {
+	app := &GenericApplication{Name: "mysql"}
+	for i := 0; i < maxEvents+10; i++ {
+		app.appendEvent(map[string]string{"reason": "Test"})
+	}
+	if len(app.GetEvents()) != maxEvents {
+		t.Errorf("expect %d events, got %d", maxEvents, len(app.GetEvents()))
+	}
+	if app.GetEvents()[0]["time"] == "" {
+		t.Error("expect events stamped with time")
+	}
+}
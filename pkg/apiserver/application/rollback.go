@@ -0,0 +1,105 @@
+package application
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kataras/iris"
+)
+
+type InstallFailureAction string
+
+const (
+	InstallFailureLeave     InstallFailureAction = "leave"     // keep the failed install on the host for debugging
+	InstallFailureUninstall InstallFailureAction = "uninstall" // roll back by the uninstall script
+	InstallFailureRetry     InstallFailureAction = "retry"     // roll back and install again, roll back at last if all failed
+)
+
+// InstallFailurePolicyx decides what the apiserver does after install.sh failed.
+type InstallFailurePolicyx struct {
+	Action InstallFailureAction `json:"action"` // leave by default
+	// installs after the first one failed, only for retry, 1 by default
+	Retries int `json:"retries"`
+	// delay before each retry, 10 by default
+	RetryDelaySeconds int `json:"retry_delay_seconds"`
+}
+
+func (p *InstallFailurePolicyx) withDefaults() InstallFailurePolicyx {
+	var policy InstallFailurePolicyx
+	if p != nil {
+		policy = *p
+	}
+	if policy.Action == "" {
+		policy.Action = InstallFailureLeave
+	}
+	if policy.Action != InstallFailureRetry {
+		policy.Retries = 0
+	} else if policy.Retries <= 0 {
+		policy.Retries = 1
+	}
+	if policy.RetryDelaySeconds <= 0 {
+		policy.RetryDelaySeconds = 10
+	}
+	return policy
+}
+
+// Validate return an error if the policy action is illegal
+func (p *InstallFailurePolicyx) Validate() error {
+	switch p.Action {
+	case "", InstallFailureLeave, InstallFailureUninstall, InstallFailureRetry:
+		return nil
+	}
+	return fmt.Errorf("install failure action <%s> is illegal, expect leave, uninstall or retry", p.Action)
+}
+
+// install calls the agent to install the app and handles a failure by the install failure policy,
+// it return the realtime status of the app. An app rolled back cleanly is not-installed.
+func (a *GenericApplication) install(ctx iris.Context) ApplicationStatus {
+	policy := a.App.InstallFailurePolicy.withDefaults()
+
+	for attempt := 0; ; attempt++ {
+		err := CallToAgent(AInstall, a, ctx)
+		status := realtimeAfter(AInstall, err)
+		if status != Failed {
+			return status
+		}
+		a.appendEvent(map[string]string{
+			"reason":  "InstallFailed",
+			"message": fmt.Sprintf("install attempt %d failed: %v", attempt+1, err),
+		})
+		if policy.Action == InstallFailureLeave {
+			return Failed
+		}
+
+		if !a.rollback(ctx) {
+			// the host isn't clean, another install may make it worse
+			return Failed
+		}
+		if attempt >= policy.Retries {
+			return NotInstalled
+		}
+
+		delay := time.Duration(policy.RetryDelaySeconds) * time.Second
+		ctx.Application().Logger().Infof("Install <%s> again after %s, retry %d/%d", a.Name, delay, attempt+1, policy.Retries)
+		time.Sleep(delay)
+	}
+}
+
+// rollback calls the agent to uninstall the partially installed app, and return true if the host is clean.
+func (a *GenericApplication) rollback(ctx iris.Context) bool {
+	ctx.Application().Logger().Warnf("Install <%s> failed, roll back by uninstall", a.Name)
+	err := CallToAgent(AUninstall, a, ctx)
+	if realtimeAfter(AUninstall, err) != NotInstalled {
+		ctx.Application().Logger().Errorf("Roll back <%s> failed: %v", a.Name, err)
+		a.appendEvent(map[string]string{
+			"reason":  "RollbackFailed",
+			"message": fmt.Sprintf("uninstall after a failed install failed: %v", err),
+		})
+		return false
+	}
+	a.appendEvent(map[string]string{
+		"reason":  "RolledBack",
+		"message": "uninstalled after a failed install, the host is clean",
+	})
+	return true
+}
@@ -0,0 +1,26 @@
+package application
+
+import "testing"
+
+func TestInstallFailurePolicyDefaults(t *testing.T) {
+	cases := []struct {
+		policy  *InstallFailurePolicyx
+		action  InstallFailureAction
+		retries int
+	}{
+		{nil, InstallFailureLeave, 0},
+		{&InstallFailurePolicyx{Action: InstallFailureUninstall, Retries: 3}, InstallFailureUninstall, 0},
+		{&InstallFailurePolicyx{Action: InstallFailureRetry}, InstallFailureRetry, 1},
+		{&InstallFailurePolicyx{Action: InstallFailureRetry, Retries: 3}, InstallFailureRetry, 3},
+	}
+	for i, c := range cases {
+		policy := c.policy.withDefaults()
+		if policy.Action != c.action || policy.Retries != c.retries {
+			t.Errorf("case %d: expect <%s, %d>, got <%s, %d>", i, c.action, c.retries, policy.Action, policy.Retries)
+		}
+	}
+
+	if err := (&InstallFailurePolicyx{Action: "rollback"}).Validate(); err == nil {
+		t.Error("expect an error for an illegal action")
+	}
+}
@@ -0,0 +1,264 @@
+package application
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils/sshcli"
+)
+
+// sshCheckInterval is how often the check script of an app run over ssh is executed
+const sshCheckInterval = 15 * time.Second
+
+// sshScriptTimeout is how long a script run over ssh as the agent user may take, like an action of a pull mode agent
+const sshScriptTimeout = pullOperationTimeout
+
+// sshExecutor runs scripts on the host over ssh, for hosts which can't run an agent.
+// Scripts and the package are downloaded by the apiserver and uploaded to the same app dir
+// an agent uses, and run with the same environment.
+type sshExecutor struct{}
+
+// Prepare does nothing, the host is connected for every action
+func (e *sshExecutor) Prepare(host Hostx, ctx iris.Context) error {
+	return nil
+}
+
+func (e *sshExecutor) Execute(action ApplicationAction, appInfo *agent.AppInfo, app *GenericApplication, ctx iris.Context) error {
+	if appInfo.Bundle != nil || len(appInfo.Probes) > 0 {
+		return &ActionError{Action: action, Result: agent.ResultFailed, ExitCode: -1,
+			Msg: "bundles and probes need an agent, they aren't supported by the ssh executor"}
+	}
+
+	// the check script is run by the apiserver periodically, like the check loop of an agent
+	if action == ACheck {
+		startSSHCheck(AppType(app.Type), app.Name, ctx)
+		return nil
+	}
+
+	sshCli, become, err := dialHost(app.GetHosts()[0], ctx)
+	if err != nil {
+		return err
+	}
+	defer sshCli.Cli.Close()
+
+	out, err := runOverSSH(sshCli, become, agent.Action(action), appInfo)
+	ctx.Application().Logger().Infof("Run <%s> of <%s> over ssh get output: <%s>", action, app.Name, out)
+	if err != nil {
+		code := sshcli.ExitCode(err)
+		if code < 0 {
+			return err
+		}
+		return &ActionError{Action: action, Result: agent.ResultOfExitCode(code), ExitCode: code, Msg: out}
+	}
+
+	// an uninstalled app leaves nothing on the host, like an agent does
+	if action == AUninstall {
+		appDir, _ := appInfo.AppDir()
+		if _, err := sshCli.ExecAs("rm -rf "+sshcli.ShellQuote(filepath.Dir(appDir)), become); err != nil {
+			ctx.Application().Logger().Errorf("Clean dir of <%s> failed: %s", app.Name, err)
+		}
+	}
+	return nil
+}
+
+// dialHost connects to the host by its credentials, through the relay and the jump hosts of its zone if it's in one
+func dialHost(host Hostx, ctx iris.Context) (*sshcli.SSHClient, sshcli.Become, error) {
+	auth, err := hostAuth(host, ctx)
+	if err != nil {
+		return nil, sshcli.Become{}, err
+	}
+	sshUser, sshPasswd, become, err := hostCredentials(auth)
+	if err != nil {
+		return nil, become, err
+	}
+	sshCli, err := sshClientOf(host, sshUser, sshPasswd, ctx)
+	if err != nil {
+		return nil, become, err
+	}
+	if err := sshCli.ValidateConn(); err != nil {
+		return nil, become, err
+	}
+	return sshCli, become, nil
+}
+
+// runOverSSH uploads the script of the action (and the package for install) into the app dir
+// on the host, and runs it as the agent user with the script environment.
+func runOverSSH(sshCli *sshcli.SSHClient, become sshcli.Become, action agent.Action, appInfo *agent.AppInfo) (string, error) {
+	scriptName := appInfo.ScriptName(action)
+	if scriptName == "" {
+		return "", errors.New("script name illegal: " + scriptName)
+	}
+	appDir, err := appInfo.AppDir()
+	if err != nil {
+		return "", err
+	}
+
+	// files are staged in a new dir of the ssh user, then copied by the agent user
+	stageDir, err := makeStageDir(sshCli)
+	if err != nil {
+		return "", err
+	}
+	defer sshCli.ExecCmd("rm -rf " + sshcli.ShellQuote(stageDir))
+
+	files := []string{scriptName}
+	if action == agent.Install && appInfo.Package != "" {
+		files = append(files, appInfo.Package)
+	}
+	d := agent.NewDownloader(append([]string{appInfo.RepoURL}, appInfo.Mirrors...)...)
+	d.CacheDir = filepath.Join(WORK_DIR, "cache")
+	for _, name := range files {
+		local := filepath.Join(WORK_DIR, "ssh", appInfo.Type, appInfo.Name, name)
+		if _, err := d.Fetch(name, appInfo.Checksums[name], local); err != nil {
+			return "", err
+		}
+		if err := sshCli.UploadFile(local, stageDir+"/"+name); err != nil {
+			return "", err
+		}
+	}
+
+	cmd := fmt.Sprintf("mkdir -p %[1]s && cp %[2]s/%[3]s %[1]s/ && chmod +x %[1]s/%[3]s", sshcli.ShellQuote(appDir), sshcli.ShellQuote(stageDir), sshcli.ShellQuote(scriptName))
+	if len(files) > 1 {
+		cmd += fmt.Sprintf(" && cp %s/%s %s/", sshcli.ShellQuote(stageDir), sshcli.ShellQuote(appInfo.Package), sshcli.ShellQuote(appDir))
+	}
+	// the agent user copies the staged files by name, but can't list the dir
+	if become.User != "" && become.User != sshCli.Username {
+		if _, err := sshCli.ExecCmd("chmod 711 " + sshcli.ShellQuote(stageDir) + " && chmod 644 " + sshcli.ShellQuote(stageDir) + "/*"); err != nil {
+			return "", err
+		}
+	}
+
+	// the environment may hold secrets in metadata, so it's sent to the agent user after escalation,
+	// never in a command line or a file others can read
+	var env bytes.Buffer
+	for _, kv := range agent.ScriptEnv(action, appInfo, appDir) {
+		i := strings.Index(kv, "=")
+		fmt.Fprintf(&env, "%s=%s\n", kv[:i], sshcli.ShellQuote(kv[i+1:]))
+	}
+	cmd += fmt.Sprintf(` && cd %s && set -a && . "$INPUT_FILE" && rm -f "$INPUT_FILE" && set +a && sh ./%s`,
+		sshcli.ShellQuote(appDir), sshcli.ShellQuote(scriptName))
+	become.Timeout = sshScriptTimeout
+	return sshCli.ExecAsWithInput(cmd, become, env.Bytes())
+}
+
+// makeStageDir creates a new dir in /tmp only the ssh user can access, and return its path.
+// mktemp never takes a dir which exists, and the owner is checked in case /tmp is tampered with.
+func makeStageDir(sshCli *sshcli.SSHClient) (string, error) {
+	out, err := sshCli.ExecCmd(`d=$(mktemp -d /tmp/paas-operator-XXXXXXXXXX) && test -O "$d" && test ! -L "$d" && echo "$d"`)
+	if err != nil {
+		return "", fmt.Errorf("create stage dir failed: %s", err)
+	}
+	dir := strings.TrimSpace(out)
+	if filepath.Dir(dir) != "/tmp" || !strings.HasPrefix(filepath.Base(dir), "paas-operator-") {
+		return "", fmt.Errorf("create stage dir failed, got <%s>", dir)
+	}
+	return dir, nil
+}
+
+// sshChecks holds the apps checked over ssh, key: type/name
+var sshChecks sync.Map
+
+// startSSHCheck runs the check script of the app over ssh periodically, and reports the result
+// to the apiserver itself as an agent does. The loop stops when the app is deleted or uninstalled,
+// or its executor isn't ssh any more.
+func startSSHCheck(appType AppType, name string, ctx iris.Context) {
+	key := string(appType) + "/" + name
+	if _, loaded := sshChecks.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		defer sshChecks.Delete(key)
+		c := &http.Client{Timeout: 10 * time.Second}
+		for {
+			a, ok := GetETCDApplications(appType).Get(name, ctx)
+			if !ok {
+				return
+			}
+			app := a.(*GenericApplication)
+			if executor, err := executorOf(app); err != nil || app.GetStatus().Expect == NotInstalled {
+				return
+			} else if _, ok := executor.(*sshExecutor); !ok {
+				return
+			}
+
+			if msg, err := checkOverSSH(app, ctx); err != nil {
+				ctx.Application().Logger().Errorf("Check <%s> over ssh failed: %s", name, err)
+			} else if err := reportCheck(c, appType, name, msg); err != nil {
+				ctx.Application().Logger().Errorf("Report check of <%s> failed: %s", name, err)
+			}
+			time.Sleep(sshCheckInterval)
+		}
+	}()
+}
+
+// checkOverSSH runs the check script of the app once, and return the app healthy json it printed
+func checkOverSSH(app *GenericApplication, ctx iris.Context) (string, error) {
+	sshCli, become, err := dialHost(app.GetHosts()[0], ctx)
+	if err != nil {
+		return "", err
+	}
+	defer sshCli.Cli.Close()
+
+	appInfo := agent.AppInfo{
+		Name: app.Name, Type: app.Type, OperatorIp: OPERATOR_IP, OperatorPort: OPERATOR_PORT,
+		RepoURL: app.App.RepoURL, Check: app.App.Check, Version: app.App.Version,
+		Mirrors: app.App.Mirrors, Checksums: app.App.Checksums, HostIP: app.Host[0].IP, Metadata: app.App.Metadata,
+		OperationID: "check-" + app.Name,
+	}
+	out, err := runOverSSH(sshCli, become, agent.Check, &appInfo)
+	// a check script prints the json and exits with 0 even if the app is unhealthy
+	if err != nil && sshcli.ExitCode(err) < 0 {
+		return "", err
+	}
+	// trim "xxx{xxx}xxx" to "{xxx}"
+	start, end := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("check output isn't json: %s", out)
+	}
+	return out[start : end+1], nil
+}
+
+// reportCheck puts the check result to the apiserver itself, so it's handled like reports of agents
+func reportCheck(c *http.Client, appType AppType, name, msg string) error {
+	url := fmt.Sprintf("http://%s/apis/v1alpha1/%s/%s/check", net.JoinHostPort(OPERATOR_IP, OPERATOR_PORT), appType, name)
+	req, err := http.NewRequest("PUT", url, bytes.NewBufferString(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json;charset=utf-8")
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// RestoreSSHChecks starts checks over ssh of all apps installed before the apiserver started
+func RestoreSSHChecks(ctx iris.Context) {
+	for _, appType := range []AppType{APP_DATABASE, APP_MIDDLEWARE} {
+		for _, a := range GetETCDApplications(appType).List(ctx) {
+			app := a.(*GenericApplication)
+			executor, err := executorOf(app)
+			if err != nil || app.GetStatus().Expect == NotInstalled {
+				continue
+			}
+			if _, ok := executor.(*sshExecutor); ok {
+				startSSHCheck(appType, app.Name, ctx)
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+	"github.com/farmer-hutao/paas-operator/pkg/tunnel"
+)
+
+// tunnelTokenPrefix holds the secret authorizing the tunnel of every node, it's issued at onboarding,
+// eg. key=/paas-operator/tunnel-tokens/192.168.19.101 value="9f86d0..."
+var tunnelTokenPrefix = os.Getenv("ETCD_TUNNEL_TOKEN_PREFIX")
+
+func init() {
+	if tunnelTokenPrefix == "" {
+		tunnelTokenPrefix = "/paas-operator/tunnel-tokens"
+	}
+}
+
+var (
+	tunnelsLock sync.Mutex
+	// agentTunnels holds the tunnels opened by agents in tunnel mode, key: ip of the node
+	agentTunnels = map[string]*tunnel.Tunnel{}
+)
+
+// agentTunnel return the tunnel of the agent on the node, nil if it isn't connected
+func agentTunnel(ip string) *tunnel.Tunnel {
+	tunnelsLock.Lock()
+	defer tunnelsLock.Unlock()
+	return agentTunnels[ip]
+}
+
+// IssueTunnelToken makes a new secret authorizing the tunnel of the agent on the node and return it,
+// the old one is revoked. The agent sends it as a bearer Authorization when it opens the tunnel.
+func IssueTunnelToken(ip string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if _, err := globalKapi.Set(context.Background(), tunnelTokenKey(ip), token, nil); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// tunnelTokenOf return the tunnel token of the node, it's issued if the node hasn't one
+func tunnelTokenOf(ip string) (string, error) {
+	resp, err := globalKapi.Get(context.Background(), tunnelTokenKey(ip), nil)
+	if client.IsKeyNotFound(err) {
+		return IssueTunnelToken(ip)
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.Node.Value, nil
+}
+
+// ForgetTunnelToken revokes the tunnel token of a deleted node
+func ForgetTunnelToken(ip string, ctx iris.Context) {
+	_, err := globalKapi.Delete(context.Background(), tunnelTokenKey(ip), nil)
+	if err != nil && !client.IsKeyNotFound(err) {
+		ctx.Application().Logger().Errorf("Forget tunnel token of <%s> failed: %s", ip, err)
+	}
+}
+
+func tunnelTokenKey(ip string) string {
+	return fmt.Sprintf("%s/%s", tunnelTokenPrefix, ip)
+}
+
+// authorizeTunnel return nil if the request opening the tunnel of the node carries its token
+func authorizeTunnel(ip string, r *http.Request) error {
+	resp, err := globalKapi.Get(context.Background(), tunnelTokenKey(ip), nil)
+	if client.IsKeyNotFound(err) {
+		return fmt.Errorf("node <%s> has no tunnel token, onboard it or issue one", ip)
+	}
+	if err != nil {
+		return err
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(resp.Node.Value)) != 1 {
+		return fmt.Errorf("tunnel token of node <%s> is wrong", ip)
+	}
+	return nil
+}
+
+// ServeTunnel takes over the websocket opened by the agent on the node, and serves it until it closes.
+// The agent must carry the tunnel token of the node, or anyone could take the actions of the node.
+// Requests of the agent through it are served by the apiserver itself if they are allowed by agentRoute,
+// calls to the agent go through it, and the node is not ready as soon as it closes.
+func ServeTunnel(ip string, w http.ResponseWriter, r *http.Request, ctx iris.Context) error {
+	if err := authorizeTunnel(ip, r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return err
+	}
+	conn, err := tunnel.Upgrade(w, r)
+	if err != nil {
+		return err
+	}
+	t := tunnel.New(conn, agentHandler(ip, ctx.Application()))
+	t.OnLog = func(line string) {
+		ctx.Application().Logger().Infof("[node %s] %s", ip, line)
+	}
+
+	// an agent dials again after a network failure, the old tunnel may not be closed yet
+	tunnelsLock.Lock()
+	if old, ok := agentTunnels[ip]; ok {
+		old.Close()
+	}
+	agentTunnels[ip] = t
+	tunnelsLock.Unlock()
+	setTunnelCondition(ip, true, "TunnelConnected", "agent is connected by a tunnel", ctx)
+
+	err = t.Run()
+
+	tunnelsLock.Lock()
+	replaced := agentTunnels[ip] != t
+	if !replaced {
+		delete(agentTunnels, ip)
+	}
+	tunnelsLock.Unlock()
+	if !replaced {
+		setTunnelCondition(ip, false, "TunnelClosed", fmt.Sprintf("tunnel of agent is closed: %s", err), ctx)
+	}
+	return err
+}
+
+// agentHandler serves the requests of the agent on the node by h, other requests are forbidden
+func agentHandler(ip string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !agentRoute(ip, r.Method, r.URL.Path) {
+			http.Error(w, "not allowed through the tunnel of an agent", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// agentRoute return true if the agent on the node may call the api: check reports, and the heartbeat,
+// facts and operations of the node itself
+func agentRoute(ip, method, urlPath string) bool {
+	const prefix = "/apis/v1alpha1/"
+	if !strings.HasPrefix(urlPath, prefix) || path.Clean(urlPath) != urlPath {
+		return false
+	}
+	elems := strings.Split(strings.TrimPrefix(urlPath, prefix), "/")
+	switch {
+	case len(elems) == 3 && (elems[0] == string(APP_DATABASE) || elems[0] == string(APP_MIDDLEWARE)) && elems[2] == "check":
+		return method == http.MethodPut
+	case len(elems) < 3 || elems[0] != "nodes" || elems[1] != ip:
+		return false
+	case len(elems) == 3 && (elems[2] == "heartbeat" || elems[2] == "facts"):
+		return method == http.MethodPut
+	case len(elems) == 3 && elems[2] == "operations":
+		return method == http.MethodGet
+	case len(elems) == 4 && elems[2] == "operations":
+		return method == http.MethodPut
+	}
+	return false
+}
+
+// setTunnelCondition sets the Ready condition of the node by the state of its tunnel
+func setTunnelCondition(ip string, ready bool, reason, msg string, ctx iris.Context) {
+	node, ok := GetETCDNodes().Get(ip, ctx)
+	if !ok {
+		node = &Node{NodeStatus: agent.NodeStatus{IP: ip}}
+	}
+	var changed bool
+	node.Conditions, changed = setCondition(node.Conditions, ConditionReady, ready, reason, msg)
+	if ready {
+		node.LastHeartbeatTime = time.Now().Format("2006-01-02 15:04:05")
+	}
+	if changed {
+		ctx.Application().Logger().Infof("Node <%s> ready: %t, %s", ip, ready, msg)
+	}
+	GetETCDNodes().Save(node, ctx)
+}
+
+// agentDo sends body to the path of the agent api on the host and return the status code and body,
+// through the tunnel of the agent if it's connected, or the relay of its zone if it's in one.
+// timeout 0 waits for as long as the action takes.
+func agentDo(host Hostx, method, path string, body []byte, timeout time.Duration, ctx iris.Context) (int, []byte, error) {
+	if t := agentTunnel(host.IP); t != nil {
+		return t.Do(method, "/"+path, body, timeout)
+	}
+
+	dial, err := dialerFor(host, ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	// the address family of the host is kept by dialing its network
+	if network := host.network(); dial == nil && network != "tcp" {
+		dial = func(_, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, agentTimeout)
+		}
+	}
+	c := http.DefaultClient
+	if timeout > 0 || dial != nil {
+		c = &http.Client{Timeout: timeout}
+	}
+	if dial != nil {
+		c.Transport = &http.Transport{Dial: dial, DisableKeepAlives: true}
+	}
+	req, err := http.NewRequest(method, agentURL(host, path), bytes.NewBuffer(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Add("Content-Type", "application/json;charset=utf-8")
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
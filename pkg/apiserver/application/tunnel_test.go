@@ -0,0 +1,85 @@
+package application
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAgentHandler(t *testing.T) {
+	served := 0
+	h := agentHandler("192.168.19.10", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		method, path string
+		allowed      bool
+	}{
+		{"PUT", "/apis/v1alpha1/database/mysql/check", true},
+		{"PUT", "/apis/v1alpha1/middleware/redis/check", true},
+		{"PUT", "/apis/v1alpha1/nodes/192.168.19.10/heartbeat", true},
+		{"PUT", "/apis/v1alpha1/nodes/192.168.19.10/facts", true},
+		{"GET", "/apis/v1alpha1/nodes/192.168.19.10/operations", true},
+		{"PUT", "/apis/v1alpha1/nodes/192.168.19.10/operations/op-1", true},
+		// apis of users
+		{"GET", "/apis/v1alpha1/credentials", false},
+		{"POST", "/apis/v1alpha1/agent-rollouts", false},
+		{"POST", "/apis/v1alpha1/database/create", false},
+		{"DELETE", "/apis/v1alpha1/database/mysql", false},
+		{"GET", "/apis/v1alpha1/database/mysql/check", false},
+		// other nodes
+		{"PUT", "/apis/v1alpha1/nodes/192.168.19.11/heartbeat", false},
+		{"GET", "/apis/v1alpha1/nodes/192.168.19.10", false},
+		{"PUT", "/apis/v1alpha1/nodes/192.168.19.10/../../credentials/x", false},
+	}
+	for _, c := range cases {
+		served = 0
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if allowed := served == 1; allowed != c.allowed {
+			t.Errorf("%s %s: expect allowed %v, got status %d", c.method, c.path, c.allowed, w.Code)
+		}
+	}
+}
+
+func TestAuthorizeTunnel(t *testing.T) {
+	oldKapi := globalKapi
+	defer func() { globalKapi = oldKapi }()
+	globalKapi = &fakeKeys{}
+
+	request := func(auth string) *http.Request {
+		r := httptest.NewRequest("GET", "/apis/v1alpha1/nodes/192.168.19.101/tunnel", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		return r
+	}
+	if err := authorizeTunnel("192.168.19.101", request("Bearer anything")); err == nil {
+		t.Error("expect a node without tunnel token refused")
+	}
+
+	token, err := IssueTunnelToken("192.168.19.101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := tunnelTokenOf("192.168.19.101"); err != nil || got != token {
+		t.Errorf("expect the issued token kept, got %q, %v", got, err)
+	}
+	cases := []struct {
+		auth string
+		ok   bool
+	}{
+		{"Bearer " + token, true},
+		{"", false},
+		{"Bearer ", false},
+		{"Bearer wrong", false},
+		{token, false},
+	}
+	for _, c := range cases {
+		if err := authorizeTunnel("192.168.19.101", request(c.auth)); (err == nil) != c.ok {
+			t.Errorf("authorization %q: expect ok %v, got %v", c.auth, c.ok, err)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils/sshcli"
+	"github.com/farmer-hutao/paas-operator/pkg/tunnel"
+)
+
+// zonePrefix holds all zones, eg. key=/paas-operator/zones/db
+var zonePrefix = os.Getenv("ETCD_ZONE_PREFIX")
+
+func init() {
+	if zonePrefix == "" {
+		zonePrefix = "/paas-operator/zones"
+		log.Printf("Warning: %s is unset, use default value: %s", "ETCD_ZONE_PREFIX", zonePrefix)
+	}
+}
+
+// Zone is a network segment the apiserver reaches only by a relay (see agent.Relay), or by ssh
+// bastions only.
+// Hosts are assigned to a zone by Hostx.Zone.
+type Zone struct {
+	Name string `json:"name"`
+	// Relay is the address the apiserver reaches the relay at, eg. 192.168.19.50:3336
+	Relay string `json:"relay"`
+	// AgentRelay is the address agents in the zone reach the relay at, Relay by default
+	AgentRelay string `json:"agent_relay,omitempty"`
+	// Token is AGENT_RELAY_TOKEN of the relay, it's never returned by the api
+	Token string `json:"token,omitempty"`
+	// Jumps are the ssh bastions to hosts in the zone, after the relay if it has one
+	Jumps []Jumpx `json:"jumps,omitempty"`
+}
+
+// Validate return an error if the zone can't be used
+func (z *Zone) Validate() error {
+	if z.Name == "" || strings.Contains(z.Name, "/") {
+		return fmt.Errorf("zone name <%s> is illegal", z.Name)
+	}
+	if z.Relay == "" && len(z.Jumps) > 0 {
+		return validateJumps(z.Jumps)
+	}
+	if _, _, err := net.SplitHostPort(z.Relay); err != nil {
+		return fmt.Errorf("relay <%s> is illegal, expect ip:port", z.Relay)
+	}
+	if z.AgentRelay != "" {
+		if _, _, err := net.SplitHostPort(z.AgentRelay); err != nil {
+			return fmt.Errorf("agent_relay <%s> is illegal, expect ip:port", z.AgentRelay)
+		}
+	}
+	return validateJumps(z.Jumps)
+}
+
+func validateJumps(jumps []Jumpx) error {
+	for _, jump := range jumps {
+		if jump.IP == "" || jump.Username == "" {
+			return fmt.Errorf("jump host <%s> is illegal, ip and username are needed", jump.IP)
+		}
+	}
+	return nil
+}
+
+// agentRelay return the host and port agents in the zone reach the apiserver by
+func (z *Zone) agentRelay() (string, string) {
+	addr := z.AgentRelay
+	if addr == "" {
+		addr = z.Relay
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	return host, port
+}
+
+// dialer return how to connect to hosts in the zone, nil if the zone has no relay
+func (z *Zone) dialer() func(network, addr string) (net.Conn, error) {
+	if z.Relay == "" {
+		return nil
+	}
+	return z.dial
+}
+
+// dial opens a connection to addr in the zone through the relay
+func (z *Zone) dial(network, addr string) (net.Conn, error) {
+	return tunnel.DialConnect(z.Relay, z.Token, addr, 10*time.Second)
+}
+
+type ETCDZones struct {
+	kapi   client.KeysAPI
+	prefix string
+}
+
+var etcdZones = &ETCDZones{}
+
+func GetETCDZones() *ETCDZones {
+	etcdZones.kapi = globalKapi
+	etcdZones.prefix = zonePrefix
+	return etcdZones
+}
+
+func (zones *ETCDZones) key(name string) string {
+	return fmt.Sprintf("%s/%s", zones.prefix, name)
+}
+
+// Save adds or updates the zone
+func (zones *ETCDZones) Save(zone *Zone, ctx iris.Context) error {
+	zoneBytes, err := json.MarshalIndent(zone, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = zones.kapi.Set(context.Background(), zones.key(zone.Name), string(zoneBytes), nil)
+	if err != nil {
+		ctx.Application().Logger().Errorf("Save zone <%s> to etcd failed. with error: <%s>", zone.Name, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Get return the zone with the name, false if it isn't exist
+func (zones *ETCDZones) Get(name string, ctx iris.Context) (*Zone, bool) {
+	resp, err := zones.kapi.Get(context.Background(), zones.key(name), nil)
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("Get zone <%s> from etcd failed: <%s>", name, err.Error())
+		}
+		return nil, false
+	}
+	var zone = new(Zone)
+	if err := json.Unmarshal([]byte(resp.Node.Value), zone); err != nil {
+		ctx.Application().Logger().Errorf("Get zone <%s>, json unmarshal failed: <%s>", name, err.Error())
+		return nil, false
+	}
+	return zone, true
+}
+
+// List return all zones, zones which can't be unmarshaled are skipped
+func (zones *ETCDZones) List(ctx iris.Context) []*Zone {
+	resp, err := zones.kapi.Get(context.Background(), zones.prefix, &client.GetOptions{Sort: true})
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			ctx.Application().Logger().Errorf("List zones from etcd failed: <%s>", err.Error())
+		}
+		return nil
+	}
+	var list = make([]*Zone, 0, len(resp.Node.Nodes))
+	for _, n := range resp.Node.Nodes {
+		var zone = new(Zone)
+		if err := json.Unmarshal([]byte(n.Value), zone); err != nil {
+			ctx.Application().Logger().Errorf("List zone <%s>, json unmarshal failed: <%s>", n.Key, err.Error())
+			continue
+		}
+		list = append(list, zone)
+	}
+	return list
+}
+
+// Delete removes the zone, a zone not exist isn't an error
+func (zones *ETCDZones) Delete(name string, ctx iris.Context) error {
+	_, err := zones.kapi.Delete(context.Background(), zones.key(name), nil)
+	if err != nil && !client.IsKeyNotFound(err) {
+		ctx.Application().Logger().Errorf("Delete zone <%s> from etcd failed: <%s>", name, err.Error())
+		return err
+	}
+	return nil
+}
+
+// zoneOf return the zone of the host, nil if the apiserver reaches it directly
+func zoneOf(host Hostx, ctx iris.Context) (*Zone, error) {
+	if host.Zone == "" {
+		return nil, nil
+	}
+	zone, ok := GetETCDZones().Get(host.Zone, ctx)
+	if !ok {
+		return nil, fmt.Errorf("zone <%s> of host <%s> is not exist", host.Zone, host.IP)
+	}
+	return zone, nil
+}
+
+// operatorFor return the address agents on the host reach the apiserver by, the relay of its zone if it has one,
+// and the token of the relay
+func operatorFor(host Hostx, ctx iris.Context) (string, string, string, error) {
+	zone, err := zoneOf(host, ctx)
+	if err != nil {
+		return "", "", "", err
+	}
+	if zone == nil || zone.Relay == "" {
+		return OPERATOR_IP, OPERATOR_PORT, "", nil
+	}
+	ip, port := zone.agentRelay()
+	return ip, port, zone.Token, nil
+}
+
+// operatorGRPCPortOf return the port of the grpc api for agents reaching the apiserver at ip, empty if they
+// reach it by the relay of a zone, which proxies only the json api
+func operatorGRPCPortOf(operatorIp string) string {
+	if operatorIp != OPERATOR_IP {
+		return ""
+	}
+	return OPERATOR_GRPC_PORT
+}
+
+// dialerFor return how to connect to the host, nil to connect directly
+func dialerFor(host Hostx, ctx iris.Context) (func(network, addr string) (net.Conn, error), error) {
+	zone, err := zoneOf(host, ctx)
+	if err != nil || zone == nil {
+		return nil, err
+	}
+	return zone.dialer(), nil
+}
+
+// sshClientOf return the ssh client to the host, through the relay and the jump hosts of its zone if it's in one
+func sshClientOf(host Hostx, username, password string, ctx iris.Context) (*sshcli.SSHClient, error) {
+	zone, err := zoneOf(host, ctx)
+	if err != nil {
+		return nil, err
+	}
+	sshCli := sshcli.New(host.IP, username, password, host.sshPort())
+	sshCli.Network = host.network()
+	jumps := host.Jumps
+	if zone != nil {
+		sshCli.Dial = zone.dialer()
+		if len(jumps) == 0 {
+			jumps = zone.Jumps
+		}
+	}
+	for _, jump := range jumps {
+		sshCli.Jumps = append(sshCli.Jumps, sshcli.JumpHost{Host: jump.IP, Port: jump.Port, Username: jump.Username, Password: jump.Password})
+	}
+	return sshCli, nil
+}
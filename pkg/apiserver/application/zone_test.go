@@ -0,0 +1,40 @@
+package application
+
+import "testing"
+
+func TestZoneValidate(t *testing.T) {
+	cases := []struct {
+		zone  Zone
+		legal bool
+	}{
+		{Zone{Name: "db", Relay: "192.168.19.50:3336"}, true},
+		{Zone{Name: "db", Relay: "192.168.19.50:3336", AgentRelay: "10.10.0.5:3336"}, true},
+		{Zone{Name: "db", Jumps: []Jumpx{{IP: "192.168.19.51", Username: "ops"}}}, true},
+		{Zone{Name: "db"}, false},
+		{Zone{Name: "db", Relay: "192.168.19.50"}, false},
+		{Zone{Name: "a/b", Relay: "192.168.19.50:3336"}, false},
+		{Zone{Name: "db", Jumps: []Jumpx{{IP: "192.168.19.51"}}}, false},
+	}
+	for _, c := range cases {
+		if err := c.zone.Validate(); (err == nil) != c.legal {
+			t.Errorf("zone %+v: expect legal %t, got %v", c.zone, c.legal, err)
+		}
+	}
+}
+
+func TestSSHClientOfJumps(t *testing.T) {
+	host := Hostx{IP: "10.10.0.8", Jumps: []Jumpx{
+		{IP: "192.168.19.51", Username: "ops", Password: "xxx"},
+		{IP: "10.10.0.2", Port: "2222", Username: "ops", Password: "yyy"},
+	}}
+	sshCli, err := sshClientOf(host, "root", "zzz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sshCli.Dial != nil || len(sshCli.Jumps) != 2 {
+		t.Fatalf("expect 2 jump hosts without a relay, got %+v", sshCli)
+	}
+	if j := sshCli.Jumps[1]; j.Host != "10.10.0.2" || j.Port != "2222" || j.Password != "yyy" {
+		t.Errorf("unexpected jump host %+v", j)
+	}
+}
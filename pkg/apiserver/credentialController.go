@@ -0,0 +1,73 @@
+package apiserver
+
+import (
+	"fmt"
+
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/application"
+)
+
+// ListCredentials return all credentials, without their passwords
+func ListCredentials(ctx iris.Context) {
+	creds := application.GetETCDCredentials().List(ctx)
+	if creds == nil {
+		creds = []*application.Credential{}
+	}
+	for _, cred := range creds {
+		redactCredential(cred)
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(creds)
+}
+
+// SaveCredential adds or updates the credential with the name, hosts refer to it by the credential of their profile
+func SaveCredential(ctx iris.Context) {
+	name := ctx.Params().GetString("name")
+	var cred application.Credential
+	if err := ctx.ReadJSON(&cred); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		ctx.Application().Logger().Errorf("SaveCredential Error, json is illegal: %s", err)
+		return
+	}
+	if cred.Name == "" {
+		cred.Name = name
+	}
+	if cred.Name != name {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(fmt.Sprintf("credential name <%s> isn't the one in the url <%s>", cred.Name, name))
+		return
+	}
+	if err := cred.Validate(); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	if err := application.GetETCDCredentials().Save(&cred, ctx); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	redactCredential(&cred)
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(cred)
+}
+
+// DeleteCredential forgets a credential, hosts referring to it can't be connected to until it's added again
+func DeleteCredential(ctx iris.Context) {
+	name := ctx.Params().GetString("name")
+	if err := application.GetETCDCredentials().Delete(name, ctx); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+}
+
+// redactCredential removes the passwords of the credential before it's returned
+func redactCredential(cred *application.Credential) {
+	for i := range cred.Auth {
+		cred.Auth[i].Password = ""
+	}
+}
@@ -90,6 +90,45 @@ func createApplication(appType application.AppType, ctx iris.Context) {
 		return
 	}
 
+	// validate probes, they are run by the agent and can't be fixed after install
+	for i := range app.GetApp().Probes {
+		if err := app.GetApp().Probes[i].Validate(); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			ctx.Application().Logger().Errorf("CreateApplication Error, probe is illegal: %s", err)
+			return
+		}
+	}
+	if policy := app.GetApp().RestartPolicy; policy != nil {
+		if err := policy.Validate(); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			ctx.Application().Logger().Errorf("CreateApplication Error, restart policy is illegal: %s", err)
+			return
+		}
+	}
+	if policy := app.GetApp().InstallFailurePolicy; policy != nil {
+		if err := policy.Validate(); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			ctx.Application().Logger().Errorf("CreateApplication Error, install failure policy is illegal: %s", err)
+			return
+		}
+	}
+
+	if err := application.ValidateExecutor(&app); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		ctx.Application().Logger().Errorf("CreateApplication Error, executor is illegal: %s", err)
+		return
+	}
+	if err := application.ValidateHosts(&app, ctx); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		ctx.Application().Logger().Errorf("CreateApplication Error, host is illegal: %s", err)
+		return
+	}
+
 	// validate application is already exist
 	if _, ok := application.GetETCDApplications(appType).Get(app.GetName(), ctx); ok {
 		ctx.StatusCode(iris.StatusBadRequest)
@@ -161,7 +200,7 @@ func updateApplicationStatus(appType application.AppType, ctx iris.Context) {
 	if _, ok := application.ApplicationStatusMap[expectStatus]; !ok {
 		ctx.StatusCode(iris.StatusBadRequest)
 		ctx.WriteString("application status is illegal: " + status)
-		ctx.Application().Logger().Errorf("application status is illegal: " + status)
+		ctx.Application().Logger().Errorf("application status is illegal: %s", status)
 		return
 	}
 
@@ -230,13 +269,15 @@ func getApplicationStatus(appType application.AppType, ctx iris.Context) {
 	status := app.GetStatus()
 
 	_, err := ctx.JSON(iris.Map{
-		"name":   app.GetName(),
-		"status": status,
+		"name":       app.GetName(),
+		"status":     status,
+		"conditions": app.GetConditions(),
+		"events":     app.GetEvents(),
 	})
 	if err != nil {
 		ctx.StatusCode(iris.StatusInternalServerError)
 		ctx.WriteString("got some error")
-		ctx.Application().Logger().Errorf("get app status failed: %s" + appName)
+		ctx.Application().Logger().Errorf("get app status failed: %s", appName)
 		return
 	}
 
@@ -255,6 +296,7 @@ func deleteApplication(appType application.AppType, ctx iris.Context) {
 		ctx.Application().Logger().Errorf("Failed to delete a app <%s>.", appName)
 		return
 	}
+	application.ForgetReport(appType, appName, ctx)
 
 	if app == nil {
 		ctx.StatusCode(iris.StatusOK)
@@ -295,28 +337,88 @@ func setApplicationRealtimeStatus(appType application.AppType, ctx iris.Context)
 		return
 	}
 
-	var healthy = false
-	if appHealthy.Code == "0" {
-		healthy = true
+	// reports queued by the agent while the apiserver was unreachable come late
+	at, err := checkTimeOf(&appHealthy)
+	if err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
 	}
 
-	app, ok := application.GetETCDApplications(appType).Get(appName, ctx)
-	if !ok {
+	exist, err := recordCheck(appType, appName, &appHealthy, at, ctx)
+	if err != nil {
+		// the agent keeps the report and sends it again
+		ctx.StatusCode(iris.StatusServiceUnavailable)
+		ctx.WriteString(err.Error())
+		return
+	}
+	if !exist {
 		ctx.StatusCode(iris.StatusBadRequest)
 		ctx.WriteString("app not exist")
 		return
 	}
+	ctx.StatusCode(iris.StatusAccepted)
+}
 
-	expect := app.GetStatus().Expect
+// checkTimeOf return when the check of the report ran, now if the agent doesn't send it
+func checkTimeOf(appHealthy *utils.AppHealthy) (time.Time, error) {
+	if appHealthy.Time == "" {
+		return time.Now(), nil
+	}
+	at, err := time.Parse(time.RFC3339, appHealthy.Time)
+	if err != nil {
+		return at, fmt.Errorf("time is illegal, expect RFC 3339: %s", err)
+	}
+	return at, nil
+}
 
-	if expect == application.Running {
-		if app.GetStatus().Realtime == application.Running && !healthy {
-			app.SetStatus(application.ApplicationStatus(""), application.Failed, ctx)
-		} else if app.GetStatus().Realtime != application.Running && healthy {
-			app.SetStatus(application.ApplicationStatus(""), application.Running, ctx)
+// recordCheck records a check report of the app, sent by the json api or the grpc api.
+// It return false if the app isn't exist, an error if the report can't be saved now.
+func recordCheck(appType application.AppType, appName string, appHealthy *utils.AppHealthy, at time.Time, ctx iris.Context) (bool, error) {
+	// the check history is saved by compare-and-swap, reports come every few seconds and mustn't
+	// overwrite what users or operations saved meanwhile
+	var realtime application.ApplicationStatus
+	app, ok, err := application.GetETCDApplications(appType).Change(appName, func(app application.Application) {
+		expect := app.GetStatus().Expect
+
+		// a single check result never changes the status, the thresholds of the app decide it
+		verdict := app.RecordCheck(appHealthy.Code == "0", appHealthy.Msg, at, ctx)
+		app.Reported(ctx)
+
+		realtime = realtimeOfCheck(expect, app.GetStatus().Realtime, verdict)
+		if realtime != "" {
+			app.GetStatus().Realtime = realtime
 		}
+	}, ctx)
+	if err != nil || !ok {
+		return false, err
 	}
-	ctx.StatusCode(iris.StatusAccepted)
+
+	if realtime == "" {
+		return true, nil
+	}
+	if err := application.GetETCDApplications(appType).AddChangedApp(appName, ctx); err != nil {
+		ctx.Application().Logger().Errorf("Got some error: %s", err.Error())
+	}
+	if realtime == application.Failed {
+		app.AutoRestart(ctx)
+	}
+	return true, nil
+}
+
+// realtimeOfCheck return the realtime status the verdict of the checks moves the app to, "" to keep it.
+// An operation in progress (installing, restarting...) sets the status itself when it's done.
+func realtimeOfCheck(expect, realtime, verdict application.ApplicationStatus) application.ApplicationStatus {
+	if expect != application.Running {
+		return ""
+	}
+	switch {
+	case (realtime == application.Running || realtime == application.Unknown) && verdict == application.Failed:
+		return application.Failed
+	case (realtime == application.Failed || realtime == application.Unknown) && verdict == application.Running:
+		return application.Running
+	}
+	return ""
 }
 
 func getApplicationsStatusChanged(appType application.AppType, ctx iris.Context) {
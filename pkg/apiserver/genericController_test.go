@@ -1,6 +1,10 @@
 package apiserver
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/application"
+)
 
 func TestCreateDatabase(t *testing.T) {
 
@@ -13,3 +17,27 @@ func TestGetDatabaseStatus(t *testing.T) {
 func TestUpdateDatabaseStatus(t *testing.T) {
 
 }
+
+func TestRealtimeOfCheck(t *testing.T) {
+	cases := []struct {
+		expect, realtime, verdict, want application.ApplicationStatus
+	}{
+		{application.Running, application.Running, application.Failed, application.Failed},
+		{application.Running, application.Unknown, application.Failed, application.Failed},
+		{application.Running, application.Failed, application.Running, application.Running},
+		{application.Running, application.Unknown, application.Running, application.Running},
+		{application.Running, application.Running, application.Running, ""},
+		{application.Running, application.Failed, application.Failed, ""},
+		// an operation in progress isn't overwritten by a check
+		{application.Running, application.Installing, application.Running, ""},
+		{application.Running, application.Restarting, application.Running, ""},
+		{application.Running, application.Starting, application.Failed, ""},
+		{application.Running, application.Stopping, application.Running, ""},
+		{application.Stopped, application.Failed, application.Running, ""},
+	}
+	for _, c := range cases {
+		if got := realtimeOfCheck(c.expect, c.realtime, c.verdict); got != c.want {
+			t.Errorf("expect %s, realtime %s, verdict %s: want <%s>, got <%s>", c.expect, c.realtime, c.verdict, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"time"
+
+	"github.com/kataras/iris"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/application"
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils"
+)
+
+// operatorServer serves the Operator service of protocol v1 to agents, next to the json api
+type operatorServer struct {
+	// record records a check report like the json api, it return false if the app isn't exist
+	record func(appType application.AppType, appName string, appHealthy *utils.AppHealthy, at time.Time) (bool, error)
+	ctx    iris.Context
+}
+
+func newOperatorServer(ctx iris.Context) *operatorServer {
+	return &operatorServer{
+		record: func(appType application.AppType, appName string, appHealthy *utils.AppHealthy, at time.Time) (bool, error) {
+			return recordCheck(appType, appName, appHealthy, at, ctx)
+		},
+		ctx: ctx,
+	}
+}
+
+// serveGRPC serves the grpc api on OPERATOR_GRPC_PORT, it returns only on errors
+func serveGRPC(ctx iris.Context) error {
+	l, err := net.Listen("tcp", ":"+application.OPERATOR_GRPC_PORT)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	agentpb.RegisterOperatorServer(s, newOperatorServer(ctx))
+	ctx.Application().Logger().Infof("grpc api on :%s", application.OPERATOR_GRPC_PORT)
+	return s.Serve(l)
+}
+
+// Report answers every check report in order: STOP_CHECK if the app isn't exist, ACK otherwise, an illegal
+// report is acked and dropped like a 400 of the json api. The stream fails if the report can't be recorded
+// now, the agent keeps it and sends it again.
+func (s *operatorServer) Report(stream agentpb.Operator_ReportServer) error {
+	for {
+		report, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		cmd, err := s.handle(report)
+		if err != nil {
+			s.ctx.Application().Logger().Errorf("Record check report of <%s_%s> failed: %s", report.AppType, report.AppName, err)
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		if err := stream.Send(&agentpb.ReportCommand{AppType: report.AppType, AppName: report.AppName, Command: cmd}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *operatorServer) handle(report *agentpb.CheckReport) (agentpb.ReportCommand_Command, error) {
+	appType := application.AppType(report.AppType)
+	if appType != application.APP_DATABASE && appType != application.APP_MIDDLEWARE {
+		return agentpb.ReportCommand_STOP_CHECK, nil
+	}
+
+	var appHealthy utils.AppHealthy
+	if err := json.Unmarshal([]byte(report.Health), &appHealthy); err != nil {
+		s.ctx.Application().Logger().Errorf("Check report of <%s_%s> is illegal: %s", report.AppType, report.AppName, err)
+		return agentpb.ReportCommand_ACK, nil
+	}
+	appHealthy.Time = report.Time
+	at, err := checkTimeOf(&appHealthy)
+	if err != nil {
+		s.ctx.Application().Logger().Errorf("Check report of <%s_%s> is illegal: %s", report.AppType, report.AppName, err)
+		return agentpb.ReportCommand_ACK, nil
+	}
+
+	exist, err := s.record(appType, report.AppName, &appHealthy, at)
+	if err != nil {
+		return agentpb.ReportCommand_COMMAND_UNSPECIFIED, err
+	}
+	if !exist {
+		return agentpb.ReportCommand_STOP_CHECK, nil
+	}
+	return agentpb.ReportCommand_ACK, nil
+}
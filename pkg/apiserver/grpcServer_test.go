@@ -0,0 +1,91 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris"
+	irisctx "github.com/kataras/iris/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent/agentpb/v1"
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/application"
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/utils"
+)
+
+func TestOperatorReport(t *testing.T) {
+	ran := time.Now().Add(-time.Minute).Truncate(time.Second)
+	var recorded []string
+	s := newOperatorServer(irisctx.NewContext(iris.New()))
+	s.record = func(appType application.AppType, appName string, appHealthy *utils.AppHealthy, at time.Time) (bool, error) {
+		switch appName {
+		case "gone":
+			return false, nil
+		case "broken":
+			return false, errors.New("etcd is down")
+		}
+		if !at.Equal(ran) {
+			t.Errorf("expect the time the check ran, got %s", at)
+		}
+		recorded = append(recorded, appHealthy.Msg)
+		return true, nil
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpc.NewServer()
+	agentpb.RegisterOperatorServer(gs, s)
+	go gs.Serve(l)
+	defer gs.Stop()
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := agentpb.NewOperatorClient(conn).Report(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		appType, name, health, time string
+		expect                      agentpb.ReportCommand_Command
+	}{
+		{"database", "mysql", `{"code":"0","msg":"ok"}`, ran.Format(time.RFC3339), agentpb.ReportCommand_ACK},
+		// an illegal report is dropped like a 400 of the json api
+		{"database", "mysql", `not json`, ran.Format(time.RFC3339), agentpb.ReportCommand_ACK},
+		{"database", "mysql", `{"code":"0","msg":"ok"}`, "yesterday", agentpb.ReportCommand_ACK},
+		{"database", "gone", `{"code":"0","msg":"ok"}`, ran.Format(time.RFC3339), agentpb.ReportCommand_STOP_CHECK},
+		{"cache", "redis", `{"code":"0","msg":"ok"}`, ran.Format(time.RFC3339), agentpb.ReportCommand_STOP_CHECK},
+	}
+	for _, c := range cases {
+		if err := stream.Send(&agentpb.CheckReport{AppType: c.appType, AppName: c.name, Health: c.health, Time: c.time}); err != nil {
+			t.Fatal(err)
+		}
+		cmd, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd.AppType != c.appType || cmd.AppName != c.name || cmd.Command != c.expect {
+			t.Errorf("expect %s for %s_%s, got %v", c.expect, c.appType, c.name, cmd)
+		}
+	}
+	if len(recorded) != 1 || recorded[0] != "ok" {
+		t.Errorf("expect only the legal report recorded, got %v", recorded)
+	}
+
+	// a report can't be recorded now is kept by the agent
+	if err := stream.Send(&agentpb.CheckReport{AppType: "database", AppName: "broken", Health: `{"code":"0"}`}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("expect the stream unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package apiserver
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/agent"
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/application"
+)
+
+// ListNodes return all nodes with their conditions
+func ListNodes(ctx iris.Context) {
+	nodes := application.GetETCDNodes().List(ctx)
+	if nodes == nil {
+		nodes = []*application.Node{}
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(nodes)
+}
+
+// OnboardNode bootstraps the agent on a host with its credentials, the node is ready after that
+// and installs on the host skip bootstrapping. It's done in background, query the node for the result.
+func OnboardNode(ctx iris.Context) {
+	var host application.Hostx
+	if err := ctx.ReadJSON(&host); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		ctx.Application().Logger().Errorf("OnboardNode Error, json is illegal: %s", err)
+		return
+	}
+	if net.ParseIP(host.IP) == nil || (len(host.Auth) == 0 && (host.Profile == nil || host.Profile.Credential == "")) {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString("ip and auth (or a credential in the profile) are needed")
+		return
+	}
+	if err := application.ValidateHost(host, ctx); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+
+	go application.Onboard(host, ctx)
+
+	ctx.StatusCode(iris.StatusAccepted)
+	ctx.JSON(iris.Map{
+		"ip": host.IP,
+	})
+}
+
+// GetNode return the node with the ip
+func GetNode(ctx iris.Context) {
+	ip := ctx.Params().GetString("ip")
+	node, ok := application.GetETCDNodes().Get(ip, ctx)
+	if !ok {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString(fmt.Sprintf("node <%s> is not exist", ip))
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(node)
+}
+
+// NodeHeartbeat registers the node at the first time, and keeps it ready (only called by agent)
+func NodeHeartbeat(ctx iris.Context) {
+	ip := ctx.Params().GetString("ip")
+	if net.ParseIP(ip) == nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString("ip is illegal: " + ip)
+		return
+	}
+
+	var status agent.NodeStatus
+	if err := ctx.ReadJSON(&status); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		ctx.Application().Logger().Errorf("Heartbeat of node <%s> is illegal: %s", ip, err)
+		return
+	}
+	status.IP = ip
+
+	node, ok := application.GetETCDNodes().Get(ip, ctx)
+	if !ok {
+		ctx.Application().Logger().Infof("Node <%s> registered, agent version: %s", ip, status.AgentVersion)
+		node = &application.Node{}
+	}
+	node.Heartbeat(&status, ctx)
+	if err := application.GetETCDNodes().Save(node, ctx); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(node)
+}
+
+// DeleteNode forgets a node, it's registered again by the next heartbeat if the agent is alive
+func DeleteNode(ctx iris.Context) {
+	ip := ctx.Params().GetString("ip")
+	if err := application.GetETCDNodes().Delete(ip, ctx); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	application.ForgetTunnelToken(ip, ctx)
+	ctx.StatusCode(iris.StatusOK)
+}
+
+// IssueNodeTunnelToken issues a new tunnel token of the node for an agent in tunnel mode deployed by hand,
+// the old one is revoked
+func IssueNodeTunnelToken(ctx iris.Context) {
+	ip := ctx.Params().GetString("ip")
+	if net.ParseIP(ip) == nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString("ip is illegal: " + ip)
+		return
+	}
+	token, err := application.IssueTunnelToken(ip)
+	if err != nil {
+		ctx.Application().Logger().Errorf("Issue tunnel token of <%s> failed: %s", ip, err)
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(iris.Map{"tunnel_token": token})
+}
+
+// GetNodeFacts return the facts of the node with the ip
+func GetNodeFacts(ctx iris.Context) {
+	ip := ctx.Params().GetString("ip")
+	node, ok := application.GetETCDNodes().Get(ip, ctx)
+	if !ok || node.Facts == nil {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString(fmt.Sprintf("facts of node <%s> is not exist", ip))
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(node.Facts)
+}
+
+// SetNodeFacts saves the facts gathered by the agent on the node (only called by agent)
+func SetNodeFacts(ctx iris.Context) {
+	ip := ctx.Params().GetString("ip")
+	node, ok := application.GetETCDNodes().Get(ip, ctx)
+	if !ok {
+		// the agent registers by a heartbeat before reporting facts
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString(fmt.Sprintf("node <%s> is not exist", ip))
+		return
+	}
+
+	var facts agent.Facts
+	if err := ctx.ReadJSON(&facts); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		ctx.Application().Logger().Errorf("Facts of node <%s> is illegal: %s", ip, err)
+		return
+	}
+	node.Facts = &facts
+	if err := application.GetETCDNodes().Save(node, ctx); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+}
+
+// PollNodeOperations return the operations queued for the pull mode agent on the node (only called by agent).
+// The request is held up to ?wait= seconds if none is queued.
+func PollNodeOperations(ctx iris.Context) {
+	ip := ctx.Params().GetString("ip")
+	if net.ParseIP(ip) == nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString("ip is illegal: " + ip)
+		return
+	}
+	wait := ctx.URLParamIntDefault("wait", 0)
+	ops := application.PollOperations(ip, time.Duration(wait)*time.Second, ctx)
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(ops)
+}
+
+// FinishNodeOperation saves the result of an operation run by the pull mode agent on the node (only called by agent)
+func FinishNodeOperation(ctx iris.Context) {
+	ip := ctx.Params().GetString("ip")
+	id := ctx.Params().GetString("id")
+
+	var result agent.OperationResult
+	if err := ctx.ReadJSON(&result); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		ctx.Application().Logger().Errorf("Result of operation <%s> is illegal: %s", id, err)
+		return
+	}
+	ok, err := application.FinishOperation(ip, id, &result, ctx)
+	if err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	if !ok {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString(fmt.Sprintf("operation <%s> of node <%s> is not exist", id, ip))
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+}
+
+// NodeTunnel takes over the websocket opened by the agent in tunnel mode on the node (only called by agent).
+// It returns when the tunnel closes.
+func NodeTunnel(ctx iris.Context) {
+	ip := ctx.Params().GetString("ip")
+	if net.ParseIP(ip) == nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString("ip is illegal: " + ip)
+		return
+	}
+	if err := application.ServeTunnel(ip, ctx.ResponseWriter(), ctx.Request(), ctx); err != nil {
+		ctx.Application().Logger().Warnf("Tunnel of node <%s> is closed: %s", ip, err)
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"time"
 
 	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
 	"github.com/kataras/iris/middleware/recover"
+
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/application"
 )
 
 func Run() {
@@ -30,6 +33,20 @@ func Run() {
 	})
 
 	applyRoute(app)
+
+	// apps whose check reports go stale are set unknown
+	go application.WatchStaleReports(context.NewContext(app))
+	// nodes whose agents stop sending heartbeats are set not ready
+	go application.WatchNodeHeartbeats(context.NewContext(app))
+	// apps run over ssh are checked by the apiserver instead of an agent
+	go application.RestoreSSHChecks(context.NewContext(app))
+	// agents send check reports by the grpc api of protocol v1 too, see proto/agent/v1
+	go func() {
+		if err := serveGRPC(context.NewContext(app)); err != nil {
+			app.Logger().Errorf("grpc api stopped: %s", err)
+		}
+	}()
+
 	if err := app.Run(iris.Addr(fmt.Sprintf("%s:%s", "", "3334"))); err != nil {
 		app.Logger().Fatal(err)
 	}
@@ -67,6 +84,62 @@ func applyRoute(app *iris.Application) {
 
 	// Set a middleware's realtime status
 	mwRouter.Put("/{a_name}/check", SetMiddlewareRealtimeStatus)
+
+	nodeRouter := versionRouter.Party("/nodes")
+	// Query all nodes
+	nodeRouter.Get("", ListNodes)
+	// Onboard a host by bootstrapping the agent on it
+	nodeRouter.Post("", OnboardNode)
+	// Query a node by ip
+	nodeRouter.Get("/{ip}", GetNode)
+	// Delete a node by ip
+	nodeRouter.Delete("/{ip}", DeleteNode)
+	// Register a node and keep it ready (only called by agent)
+	nodeRouter.Put("/{ip}/heartbeat", NodeHeartbeat)
+	// Query facts of a node
+	nodeRouter.Get("/{ip}/facts", GetNodeFacts)
+	// Set facts of a node (only called by agent)
+	nodeRouter.Put("/{ip}/facts", SetNodeFacts)
+	// Long-poll operations queued for a pull mode agent (only called by agent)
+	nodeRouter.Get("/{ip}/operations", PollNodeOperations)
+	// Report the result of an operation (only called by agent)
+	nodeRouter.Put("/{ip}/operations/{id}", FinishNodeOperation)
+	// Open the websocket tunnel of an agent in tunnel mode (only called by agent)
+	nodeRouter.Get("/{ip}/tunnel", NodeTunnel)
+	// Issue a new tunnel token of a node, for an agent in tunnel mode deployed by hand
+	nodeRouter.Post("/{ip}/tunnel-token", IssueNodeTunnelToken)
+
+	zoneRouter := versionRouter.Party("/zones")
+	// Query all zones, secrets are not returned
+	zoneRouter.Get("", ListZones)
+	// Add or update a zone of hosts reached by a relay
+	zoneRouter.Put("/{name}", SaveZone)
+	// Query a zone by name
+	zoneRouter.Get("/{name}", GetZone)
+	// Delete a zone by name
+	zoneRouter.Delete("/{name}", DeleteZone)
+
+	credentialRouter := versionRouter.Party("/credentials")
+	// Query all credentials, passwords are not returned
+	credentialRouter.Get("", ListCredentials)
+	// Add or update a credential referred by profiles of hosts
+	credentialRouter.Put("/{name}", SaveCredential)
+	// Delete a credential by name
+	credentialRouter.Delete("/{name}", DeleteCredential)
+
+	agentRouter := versionRouter.Party("/agents")
+	// Query versions of agent served by the apiserver
+	agentRouter.Get("", ListAgentReleases)
+	// Download the agent binary of a version (only called by agent)
+	agentRouter.Get("/{version}/agent", GetAgentBinary)
+
+	rolloutRouter := versionRouter.Party("/agent-rollouts")
+	// Upgrade agents of nodes to a version batch by batch
+	rolloutRouter.Post("", CreateAgentRollout)
+	// Query all rollouts
+	rolloutRouter.Get("", ListAgentRollouts)
+	// Query the progress of a rollout
+	rolloutRouter.Get("/{id}", GetAgentRollout)
 }
 
 // eg. path=/var/log ->
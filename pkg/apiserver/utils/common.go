@@ -4,23 +4,16 @@ import (
 	"encoding/json"
 )
 
-// TODO 结果判断
-var DoWithSu = `#!/usr/bin/expect -f
-spawn -noecho su [lindex $argv 0] -c [lindex $argv 2]
-set password [lindex $argv 1]
-expect "*assword:"
-send "$password\r"
-set timeout 60
-expect eof
-exit`
-
 //{
 //	  "code": "0",
-//	  "msg": "some message"
+//	  "msg": "some message",
+//	  "time": "2019-06-28T10:33:55+08:00"
 //}
 type AppHealthy struct {
 	Code string `json:"code"`
 	Msg  string `json:"msg"`
+	// Time is when the check ran in RFC 3339, set by the agent as reports may be sent late; empty means now
+	Time string `json:"time,omitempty"`
 }
 
 func ValidateAppHealthyJson(jsonStr string) bool {
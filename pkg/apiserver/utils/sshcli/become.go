@@ -0,0 +1,308 @@
+package sshcli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	BecomeSu   = "su"
+	BecomeSudo = "sudo"
+)
+
+// promptTimeout is how long su or sudo may take to ask for the password
+const promptTimeout = 15 * time.Second
+
+// defaultBecomeTimeout is how long a command run by su or sudo may take if Become.Timeout isn't set
+const defaultBecomeTimeout = 60 * time.Second
+
+// sudoPrompt is set by sudo -p, so the password is sent only when sudo asks for it
+const sudoPrompt = "[paas-operator] sudo password:"
+
+// suPrompt is the prompt of su in the C locale, a localized one would never be matched
+const suPrompt = "Password:"
+
+// inputReady is printed by a command of ExecAsWithInput when it's ready to read its input
+const inputReady = "[paas-operator] input:"
+
+// Become describes how a command is run as another user. The password is written to the
+// prompt of su or sudo, it never appears in a command line or a remote file.
+type Become struct {
+	// Method is su or sudo, su by default
+	Method string
+	// User is who runs the command
+	User string
+	// Password is the password of User for su, or the password of the ssh user for sudo;
+	// it may be empty for passwordless sudo
+	Password string
+	// Timeout is how long the command may take with the password prompt, 60s by default;
+	// the session is closed after it, eg. when a wrong password is asked again
+	Timeout time.Duration
+}
+
+// ExecAs runs cmd as b.User and return its output, an error is returned if the command
+// exits with non-zero or escalation failed, see ExitCode.
+func (s *SSHClient) ExecAs(cmd string, b Become) (string, error) {
+	if b.User == "" || b.User == s.Username {
+		return s.ExecCmd(cmd)
+	}
+
+	full, prompt, err := becomeCmd(cmd, b)
+	if err != nil {
+		return "", err
+	}
+	if prompt == "" {
+		return s.ExecCmd(full)
+	}
+	return s.execWithPrompt(full, prompt, b.Password, nil, b.timeout())
+}
+
+// ExecAsWithInput runs cmd as b.User like ExecAs, with input in a file only b.User can read.
+// The path of the file is $INPUT_FILE in cmd, and it's removed when cmd exits. input is sent over
+// the session after escalation, so it never appears in a command line or a file others can read.
+func (s *SSHClient) ExecAsWithInput(cmd string, b Become, input []byte) (string, error) {
+	cmd, encoded := inputCmd(cmd), encodeInput(input)
+	if b.User == "" || b.User == s.Username {
+		return s.execWithInput(cmd, encoded)
+	}
+	full, prompt, err := becomeCmd(cmd, b)
+	if err != nil {
+		return "", err
+	}
+	if prompt == "" {
+		return s.execWithInput(full, encoded)
+	}
+	return s.execWithPrompt(full, prompt, b.Password, encoded, b.timeout())
+}
+
+// encodeInput return input as base64 lines ended by an empty line, nothing in them is special to a terminal
+func encodeInput(input []byte) []byte {
+	var encoded bytes.Buffer
+	b64 := base64.StdEncoding.EncodeToString(input)
+	for len(b64) > 76 {
+		encoded.WriteString(b64[:76] + "\n")
+		b64 = b64[76:]
+	}
+	encoded.WriteString(b64 + "\n\n")
+	return encoded.Bytes()
+}
+
+// inputCmd return the command reading the input of ExecAsWithInput into $INPUT_FILE before running cmd
+func inputCmd(cmd string) string {
+	return fmt.Sprintf(`umask 077 && INPUT_FILE=$(mktemp) && trap 'rm -f "$INPUT_FILE"' EXIT && printf '%%s\n' %s && `+
+		`while IFS= read -r l && [ -n "$l" ]; do printf '%%s\n' "$l"; done | base64 -d > "$INPUT_FILE" && export INPUT_FILE && sh -c %s`,
+		ShellQuote(inputReady), ShellQuote(cmd))
+}
+
+// execWithInput runs cmd as the ssh user with input as its stdin, and return its output after inputReady
+func (s *SSHClient) execWithInput(cmd string, input []byte) (string, error) {
+	session, err := s.Cli.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	session.Stdin = bytes.NewReader(input)
+	buf, err := session.CombinedOutput(cmd)
+	result := string(buf)
+	if i := strings.Index(result, inputReady); i >= 0 {
+		result = strings.TrimSpace(result[i+len(inputReady):])
+	}
+	s.LastResult = result
+	if err != nil {
+		return result, &execError{err: err, output: result}
+	}
+	return result, nil
+}
+
+func (b Become) timeout() time.Duration {
+	if b.Timeout <= 0 {
+		return defaultBecomeTimeout
+	}
+	return b.Timeout
+}
+
+// becomeCmd return the command running cmd as b.User, and the password prompt to answer,
+// "" if no password is asked
+func becomeCmd(cmd string, b Become) (string, string, error) {
+	switch b.Method {
+	case "", BecomeSu:
+		// su has no option for its prompt, LC_ALL=C keeps it in english
+		return fmt.Sprintf("LC_ALL=C su %s -c %s", ShellQuote(b.User), ShellQuote(cmd)), suPrompt, nil
+	case BecomeSudo:
+		// -n fails instead of asking if a password is needed but not given
+		if b.Password == "" {
+			return fmt.Sprintf("sudo -n -u %s sh -c %s", ShellQuote(b.User), ShellQuote(cmd)), "", nil
+		}
+		// -S reads the password from stdin, -p replaces the prompt whatever the locale or sudoers say
+		return fmt.Sprintf("sudo -S -p %s -u %s sh -c %s", ShellQuote(sudoPrompt), ShellQuote(b.User), ShellQuote(cmd)), sudoPrompt, nil
+	}
+	return "", "", fmt.Errorf("become method <%s> is illegal, expect su or sudo", b.Method)
+}
+
+// execWithPrompt runs cmd in a pty, and answers password when prompt appears in the output.
+// su reads the password from the terminal only, so a pty is needed. If input isn't nil, it's written
+// when inputReady appears, after su or sudo read the password. The command is killed after timeout.
+func (s *SSHClient) execWithPrompt(cmd, prompt, password string, input []byte, timeout time.Duration) (string, error) {
+	session, err := s.Cli.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0, // the password isn't echoed into the output
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm", 40, 200, modes); err != nil {
+		return "", err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	out := newPromptWriter(prompt, input != nil)
+	// a pty merges stderr into stdout
+	session.Stdout = out
+	session.Stderr = out
+
+	if err := session.Start(cmd); err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	prompted, ready := out.prompted, out.ready
+	promptDeadline, deadline := time.After(promptTimeout), time.After(timeout)
+
+wait:
+	for {
+		select {
+		case <-prompted:
+			if _, err := io.WriteString(stdin, password+"\n"); err != nil {
+				return out.String(), err
+			}
+			prompted, promptDeadline = nil, nil
+		case <-ready:
+			// no password asked if ready comes first, eg. the ssh user is root
+			if _, err := stdin.Write(input); err != nil {
+				return out.String(), err
+			}
+			ready, promptDeadline = nil, nil
+		case err = <-done:
+			break wait
+		case <-promptDeadline:
+			session.Signal(ssh.SIGKILL)
+			return out.String(), errors.New("timeout waiting for the password prompt")
+		case <-deadline:
+			// eg. a wrong password asked again, or a sudo in the command asking for its own
+			session.Signal(ssh.SIGKILL)
+			return out.String(), fmt.Errorf("command isn't done in %s", timeout)
+		}
+	}
+
+	// a pty ends lines with \r\n
+	result := strings.TrimSpace(strings.Replace(out.afterPrompt(), "\r\n", "\n", -1))
+	s.LastResult = result
+	if err != nil {
+		return result, &execError{err: err, output: result}
+	}
+	return result, nil
+}
+
+// promptWriter collects output, and closes prompted when prompt is written the first time,
+// ready when inputReady is written if it waits for it
+type promptWriter struct {
+	lock      sync.Mutex
+	prompt    []byte
+	buf       bytes.Buffer
+	cut       int // the end of the prompt or inputReady in buf, output before it isn't the command's
+	prompted  chan struct{}
+	hasPrompt bool
+	ready     chan struct{}
+}
+
+func newPromptWriter(prompt string, waitReady bool) *promptWriter {
+	w := &promptWriter{prompt: []byte(prompt), prompted: make(chan struct{})}
+	if waitReady {
+		w.ready = make(chan struct{})
+	}
+	return w
+}
+
+func (w *promptWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf.Write(p)
+	if !w.hasPrompt {
+		if i := bytes.Index(w.buf.Bytes(), w.prompt); i >= 0 {
+			w.hasPrompt = true
+			w.cut = i + len(w.prompt)
+			close(w.prompted)
+		}
+	}
+	if w.ready != nil {
+		if i := bytes.Index(w.buf.Bytes(), []byte(inputReady)); i >= 0 {
+			w.cut = i + len(inputReady)
+			close(w.ready)
+			w.ready = nil
+		}
+	}
+	return len(p), nil
+}
+
+func (w *promptWriter) String() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.String()
+}
+
+// afterPrompt return the output after the prompt
+func (w *promptWriter) afterPrompt() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return string(w.buf.Bytes()[w.cut:])
+}
+
+// ShellQuote quotes s as a single argument of sh
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// ExitCode return the exit status of a remote command from the error returned by
+// ExecCmd or ExecAs, 0 if err is nil and -1 if the command didn't exit normally.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	for {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			return exitErr.ExitStatus()
+		}
+		wrapped, ok := err.(*execError)
+		if !ok {
+			return -1
+		}
+		err = wrapped.err
+	}
+}
+
+// execError keeps the error of a remote command with its output
+type execError struct {
+	err    error
+	output string
+}
+
+func (e *execError) Error() string {
+	return fmt.Sprintf("%s: %s", e.err, e.output)
+}
@@ -0,0 +1,234 @@
+package sshcli
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{"sh /tmp/agent/agent.sh", "echo 'it''s' \"$HOME\"; exit 3", ""} {
+		out, err := exec.Command("sh", "-c", "printf %s "+ShellQuote(s)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != s {
+			t.Errorf("expect <%s>, got <%s>", s, out)
+		}
+	}
+}
+
+func TestPromptWriter(t *testing.T) {
+	w := newPromptWriter(suPrompt, false)
+	w.Write([]byte("Pass"))
+	select {
+	case <-w.prompted:
+		t.Fatal("expect no prompt before it's complete")
+	default:
+	}
+	w.Write([]byte("word: "))
+	select {
+	case <-w.prompted:
+	default:
+		t.Fatal("expect prompted")
+	}
+	w.Write([]byte("\r\nv0.1.0\r\n"))
+	if got := w.afterPrompt(); got != " \r\nv0.1.0\r\n" {
+		t.Errorf("unexpected output after prompt <%q>", got)
+	}
+}
+
+func TestPromptWriterReady(t *testing.T) {
+	w := newPromptWriter(suPrompt, true)
+	ready := w.ready
+	w.Write([]byte("Password: \r\n"))
+	w.Write([]byte(inputReady + "\r\nok\r\n"))
+	select {
+	case <-ready:
+	default:
+		t.Fatal("expect ready")
+	}
+	if got := w.afterPrompt(); got != "\r\nok\r\n" {
+		t.Errorf("expect output after the input is ready, got <%q>", got)
+	}
+}
+
+func TestBecomeCmd(t *testing.T) {
+	cases := []struct {
+		b      Become
+		prefix string
+		prompt string
+	}{
+		{Become{User: "mysql", Password: "p"}, "LC_ALL=C su 'mysql' -c ", suPrompt},
+		{Become{Method: BecomeSudo, User: "mysql", Password: "p"}, "sudo -S -p '" + sudoPrompt + "' -u 'mysql' ", sudoPrompt},
+		{Become{Method: BecomeSudo, User: "mysql"}, "sudo -n -u 'mysql' ", ""},
+	}
+	for _, c := range cases {
+		full, prompt, err := becomeCmd("id", c.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(full, c.prefix) || prompt != c.prompt {
+			t.Errorf("become %+v: expect <%s...> with prompt <%s>, got <%s> with <%s>", c.b, c.prefix, c.prompt, full, prompt)
+		}
+	}
+	if _, _, err := becomeCmd("id", Become{Method: "doas", User: "mysql"}); err == nil {
+		t.Error("expect an error of become method doas")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if code := ExitCode(nil); code != 0 {
+		t.Errorf("expect 0, got %d", code)
+	}
+	if code := ExitCode(&execError{err: errors.New("connection lost")}); code != -1 {
+		t.Errorf("expect -1, got %d", code)
+	}
+}
+
+// serveExec is an ssh server running handle for every command, handle return the exit status
+func serveExec(t *testing.T, handle func(ch ssh.Channel, cmd string) int) (string, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ssh.NewSignerFromKey(key)
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChan := range chans {
+					ch, reqs, err := newChan.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						for req := range reqs {
+							req.Reply(req.Type == "pty-req" || req.Type == "exec", nil)
+							if req.Type != "exec" {
+								continue
+							}
+							var exec struct{ Command string }
+							ssh.Unmarshal(req.Payload, &exec)
+							go func() {
+								status := handle(ch, exec.Command)
+								ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+								ch.Close()
+							}()
+						}
+					}()
+				}
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port, func() { l.Close() }
+}
+
+func TestExecAsTimeout(t *testing.T) {
+	// su given a wrong password asks again and again
+	port, stop := serveExec(t, func(ch ssh.Channel, cmd string) int {
+		r := bufio.NewReader(ch)
+		for {
+			io.WriteString(ch, suPrompt+" ")
+			if _, err := r.ReadString('\n'); err != nil {
+				return 1
+			}
+			io.WriteString(ch, "\r\nsu: Authentication failure\r\n")
+		}
+	})
+	defer stop()
+	s := New("127.0.0.1", "ops", "", port)
+	if err := s.ValidateConn(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cli.Close()
+
+	start := time.Now()
+	_, err := s.ExecAs("id", Become{User: "mysql", Password: "wrong", Timeout: 200 * time.Millisecond})
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("expect the command killed after its timeout, got %v in %s", err, time.Since(start))
+	}
+}
+
+func TestExecAsWithInput(t *testing.T) {
+	// a fake su asking for the password before running the command
+	dir, err := ioutil.TempDir("", "sshcli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	su := "#!/bin/sh\nprintf 'Password: '\nread -r p\n[ \"$p\" = secret ] || exit 1\nexec sh -c \"$3\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "su"), []byte(su), 0755); err != nil {
+		t.Fatal(err)
+	}
+	port, stop := serveExec(t, func(ch ssh.Channel, cmd string) int {
+		c := exec.Command("sh", "-c", cmd)
+		c.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"))
+		c.Stdout, c.Stderr = ch, ch
+		// the command ends without waiting for the end of stdin, like sshd
+		stdin, _ := c.StdinPipe()
+		go io.Copy(stdin, ch)
+		if err := c.Run(); err != nil {
+			return 1
+		}
+		return 0
+	})
+	defer stop()
+	s := New("127.0.0.1", "ops", "", port)
+	if err := s.ValidateConn(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cli.Close()
+
+	input := []byte("DB_PASSWORD='secret'\n")
+	for _, b := range []Become{{User: "mysql", Password: "secret"}, {}} {
+		out, err := s.ExecAsWithInput(`. "$INPUT_FILE" && echo "$DB_PASSWORD"`, b, input)
+		if err != nil || out != "secret" {
+			t.Errorf("become %+v: expect the input read by the command, got <%s>, %v", b, out, err)
+		}
+	}
+}
+
+func TestInputCmd(t *testing.T) {
+	input := []byte("DB_PASSWORD='p@ss\nword'\n" + strings.Repeat("x", 200) + "\n")
+	cmd := exec.Command("sh", "-c", inputCmd(`stat -c %a "$INPUT_FILE" && cat "$INPUT_FILE"`))
+	cmd.Stdin = bytes.NewReader(encodeInput(input))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	expect := inputReady + "\n600\n" + string(input)
+	if string(out) != expect {
+		t.Errorf("expect the input in a private file, got <%s>", out)
+	}
+}
@@ -17,6 +17,20 @@ type SSHClient struct {
 	Password   string
 	Cli        *ssh.Client
 	LastResult string
+	// Dial connects to the host instead of a direct tcp connection if it's set, eg. through a relay
+	Dial func(network, addr string) (net.Conn, error)
+	// Jumps are the bastions to the host in order, the first one is connected by Dial
+	Jumps []JumpHost
+	// Network is how the host is connected: tcp (default), tcp4 or tcp6
+	Network string
+}
+
+// JumpHost is a bastion with its own credentials, the next hop is connected by a tcp forward of it
+type JumpHost struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
 }
 
 func New(ip, username, password, port string) *SSHClient {
@@ -28,35 +42,104 @@ func New(ip, username, password, port string) *SSHClient {
 	}
 }
 
-func (s *SSHClient) ValidateConn() error {
+func (s *SSHClient) ValidateConn() (err error) {
+	network := s.Network
+	if network == "" {
+		network = "tcp"
+	}
+	dial := s.Dial
+	if dial == nil {
+		dial = func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, sshTimeout)
+		}
+	}
+
+	// the jump hosts opened are closed from the last one if the chain can't be completed,
+	// else each one is closed with the connection it forwards, see jumpConn
+	var jumps []*ssh.Client
+	defer func() {
+		if err != nil {
+			for i := len(jumps) - 1; i >= 0; i-- {
+				jumps[i].Close()
+			}
+		}
+	}()
+
+	// every jump host forwards the connection to the next hop
+	for _, jump := range s.Jumps {
+		client, err := dialSSH(dial, network, jump.Host, jump.Port, jump.Username, jump.Password)
+		if err != nil {
+			return fmt.Errorf("jump host <%s>: %s", jump.Host, err)
+		}
+		jumps = append(jumps, client)
+		dial = func(network, addr string) (net.Conn, error) {
+			conn, err := client.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &jumpConn{Conn: conn, jump: client}, nil
+		}
+	}
+
+	client, err := dialSSH(dial, network, s.Host, s.Port, s.Username, s.Password)
+	if err != nil {
+		return err
+	}
+	s.Cli = client
+	return nil
+}
+
+const sshTimeout = 10 * time.Second
+
+func dialSSH(dial func(network, addr string) (net.Conn, error), network, host, port, username, password string) (*ssh.Client, error) {
 	var (
 		auth   []ssh.AuthMethod
 		addr   string
 		cliCfg *ssh.ClientConfig
-		err    error
-		client *ssh.Client
 	)
 
 	// auth
 	auth = make([]ssh.AuthMethod, 0)
-	auth = append(auth, ssh.Password(s.Password))
+	auth = append(auth, ssh.Password(password))
 
 	cliCfg = &ssh.ClientConfig{
-		User:    s.Username,
+		User:    username,
 		Auth:    auth,
-		Timeout: 10 * time.Second,
+		Timeout: sshTimeout,
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 			return nil
 		},
 	}
 
 	// connect
-	addr = fmt.Sprintf("%s:%s", s.Host, s.Port)
-	if client, err = ssh.Dial("tcp", addr, cliCfg); err != nil {
-		return err
+	if port == "" {
+		port = "22"
 	}
-	s.Cli = client
-	return nil
+	addr = net.JoinHostPort(host, port)
+	conn, err := dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(cliCfg.Timeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cliCfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// jumpConn is a connection forwarded by a jump host, the jump host is closed with it
+type jumpConn struct {
+	net.Conn
+	jump *ssh.Client
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	c.jump.Close()
+	return err
 }
 
 func (s *SSHClient) UploadFile(localFilePath, remoteFilePath string) error {
@@ -85,6 +168,8 @@ func (s *SSHClient) UploadFile(localFilePath, remoteFilePath string) error {
 	return nil
 }
 
+// ExecCmd runs cmd as the ssh user and return its output, an error is returned if the
+// command exits with non-zero, see ExitCode.
 func (s *SSHClient) ExecCmd(cmd string) (string, error) {
 	session, err := s.Cli.NewSession()
 	if err != nil {
@@ -93,10 +178,9 @@ func (s *SSHClient) ExecCmd(cmd string) (string, error) {
 	defer session.Close()
 
 	buf, err := session.CombinedOutput(cmd)
+	s.LastResult = string(buf)
 	if err != nil {
-		return "", nil
+		return s.LastResult, &execError{err: err, output: s.LastResult}
 	}
-
-	s.LastResult = string(buf)
 	return s.LastResult, nil
 }
@@ -0,0 +1,148 @@
+package sshcli
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an ssh server accepting a password and forwarding tcp
+type testServer struct {
+	port string
+	// accepted and closed receive a value per connection accepted and closed by the client
+	accepted chan struct{}
+	closed   chan struct{}
+	stop     func()
+}
+
+func serveSSH(t *testing.T, password string) *testServer {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ssh.NewSignerFromKey(key)
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) != password {
+				return nil, errors.New("password is wrong")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, closed := make(chan struct{}, 10), make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				accepted <- struct{}{}
+				defer func() { closed <- struct{}{} }()
+				go ssh.DiscardRequests(reqs)
+				for newChan := range chans {
+					if newChan.ChannelType() != "direct-tcpip" {
+						newChan.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+						continue
+					}
+					var target struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					ssh.Unmarshal(newChan.ExtraData(), &target)
+					next, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						newChan.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, reqs, _ := newChan.Accept()
+					go ssh.DiscardRequests(reqs)
+					go func() {
+						io.Copy(next, ch)
+						next.Close()
+					}()
+					go func() {
+						io.Copy(ch, next)
+						ch.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return &testServer{port: port, accepted: accepted, closed: closed, stop: func() { l.Close() }}
+}
+
+func TestValidateConnThroughJumps(t *testing.T) {
+	jump1 := serveSSH(t, "jump1")
+	defer jump1.stop()
+	jump2 := serveSSH(t, "jump2")
+	defer jump2.stop()
+	target := serveSSH(t, "target")
+	defer target.stop()
+
+	s := New("127.0.0.1", "root", "target", target.port)
+	s.Network = "tcp4"
+	s.Jumps = []JumpHost{
+		{Host: "127.0.0.1", Port: jump1.port, Username: "ops", Password: "jump1"},
+		{Host: "127.0.0.1", Port: jump2.port, Username: "ops", Password: "jump2"},
+	}
+	if err := s.ValidateConn(); err != nil {
+		t.Fatal(err)
+	}
+	s.Cli.Close()
+	if len(jump1.accepted) != 1 || len(jump2.accepted) != 1 {
+		t.Errorf("expect the connection through both jump hosts")
+	}
+
+	s.Jumps[1].Password = "wrong"
+	if err := s.ValidateConn(); err == nil {
+		t.Error("expect a wrong password of a jump host refused")
+	}
+}
+
+func TestValidateConnClosesJumpsOnFailure(t *testing.T) {
+	jump := serveSSH(t, "jump")
+	defer jump.stop()
+	// nothing listens on the port of the next hop
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closedPort, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	s := New("127.0.0.1", "root", "target", "22")
+	s.Jumps = []JumpHost{
+		{Host: "127.0.0.1", Port: jump.port, Username: "ops", Password: "jump"},
+		{Host: "127.0.0.1", Port: closedPort, Username: "ops", Password: "jump"},
+	}
+	if err := s.ValidateConn(); err == nil {
+		t.Fatal("expect an unreachable jump host failed")
+	}
+	select {
+	case <-jump.closed:
+	case <-time.After(5 * time.Second):
+		t.Error("expect the connection to the first jump host closed")
+	}
+}
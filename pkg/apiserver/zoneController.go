@@ -0,0 +1,88 @@
+package apiserver
+
+import (
+	"fmt"
+
+	"github.com/kataras/iris"
+
+	"github.com/farmer-hutao/paas-operator/pkg/apiserver/application"
+)
+
+// ListZones return all zones, without their secrets
+func ListZones(ctx iris.Context) {
+	zones := application.GetETCDZones().List(ctx)
+	if zones == nil {
+		zones = []*application.Zone{}
+	}
+	for _, zone := range zones {
+		redactZone(zone)
+	}
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(zones)
+}
+
+// SaveZone adds or updates the zone with the name, hosts with the zone are reached by its relay
+func SaveZone(ctx iris.Context) {
+	name := ctx.Params().GetString("name")
+	var zone application.Zone
+	if err := ctx.ReadJSON(&zone); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		ctx.Application().Logger().Errorf("SaveZone Error, json is illegal: %s", err)
+		return
+	}
+	if zone.Name == "" {
+		zone.Name = name
+	}
+	if zone.Name != name {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(fmt.Sprintf("zone name <%s> isn't the one in the url <%s>", zone.Name, name))
+		return
+	}
+	if err := zone.Validate(); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+		return
+	}
+	if err := application.GetETCDZones().Save(&zone, ctx); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	redactZone(&zone)
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(zone)
+}
+
+// GetZone return the zone with the name, without its secrets
+func GetZone(ctx iris.Context) {
+	name := ctx.Params().GetString("name")
+	zone, ok := application.GetETCDZones().Get(name, ctx)
+	if !ok {
+		ctx.StatusCode(iris.StatusNotFound)
+		ctx.WriteString(fmt.Sprintf("zone <%s> is not exist", name))
+		return
+	}
+	redactZone(zone)
+	ctx.StatusCode(iris.StatusOK)
+	ctx.JSON(zone)
+}
+
+// DeleteZone forgets a zone, hosts with it can't be reached until it's added again
+func DeleteZone(ctx iris.Context) {
+	name := ctx.Params().GetString("name")
+	if err := application.GetETCDZones().Delete(name, ctx); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		ctx.WriteString("got some error")
+		return
+	}
+	ctx.StatusCode(iris.StatusOK)
+}
+
+// redactZone removes the token and the passwords of jump hosts of the zone before it's returned
+func redactZone(zone *application.Zone) {
+	zone.Token = ""
+	for i := range zone.Jumps {
+		zone.Jumps[i].Password = ""
+	}
+}
@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DialConnect opens a connection to addr through the http proxy at proxyAddr by CONNECT,
+// token is sent as a bearer Proxy-Authorization if it isn't empty.
+func DialConnect(proxyAddr, token, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("CONNECT", "http://"+addr, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Host = addr
+	if token != "" {
+		req.Header.Set("Proxy-Authorization", "Bearer "+token)
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("connect to <%s> through <%s> got %s", addr, proxyAddr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	// the proxy may have sent data of addr after its response, eg. the banner of sshd
+	return &bufferedConn{Conn: conn, br: br}, nil
+}
+
+// bufferedConn reads what's left in br before reading the connection
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
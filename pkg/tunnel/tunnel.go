@@ -0,0 +1,226 @@
+// Package tunnel multiplexes http requests in both directions and log lines over one websocket,
+// so an agent which can't accept connections is reached by the connection it opened.
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PingInterval is how often each side pings, a tunnel without any frame in 3 intervals is dead
+var PingInterval = 15 * time.Second
+
+// Types of messages
+const (
+	TypeRequest  = "request"
+	TypeResponse = "response"
+	TypeLog      = "log"
+)
+
+// ErrClosed is returned by Do if the tunnel closed before the response came
+var ErrClosed = errors.New("tunnel is closed")
+
+// Message is sent as a text frame in json. A request is answered by a response with the same id,
+// ids are unique only among requests of the same side.
+type Message struct {
+	ID     string `json:"id,omitempty"`
+	Type   string `json:"type"`
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Tunnel serves requests of the peer by a http.Handler, and sends requests to the peer by Do.
+type Tunnel struct {
+	conn    *Conn
+	handler http.Handler
+	// OnLog is called with every log line sent by the peer, nil to drop them
+	OnLog func(line string)
+
+	lock    sync.Mutex
+	nextID  uint64
+	pending map[string]chan *Message
+	done    chan struct{}
+}
+
+// New return a tunnel over the connection, requests of the peer are served by handler
+func New(conn *Conn, handler http.Handler) *Tunnel {
+	return &Tunnel{
+		conn:    conn,
+		handler: handler,
+		pending: map[string]chan *Message{},
+		done:    make(chan struct{}),
+	}
+}
+
+// Run reads messages until the tunnel is closed or dead, and return why.
+func (t *Tunnel) Run() error {
+	defer t.Close()
+	t.conn.SetReadTimeout(3 * PingInterval)
+	go t.keepalive()
+
+	for {
+		op, data, err := t.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if op != OpText {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("illegal message in tunnel: %s", err)
+		}
+
+		switch msg.Type {
+		case TypeRequest:
+			go t.serve(&msg)
+		case TypeResponse:
+			t.lock.Lock()
+			ch, ok := t.pending[msg.ID]
+			delete(t.pending, msg.ID)
+			t.lock.Unlock()
+			if ok {
+				ch <- &msg
+			}
+		case TypeLog:
+			if t.OnLog != nil {
+				t.OnLog(msg.Body)
+			}
+		}
+	}
+}
+
+func (t *Tunnel) keepalive() {
+	ticker := time.NewTicker(PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			if err := t.conn.Ping(); err != nil {
+				t.Close()
+				return
+			}
+		}
+	}
+}
+
+func (t *Tunnel) send(msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// a frame partly written breaks the stream, and later writes needn't wait for a peer not reading
+	if err := t.conn.WriteMessage(OpText, data); err != nil {
+		t.Close()
+		return err
+	}
+	return nil
+}
+
+// serve runs the request of the peer by the handler and sends back the response
+func (t *Tunnel) serve(msg *Message) {
+	resp := &Message{ID: msg.ID, Type: TypeResponse}
+	req, err := http.NewRequest(msg.Method, msg.Path, bytes.NewBufferString(msg.Body))
+	if err != nil {
+		resp.Status = http.StatusBadRequest
+		resp.Body = err.Error()
+	} else {
+		req.Header.Set("Content-Type", "application/json;charset=utf-8")
+		rec := newRecorder()
+		t.handler.ServeHTTP(rec, req)
+		resp.Status = rec.status
+		resp.Body = rec.body.String()
+	}
+	t.send(resp)
+}
+
+// Do sends a request to the peer and waits for its response, timeout 0 waits until the tunnel closes.
+func (t *Tunnel) Do(method, path string, body []byte, timeout time.Duration) (int, []byte, error) {
+	ch := make(chan *Message, 1)
+	t.lock.Lock()
+	t.nextID++
+	id := strconv.FormatUint(t.nextID, 10)
+	t.pending[id] = ch
+	t.lock.Unlock()
+	defer func() {
+		t.lock.Lock()
+		delete(t.pending, id)
+		t.lock.Unlock()
+	}()
+
+	if err := t.send(&Message{ID: id, Type: TypeRequest, Method: method, Path: path, Body: string(body)}); err != nil {
+		return 0, nil, err
+	}
+
+	var expire <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expire = timer.C
+	}
+	select {
+	case resp := <-ch:
+		return resp.Status, []byte(resp.Body), nil
+	case <-t.done:
+		return 0, nil, ErrClosed
+	case <-expire:
+		return 0, nil, fmt.Errorf("%s %s through tunnel timeout after %s", method, path, timeout)
+	}
+}
+
+// Log sends a log line to the peer
+func (t *Tunnel) Log(line string) error {
+	return t.send(&Message{Type: TypeLog, Body: line})
+}
+
+// Done is closed when the tunnel is closed
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.done
+}
+
+// Close closes the tunnel, requests waiting for responses return ErrClosed
+func (t *Tunnel) Close() error {
+	t.lock.Lock()
+	select {
+	case <-t.done:
+		t.lock.Unlock()
+		return nil
+	default:
+		close(t.done)
+	}
+	t.lock.Unlock()
+	return t.conn.Close()
+}
+
+// recorder keeps the response of a handler
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: http.Header{}, status: http.StatusOK}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	return r.body.Write(p)
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+}
@@ -0,0 +1,104 @@
+package tunnel
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func echoHandler(who string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte(who + " " + r.Method + " " + r.URL.Path + " " + string(body)))
+	})
+}
+
+func TestTunnel(t *testing.T) {
+	serverSide := make(chan *Tunnel, 1)
+	logs := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		tun := New(conn, echoHandler("apiserver"))
+		tun.OnLog = func(line string) { logs <- line }
+		serverSide <- tun
+		tun.Run()
+	}))
+	defer server.Close()
+
+	conn, err := Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/tunnel", nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentSide := New(conn, echoHandler("agent"))
+	go agentSide.Run()
+	defer agentSide.Close()
+	apiserverSide := <-serverSide
+
+	// requests go both ways over the same connection
+	status, body, err := apiserverSide.Do("POST", "/install", []byte(`{"name":"mysql"}`), 5*time.Second)
+	if err != nil || status != http.StatusOK || string(body) != `agent POST /install {"name":"mysql"}` {
+		t.Errorf("unexpected response from agent: %d %q %v", status, body, err)
+	}
+	// a large body is split into extended frames
+	large := strings.Repeat("x", 70000)
+	status, body, err = agentSide.Do("PUT", "/missing", []byte(large), 5*time.Second)
+	if err != nil || status != http.StatusNotFound || string(body) != "apiserver PUT /missing "+large {
+		t.Errorf("unexpected response from apiserver: %d %d %v", status, len(body), err)
+	}
+
+	if err := agentSide.Log("install.sh started"); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-logs; line != "install.sh started" {
+		t.Errorf("expect the log line, got %q", line)
+	}
+
+	// the apiserver sees the agent gone at once
+	agentSide.Close()
+	select {
+	case <-apiserverSide.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the tunnel closed on the apiserver")
+	}
+	if _, _, err := apiserverSide.Do("POST", "/start", nil, time.Second); err == nil {
+		t.Error("expect an error through a closed tunnel")
+	}
+}
+
+func TestCloseWithBlockedWriter(t *testing.T) {
+	// the peer never reads
+	local, peer := net.Pipe()
+	defer peer.Close()
+	c := newConn(local, bufio.NewReader(local), true)
+
+	written := make(chan error, 1)
+	go func() { written <- c.WriteMessage(OpText, []byte("log line")) }()
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expect Close not blocked by the writer")
+	}
+	select {
+	case err := <-written:
+		if err == nil {
+			t.Error("expect the blocked write failed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expect the blocked writer released by Close")
+	}
+}